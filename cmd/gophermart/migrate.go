@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gophermart/internal/sql"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	migrateCommand = "migrate"
+	migrateTimeout = time.Minute * 10
+)
+
+var ErrBadMigrateCommand = errors.New("usage: gophermart migrate [-d database_uri] up | down [steps] | status")
+
+// runMigrate applies, rolls back or shows migrations without starting the server,
+// database uri is taken from -d flag or DATABASE_URI like for the server
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+	databaseURI := flags.String("d", os.Getenv("DATABASE_URI"), "Database uri")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *databaseURI == "" || flags.NArg() == 0 {
+		return ErrBadMigrateCommand
+	}
+
+	migrator, err := sql.OpenMigrator(*databaseURI)
+	if err != nil {
+		return fmt.Errorf("open migrator, err=%w", err)
+	}
+	defer func() {
+		_ = migrator.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch flags.Arg(0) {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		printMigrations("applied", applied)
+
+		return err
+	case "down":
+		steps := 1
+		if flags.NArg() > 1 {
+			if steps, err = strconv.Atoi(flags.Arg(1)); err != nil {
+				return errors.Join(ErrBadMigrateCommand, err)
+			}
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		printMigrations("rolled back", rolledBack)
+
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d %-40s %s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return nil
+	default:
+		return ErrBadMigrateCommand
+	}
+}
+
+func printMigrations(action string, migrations []*sql.Migration) {
+	if len(migrations) == 0 {
+		fmt.Printf("nothing is %s\n", action)
+		return
+	}
+
+	for _, migration := range migrations {
+		fmt.Printf("%s %04d %s\n", action, migration.Version, migration.Name)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gophermart/internal/sql"
+	"os"
+	"time"
+)
+
+const (
+	reconcileCommand = "reconcile"
+	reconcileTimeout = time.Minute * 10
+)
+
+var (
+	ErrBadReconcileCommand  = errors.New("usage: gophermart reconcile [-d database_uri]")
+	ErrLedgerIsInconsistent = errors.New("ledger is inconsistent")
+)
+
+// runReconcile compares balances of users with the ledger and fails if they differ,
+// so it can be run by cron or a monitoring check
+func runReconcile(args []string) error {
+	flags := flag.NewFlagSet(reconcileCommand, flag.ContinueOnError)
+	databaseURI := flags.String("d", os.Getenv("DATABASE_URI"), "Database uri")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *databaseURI == "" || flags.NArg() != 0 {
+		return ErrBadReconcileCommand
+	}
+
+	ctrl, err := sql.StartNewController(*databaseURI, sql.PoolSettings{MaxConns: 1})
+	if err != nil {
+		return fmt.Errorf("start sql controller, err=%w", err)
+	}
+	defer func() {
+		_ = ctrl.Stop()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+	defer cancel()
+
+	result, err := ctrl.ReconcileLedger(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, transaction := range result.UnbalancedTransactions {
+		fmt.Printf("unbalanced transaction=%d sum=%s\n", transaction.TransactionID, transaction.Sum)
+	}
+
+	for _, mismatch := range result.BalanceMismatches {
+		fmt.Printf("user=%s balance=%s ledger=%s\n", mismatch.Login, mismatch.Balance, mismatch.LedgerBalance)
+	}
+
+	if !result.IsConsistent() {
+		return ErrLedgerIsInconsistent
+	}
+
+	fmt.Println("ledger is consistent")
+
+	return nil
+}
@@ -9,6 +9,7 @@ require (
 	github.com/jackc/pgx/v5 v5.5.1
 	github.com/stretchr/testify v1.8.1
 	go.uber.org/zap v1.26.0
+	golang.org/x/crypto v0.9.0
 )
 
 require (
@@ -19,8 +20,8 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.11.0 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
-	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
+	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
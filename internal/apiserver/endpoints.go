@@ -7,6 +7,53 @@ const (
 	// POST - user autentification
 	loginEndpoint = "/api/user/login"
 
+	// POST - completing login by totp or recovery code for users with the second factor
+	secondFactorLoginEndpoint = "/api/user/login/2fa"
+
+	// POST - generating totp secret and otpauth uri
+	totpEnrollEndpoint = "/api/user/2fa/enroll"
+
+	// POST - enabling the second factor by the first code, returns recovery codes
+	totpConfirmEndpoint = "/api/user/2fa/confirm"
+
+	// POST - disabling the second factor by totp or recovery code
+	totpDisableEndpoint = "/api/user/2fa/disable"
+
+	// POST - exchanging refresh token for new access and refresh tokens (jwt auth mode only)
+	refreshEndpoint = "/api/user/token/refresh"
+
+	// POST - changing password of authorized user
+	passwordEndpoint = "/api/user/password"
+
+	// POST - sending password reset token to user
+	passwordResetRequestEndpoint = "/api/user/password/reset/request"
+
+	// POST - setting new password by reset token
+	passwordResetEndpoint = "/api/user/password/reset"
+
+	// POST - closing current user's session
+	logoutEndpoint = "/api/user/logout"
+
+	// GET - list of user's sessions
+	// DELETE - closing all user's sessions except the current one
+	sessionsEndpoint = "/api/user/sessions"
+
+	// DELETE - closing user's session by id
+	sessionEndpoint = "/api/user/sessions/{id}"
+
+	// DELETE - deleting user's account with all data, the password is required
+	accountEndpoint = "/api/user"
+
+	// GET - all user's data as json, or as zip with ?format=zip
+	exportEndpoint = "/api/user/export"
+
+	// GET - list of user's API keys
+	// POST - creating API key with scopes, the key is shown only in this response
+	apiKeysEndpoint = "/api/user/api-keys"
+
+	// DELETE - revoking user's API key by id
+	apiKeyEndpoint = "/api/user/api-keys/{id}"
+
 	// GET - getting all user's orders
 	// POST - download user's orders
 	ordersEndpoint = "/api/user/orders"
@@ -17,6 +64,50 @@ const (
 	// POST - user's loyality points withdraw
 	balanceWithdrawEndpoint = "/api/user/balance/withdraw"
 
+	// GET - manual adjustments of user's balance made by operators
+	balanceAdjustmentsEndpoint = "/api/user/balance/adjustments"
+
+	// GET - accruals, withdrawals and adjustments with the balance after each of them,
+	// filtered by type, from and to, paginated by limit and offset
+	balanceHistoryEndpoint = "/api/user/balance/history"
+
 	// GET - information about loyality withdrawals
 	allWithdrawalsEndpoint = "/api/user/withdrawals"
+
+	// GET - searching users by the part of login, query params: login, limit, offset
+	adminUsersEndpoint = "/api/admin/users"
+
+	// GET - user's profile with balance
+	adminUserEndpoint = "/api/admin/users/{login}"
+
+	// GET - user's orders
+	adminUserOrdersEndpoint = "/api/admin/users/{login}/orders"
+
+	// GET - user's withdrawals
+	adminUserWithdrawalsEndpoint = "/api/admin/users/{login}/withdrawals"
+
+	// POST - blocking user and closing all user's sessions
+	adminUserBlockEndpoint = "/api/admin/users/{login}/block"
+
+	// POST - unblocking user
+	adminUserUnblockEndpoint = "/api/admin/users/{login}/unblock"
+
+	// POST - closing all user's sessions
+	adminUserLogoutEndpoint = "/api/admin/users/{login}/logout"
+
+	// GET - manual adjustments of user's balance with operators
+	// POST - crediting (positive amount) or debiting (negative amount) user's balance with mandatory reason (admin only)
+	adminUserBalanceAdjustmentsEndpoint = "/api/admin/users/{login}/balance/adjustments"
+
+	// PUT - changing user's role (admin only)
+	adminUserRoleEndpoint = "/api/admin/users/{login}/role"
+
+	// GET - connections of the database pool and acquire counters (admin only)
+	adminDBPoolEndpoint = "/api/admin/db/pool"
+
+	// GET - orders which aren't checked in the accrual system anymore, query params: limit, offset (admin only)
+	adminStuckOrdersEndpoint = "/api/admin/orders/stuck"
+
+	// POST - returning the stuck order to the accrual queue with reset attempts (admin only)
+	adminOrderRetryEndpoint = "/api/admin/orders/{number}/retry"
 )
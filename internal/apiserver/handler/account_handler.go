@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/money"
+	"gophermart/internal/storage"
+	"gophermart/internal/zlog"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const exportFormatZip = "zip"
+
+var ErrDesirializeDeleteAccountRequest = errors.New("delete account request desirialization failed")
+
+type AccountDeleter interface {
+	AuthChecker
+	DeleteAccount(ctx context.Context, login string, password string, client *ClientInfo) error
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type ExportProfile struct {
+	Login        string       `json:"login"`
+	Role         string       `json:"role"`
+	Balance      money.Amount `json:"balance"`
+	Withdrawn    money.Amount `json:"withdrawn"`
+	SecondFactor bool         `json:"second_factor_enabled"`
+}
+
+// ExportResponse is the bundle of user's data, in zip format every section is a separate json file
+type ExportResponse struct {
+	ExportedAt         time.Time                      `json:"exported_at"`
+	Profile            *ExportProfile                 `json:"profile"`
+	Orders             []*OrderResponse               `json:"orders"`
+	Withdrawals        []*WithdrawalResponse          `json:"withdrawals"`
+	BalanceHistory     []*BalanceHistoryEntryResponse `json:"balance_history"`
+	BalanceAdjustments []*BalanceAdjustmentResponse   `json:"balance_adjustments"`
+}
+
+// ExportHandler returns all user's data as json or as zip archive with ?format=zip
+type ExportHandler struct {
+	authChecker AuthChecker
+	accounts    storage.Accounts
+}
+
+func NewExportHandler(authChecker AuthChecker, accounts storage.Accounts) *ExportHandler {
+	return &ExportHandler{
+		authChecker: authChecker,
+		accounts:    accounts,
+	}
+}
+
+func (h *ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Export handler")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	export, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Errorf("handle export, err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if r.URL.Query().Get("format") == exportFormatZip {
+		writeExportZip(w, export)
+		return
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		zlog.Logger.Errorf("marshal export of user=%s, err=%s", export.Profile.Login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.json"`)
+	writeJSON(w, data)
+}
+
+func (h *ExportHandler) handle(r *http.Request) (*ExportResponse, error) {
+	login, err := checkUserAuthorization(r, h.authChecker, sessionOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := h.accounts.ExportUserData(r.Context(), login)
+	if err != nil {
+		return nil, fmt.Errorf("export data of user=%s, err=%w", login, err)
+	}
+
+	adjustments := make([]*BalanceAdjustmentResponse, 0, len(data.BalanceAdjustments))
+	for _, adjustment := range data.BalanceAdjustments {
+		adjustments = append(adjustments, &BalanceAdjustmentResponse{
+			Amount:      adjustment.Amount,
+			Reason:      adjustment.Reason,
+			ProcessedAt: adjustment.CreatedAt,
+		})
+	}
+
+	return &ExportResponse{
+		ExportedAt: time.Now(),
+		Profile: &ExportProfile{
+			Login:        data.User.Login,
+			Role:         data.User.Role,
+			Balance:      data.User.Balance,
+			Withdrawn:    data.WithdrawalsTotalSum,
+			SecondFactor: data.SecondFactor,
+		},
+		Orders:             makeOrderResponses(data.Orders),
+		Withdrawals:        makeWithdrawalResponses(data.Withdrawals),
+		BalanceHistory:     makeBalanceHistoryResponses(data.BalanceHistory),
+		BalanceAdjustments: adjustments,
+	}, nil
+}
+
+func writeExportZip(w http.ResponseWriter, export *ExportResponse) {
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"orders.json", export.Orders},
+		{"withdrawals.json", export.Withdrawals},
+		{"balance_history.json", export.BalanceHistory},
+		{"balance_adjustments.json", export.BalanceAdjustments},
+	}
+
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+
+	for _, file := range files {
+		if err := writeZipJSON(archive, file.name, file.data, export.ExportedAt); err != nil {
+			zlog.Logger.Errorf("write %s of user=%s to zip, err=%s", file.name, export.Profile.Login, err)
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		zlog.Logger.Errorf("close zip of user=%s, err=%s", export.Profile.Login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="gophermart-export.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
+}
+
+func writeZipJSON(archive *zip.Writer, name string, value any, modified time.Time) error {
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(value)
+}
+
+// DeleteAccountHandler removes the authorized user after the password confirmation
+type DeleteAccountHandler struct {
+	deleter      AccountDeleter
+	secureCookie bool
+}
+
+func NewDeleteAccountHandler(deleter AccountDeleter, secureCookie bool) *DeleteAccountHandler {
+	return &DeleteAccountHandler{
+		deleter:      deleter,
+		secureCookie: secureCookie,
+	}
+}
+
+func (h *DeleteAccountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Delete account handler")
+
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.handle(r); err != nil {
+		zlog.Logger.Infof("Handle delete account was failed with err=%s", err)
+
+		var tooManyAttemptsErr *TooManyAttemptsError
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, ErrDesirializeDeleteAccountRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.As(err, &tooManyAttemptsErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttemptsErr.RetryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if errors.Is(err, ErrIsNotAutorized) {
+			// password is wrong
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	clearAuthCookies(w, h.secureCookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *DeleteAccountHandler) handle(r *http.Request) error {
+	login, err := checkUserAuthorization(r, h.deleter, sessionOnly)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("read from body err=%w", err)
+	}
+
+	req := &DeleteAccountRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return errors.Join(ErrDesirializeDeleteAccountRequest, err)
+	}
+
+	if req.Password == "" {
+		return ErrDesirializeDeleteAccountRequest
+	}
+
+	return h.deleter.DeleteAccount(r.Context(), login, req.Password, NewClientInfo(r))
+}
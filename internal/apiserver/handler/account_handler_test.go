@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticAuthChecker authorizes every request as the same user
+type staticAuthChecker string
+
+func (c staticAuthChecker) Check(_ context.Context, _ string) (string, error) {
+	return string(c), nil
+}
+
+func (c staticAuthChecker) CheckAPIKey(_ context.Context, _ string, _ rbac.Scope) (string, error) {
+	return string(c), nil
+}
+
+func TestExportContainsBalanceHistory(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	require.NoError(t, storage.CreateOrder(ctx, "user", "accrual-order"))
+	require.NoError(t, storage.UpdateAccrual(ctx, &sql.Order{
+		ID:      "accrual-order",
+		User:    "user",
+		Status:  sql.OrderStatusProcessed,
+		Accrual: money.New(30, 0),
+	}))
+	require.NoError(t, storage.Withdraw(ctx, "user", "withdrawal-order", money.New(12, 50), ""))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/user/export", nil)
+	r.Header.Set("Authorization", bearerPrefix+"token")
+	w := httptest.NewRecorder()
+
+	NewExportHandler(staticAuthChecker("user"), storage).ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var export ExportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	require.Len(t, export.BalanceHistory, 2)
+
+	assert.Equal(t, sql.LedgerEntryAccrual, export.BalanceHistory[0].Type)
+	assert.Equal(t, "accrual-order", export.BalanceHistory[0].Order)
+	assert.Equal(t, money.New(30, 0), export.BalanceHistory[0].Amount)
+	assert.Equal(t, money.New(30, 0), export.BalanceHistory[0].Balance)
+
+	assert.Equal(t, sql.LedgerEntryWithdrawal, export.BalanceHistory[1].Type)
+	assert.Equal(t, "withdrawal-order", export.BalanceHistory[1].Order)
+	assert.Equal(t, -money.New(12, 50), export.BalanceHistory[1].Amount)
+	assert.Equal(t, money.New(17, 50), export.BalanceHistory[1].Balance)
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"net/http"
+)
+
+type PoolStatistician interface {
+	PoolStats() *sql.PoolStats
+}
+
+// AdminDBPoolStatsHandler shows the state of the database connection pool for monitoring,
+// access is checked by the middleware
+type AdminDBPoolStatsHandler struct {
+	pool PoolStatistician
+}
+
+func NewAdminDBPoolStatsHandler(pool PoolStatistician) *AdminDBPoolStatsHandler {
+	return &AdminDBPoolStatsHandler{
+		pool: pool,
+	}
+}
+
+func (h *AdminDBPoolStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(h.pool.PoolStats())
+	if err != nil {
+		zlog.Logger.Errorf("marshal db pool stats err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, data)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"gophermart/internal/zlog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultStuckOrdersLimit = 50
+	maxStuckOrdersLimit     = 500
+)
+
+var ErrBadStuckOrdersRequest = errors.New("bad stuck orders request")
+
+type StuckOrderResponse struct {
+	Number      string `json:"number"`
+	User        string `json:"user"`
+	Status      string `json:"status"`
+	UploadedAt  string `json:"uploaded_at"`
+	Attempts    int    `json:"attempts"`
+	LastError   string `json:"last_error,omitempty"`
+	NextCheckAt string `json:"next_check_at"`
+	StuckAt     string `json:"stuck_at"`
+}
+
+// AdminStuckOrdersHandler lists the orders which aren't checked in the accrual system anymore,
+// access is checked by the middleware
+type AdminStuckOrdersHandler struct {
+	orders storage.StuckOrders
+}
+
+func NewAdminStuckOrdersHandler(orders storage.StuckOrders) *AdminStuckOrdersHandler {
+	return &AdminStuckOrdersHandler{
+		orders: orders,
+	}
+}
+
+func (h *AdminStuckOrdersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Errorf("Get stuck orders err=%s", err)
+
+		if errors.Is(err, ErrBadStuckOrdersRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (h *AdminStuckOrdersHandler) handle(r *http.Request) ([]byte, error) {
+	query := r.URL.Query()
+
+	limit, err := parseIntParam(query.Get("limit"), defaultStuckOrdersLimit)
+	if err != nil || limit <= 0 || limit > maxStuckOrdersLimit {
+		return nil, fmt.Errorf("limit=%s, err=%w", query.Get("limit"), ErrBadStuckOrdersRequest)
+	}
+
+	offset, err := parseIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("offset=%s, err=%w", query.Get("offset"), ErrBadStuckOrdersRequest)
+	}
+
+	orders, err := h.orders.GetStuckOrders(r.Context(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(makeStuckOrderResponses(orders))
+}
+
+// AdminOrderRetryHandler returns the stuck order from the url to the accrual queue
+type AdminOrderRetryHandler struct {
+	orders storage.StuckOrders
+}
+
+func NewAdminOrderRetryHandler(orders storage.StuckOrders) *AdminOrderRetryHandler {
+	return &AdminOrderRetryHandler{
+		orders: orders,
+	}
+}
+
+func (h *AdminOrderRetryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := chi.URLParam(r, "number")
+
+	if err := h.orders.RetryStuckOrder(r.Context(), orderID); err != nil {
+		zlog.Logger.Errorf("Retry order=%s err=%s", orderID, err)
+
+		if errors.Is(err, sql.ErrOrderIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if errors.Is(err, sql.ErrOrderIsNotStuck) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func makeStuckOrderResponses(orders []*sql.Order) []*StuckOrderResponse {
+	responses := make([]*StuckOrderResponse, 0, len(orders))
+	for _, order := range orders {
+		resp := &StuckOrderResponse{
+			Number:      order.ID,
+			User:        order.User,
+			Status:      string(order.Status),
+			UploadedAt:  order.UploadedAt.Format(time.RFC3339),
+			Attempts:    order.Attempts,
+			LastError:   order.LastError,
+			NextCheckAt: order.NextCheckAt.Format(time.RFC3339),
+		}
+
+		if order.StuckAt != nil {
+			resp.StuckAt = order.StuckAt.Format(time.RFC3339)
+		}
+
+		responses = append(responses, resp)
+	}
+
+	return responses
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrBadRoleRequest = errors.New("bad role request")
+
+type RoleSetter interface {
+	SetRole(ctx context.Context, login string, role rbac.Role) error
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AdminRoleHandler changes role of the user from the url, access is checked by the middleware
+type AdminRoleHandler struct {
+	setter RoleSetter
+}
+
+func NewAdminRoleHandler(setter RoleSetter) *AdminRoleHandler {
+	return &AdminRoleHandler{
+		setter: setter,
+	}
+}
+
+func (h *AdminRoleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Admin role handler")
+
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.handle(r); err != nil {
+		zlog.Logger.Infof("Handle set role was failed with err=%s", err)
+
+		if errors.Is(err, ErrBadRoleRequest) || errors.Is(err, rbac.ErrUnknownRole) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, sql.ErrUserIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AdminRoleHandler) handle(r *http.Request) error {
+	login := chi.URLParam(r, "login")
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("read from body err=%w", err)
+	}
+
+	req := &SetRoleRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return errors.Join(ErrBadRoleRequest, err)
+	}
+
+	role, err := rbac.ParseRole(req.Role)
+	if err != nil {
+		return err
+	}
+
+	return h.setter.SetRole(r.Context(), login, role)
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"gophermart/internal/zlog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultUsersSearchLimit = 50
+	maxUsersSearchLimit     = 500
+)
+
+var ErrBadSearchRequest = errors.New("bad users search request")
+
+type UserAdministrator interface {
+	BlockUser(ctx context.Context, login string) error
+	UnblockUser(ctx context.Context, login string) error
+	LogoutUser(ctx context.Context, login string) error
+}
+
+type AdminUserResponse struct {
+	Login     string       `json:"login"`
+	Role      string       `json:"role"`
+	Blocked   bool         `json:"blocked"`
+	Balance   money.Amount `json:"balance"`
+	Withdrawn money.Amount `json:"withdrawn"`
+}
+
+// AdminUsersSearchHandler finds users by the part of login, access is checked by the middleware
+type AdminUsersSearchHandler struct {
+	users storage.Users
+}
+
+func NewAdminUsersSearchHandler(users storage.Users) *AdminUsersSearchHandler {
+	return &AdminUsersSearchHandler{
+		users: users,
+	}
+}
+
+func (h *AdminUsersSearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Admin users search handler")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Errorf("Search users err=%s", err)
+
+		if errors.Is(err, ErrBadSearchRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (h *AdminUsersSearchHandler) handle(r *http.Request) ([]byte, error) {
+	query := r.URL.Query()
+
+	limit, err := parseIntParam(query.Get("limit"), defaultUsersSearchLimit)
+	if err != nil || limit <= 0 || limit > maxUsersSearchLimit {
+		return nil, fmt.Errorf("limit=%s, err=%w", query.Get("limit"), ErrBadSearchRequest)
+	}
+
+	offset, err := parseIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("offset=%s, err=%w", query.Get("offset"), ErrBadSearchRequest)
+	}
+
+	users, err := h.users.SearchUsers(r.Context(), query.Get("login"), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(users)
+}
+
+// AdminUserViewHandler shows the part of account of the user from the url
+type AdminUserViewHandler struct {
+	view func(ctx context.Context, login string) (any, error)
+}
+
+func NewAdminUserProfileHandler(store storage.Storage) *AdminUserViewHandler {
+	return &AdminUserViewHandler{
+		view: func(ctx context.Context, login string) (any, error) {
+			user, err := store.FindUser(ctx, login)
+			if err != nil {
+				return nil, err
+			}
+
+			statistic, err := store.GetUserStatistic(ctx, login)
+			if err != nil {
+				return nil, err
+			}
+
+			return &AdminUserResponse{
+				Login:     user.Login,
+				Role:      user.Role,
+				Blocked:   user.Blocked,
+				Balance:   statistic.Balance,
+				Withdrawn: statistic.WithdrawalsTotalSum,
+			}, nil
+		},
+	}
+}
+
+func NewAdminUserOrdersHandler(orders storage.Orders) *AdminUserViewHandler {
+	return &AdminUserViewHandler{
+		view: func(ctx context.Context, login string) (any, error) {
+			list, err := orders.GetUserOrders(ctx, login, sql.Period{})
+			if err != nil {
+				return nil, err
+			}
+
+			return makeOrderResponses(list), nil
+		},
+	}
+}
+
+func NewAdminUserWithdrawalsHandler(withdrawals storage.Withdrawals) *AdminUserViewHandler {
+	return &AdminUserViewHandler{
+		view: func(ctx context.Context, login string) (any, error) {
+			list, err := withdrawals.GetUserWithdrawals(ctx, login, sql.Period{})
+			if err != nil {
+				return nil, err
+			}
+
+			return makeWithdrawalResponses(list), nil
+		},
+	}
+}
+
+func (h *AdminUserViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	login := chi.URLParam(r, "login")
+
+	result, err := h.view(r.Context(), login)
+	if err != nil {
+		zlog.Logger.Errorf("Admin view of user=%s err=%s", login, err)
+
+		if errors.Is(err, sql.ErrUserIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		zlog.Logger.Errorf("marshal admin view of user=%s err=%s", login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+// AdminUserActionHandler applies the operator's action to the user from the url
+type AdminUserActionHandler struct {
+	action func(ctx context.Context, login string) error
+}
+
+func NewAdminUserBlockHandler(administrator UserAdministrator) *AdminUserActionHandler {
+	return &AdminUserActionHandler{action: administrator.BlockUser}
+}
+
+func NewAdminUserUnblockHandler(administrator UserAdministrator) *AdminUserActionHandler {
+	return &AdminUserActionHandler{action: administrator.UnblockUser}
+}
+
+func NewAdminUserLogoutHandler(administrator UserAdministrator) *AdminUserActionHandler {
+	return &AdminUserActionHandler{action: administrator.LogoutUser}
+}
+
+func (h *AdminUserActionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	login := chi.URLParam(r, "login")
+
+	if err := h.action(r.Context(), login); err != nil {
+		zlog.Logger.Errorf("Admin action on user=%s uri=%s err=%s", login, r.RequestURI, err)
+
+		if errors.Is(err, sql.ErrUserIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeJSON(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
+}
+
+func parseIntParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(value)
+}
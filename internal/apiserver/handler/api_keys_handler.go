@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxAPIKeyNameLength = 64
+
+var ErrBadAPIKeyRequest = errors.New("bad api key request")
+
+type APIKeyManager interface {
+	AuthChecker
+	CreateAPIKey(ctx context.Context, login string, name string, scopes rbac.Scopes, expiresAt time.Time) (*sql.APIKey, string, error)
+	GetAPIKeys(ctx context.Context, login string) ([]*sql.APIKey, error)
+	RevokeAPIKey(ctx context.Context, login string, id int64) error
+}
+
+// CreateAPIKeyRequest's expires_at is optional, the key lives for the max allowed period without it
+type CreateAPIKeyRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse contains the key itself only in the response on creation
+type APIKeyResponse struct {
+	ID         int64       `json:"id"`
+	Name       string      `json:"name"`
+	Key        string      `json:"key,omitempty"`
+	Prefix     string      `json:"prefix"`
+	Scopes     rbac.Scopes `json:"scopes"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	LastUsedAt *time.Time  `json:"last_used_at,omitempty"`
+}
+
+func newAPIKeyResponse(apiKey *sql.APIKey, key string) *APIKeyResponse {
+	return &APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Key:        key,
+		Prefix:     apiKey.Prefix,
+		Scopes:     rbac.SplitScopes(apiKey.Scopes),
+		CreatedAt:  apiKey.CreatedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+	}
+}
+
+// APIKeysHandler creates, lists and revokes user's API keys, the keys can't be managed by API key
+type APIKeysHandler struct {
+	manager APIKeyManager
+}
+
+func NewAPIKeysHandler(manager APIKeyManager) *APIKeysHandler {
+	return &APIKeysHandler{
+		manager: manager,
+	}
+}
+
+func (h *APIKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("API keys handler")
+
+	login, err := checkUserAuthorization(r, h.manager, sessionOnly)
+	if err != nil {
+		zlog.Logger.Errorf("Check user auth err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.serveGetAPIKeys(w, r, login)
+	case http.MethodPost:
+		h.serveCreateAPIKey(w, r, login)
+	case http.MethodDelete:
+		h.serveRevokeAPIKey(w, r, login)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *APIKeysHandler) serveGetAPIKeys(w http.ResponseWriter, r *http.Request, login string) {
+	data, err := h.getAPIKeys(r, login)
+	if err != nil {
+		zlog.Logger.Errorf("Get user=%s api keys err=%s", login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (h *APIKeysHandler) getAPIKeys(r *http.Request, login string) ([]byte, error) {
+	keys, err := h.manager.GetAPIKeys(r.Context(), login)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, newAPIKeyResponse(key, ""))
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("marshal api keys of user=%s, err=%w", login, err)
+	}
+
+	return data, nil
+}
+
+func (h *APIKeysHandler) serveCreateAPIKey(w http.ResponseWriter, r *http.Request, login string) {
+	data, err := h.createAPIKey(r, login)
+	if err != nil {
+		zlog.Logger.Errorf("Create user=%s api key err=%s", login, err)
+
+		if errors.Is(err, ErrBadAPIKeyRequest) || errors.Is(err, rbac.ErrUnknownScope) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
+}
+
+func (h *APIKeysHandler) createAPIKey(r *http.Request, login string) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read from body err=%w", err)
+	}
+
+	req := &CreateAPIKeyRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, errors.Join(ErrBadAPIKeyRequest, err)
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return nil, fmt.Errorf("name is empty or too long, err=%w", ErrBadAPIKeyRequest)
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, fmt.Errorf("scopes are empty, err=%w", ErrBadAPIKeyRequest)
+	}
+
+	scopes, err := rbac.ParseScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey, key, err := h.manager.CreateAPIKey(r.Context(), login, req.Name, scopes, req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(newAPIKeyResponse(apiKey, key))
+	if err != nil {
+		return nil, fmt.Errorf("marshal api key of user=%s, err=%w", login, err)
+	}
+
+	return data, nil
+}
+
+func (h *APIKeysHandler) serveRevokeAPIKey(w http.ResponseWriter, r *http.Request, login string) {
+	if err := h.revokeAPIKey(r, login); err != nil {
+		zlog.Logger.Errorf("Revoke user=%s api key err=%s", login, err)
+
+		if errors.Is(err, ErrBadAPIKeyRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, sql.ErrAPIKeyIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *APIKeysHandler) revokeAPIKey(r *http.Request, login string) error {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return errors.Join(ErrBadAPIKeyRequest, err)
+	}
+
+	return h.manager.RevokeAPIKey(r.Context(), login, id)
+}
@@ -2,27 +2,54 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gophermart/internal/zlog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type UserAuthorizer interface {
-	Authorize(ctx context.Context, login string, password string) (string, error)
+	Authorize(ctx context.Context, login string, password string, client *ClientInfo) (*Credentials, error)
 }
 
 var (
 	ErrIsNotAutorized = errors.New("user isn't autorized")
+	ErrUserIsBlocked  = errors.New("user is blocked")
 )
 
+// TooManyAttemptsError is returned when login attempts are temporarily blocked
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many login attempts, retry after %s", e.RetryAfter)
+}
+
+// SecondFactorRequiredError is returned after the right password when the user has enabled the second factor,
+// the challenge is exchanged for credentials at /api/user/login/2fa
+type SecondFactorRequiredError struct {
+	Challenge string    `json:"challenge"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "second factor is required"
+}
+
 type AutentifiactionHandler struct {
-	authorizer UserAuthorizer
+	authorizer   UserAuthorizer
+	secureCookie bool
 }
 
-func NewAutentifiactionHandler(authorizer UserAuthorizer) *AutentifiactionHandler {
+func NewAutentifiactionHandler(authorizer UserAuthorizer, secureCookie bool) *AutentifiactionHandler {
 	return &AutentifiactionHandler{
-		authorizer: authorizer,
+		authorizer:   authorizer,
+		secureCookie: secureCookie,
 	}
 }
 
@@ -34,12 +61,22 @@ func (h *AutentifiactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	authKey, err := h.handle(r)
+	credentials, err := h.handle(r)
 	if err != nil {
 		zlog.Logger.Infof("Handle request was failed with err=%s", err)
 
-		if errors.Is(err, ErrDesirializeAuthInfo) {
+		var tooManyAttemptsErr *TooManyAttemptsError
+		var secondFactorErr *SecondFactorRequiredError
+
+		if errors.As(err, &secondFactorErr) {
+			writeSecondFactorChallenge(w, secondFactorErr)
+		} else if errors.Is(err, ErrDesirializeAuthInfo) {
 			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.As(err, &tooManyAttemptsErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttemptsErr.RetryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if errors.Is(err, ErrUserIsBlocked) {
+			w.WriteHeader(http.StatusForbidden)
 		} else if errors.Is(err, ErrIsNotAutorized) {
 			w.WriteHeader(http.StatusUnauthorized)
 		} else {
@@ -49,20 +86,37 @@ func (h *AutentifiactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	writeAuthCookie(w, authKey)
-	w.WriteHeader(http.StatusOK)
+	writeCredentials(w, credentials, h.secureCookie)
 }
 
-func (h *AutentifiactionHandler) handle(r *http.Request) (string, error) {
+func (h *AutentifiactionHandler) handle(r *http.Request) (*Credentials, error) {
 	userData, err := readAuthInfoFromRequest(r)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	key, err := h.authorizer.Authorize(r.Context(), userData.Login, userData.Password)
+	credentials, err := h.authorizer.Authorize(r.Context(), userData.Login, userData.Password, NewClientInfo(r))
 	if err != nil {
-		return "", fmt.Errorf("cann't authorize user with login=%s, err=%w", userData.Login, err)
+		return nil, fmt.Errorf("cann't authorize user with login=%s, err=%w", userData.Login, err)
 	}
 
-	return key, nil
+	return credentials, nil
+}
+
+// writeSecondFactorChallenge finishes the first step of login with 202 status, cookies aren't set yet
+func writeSecondFactorChallenge(w http.ResponseWriter, challenge *SecondFactorRequiredError) {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		zlog.Logger.Errorf("marshal second factor challenge, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
 }
@@ -3,6 +3,11 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"gophermart/internal/authservice/policy"
+	"net"
+	"net/http"
+	"time"
 )
 
 var (
@@ -10,6 +15,19 @@ var (
 	ErrBadAuthInfo         = errors.New("login or password isn't correct")
 )
 
+// PolicyViolationError is returned when registration data doesn't satisfy the registration policy
+type PolicyViolationError struct {
+	Violations []policy.Violation `json:"errors"`
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("%s, violations=%+v", ErrBadAuthInfo, e.Violations)
+}
+
+func (e *PolicyViolationError) Unwrap() error {
+	return ErrBadAuthInfo
+}
+
 type AuthInfo struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
@@ -34,3 +52,31 @@ func (m *AuthInfo) Desirialize(data []byte) error {
 
 	return nil
 }
+
+// Credentials are issued to user after successful registration or authorization.
+// Refresh token is issued only in jwt auth mode.
+type Credentials struct {
+	Token     string    `json:"access_token"`
+	ExpiresAt time.Time `json:"access_expires_at"`
+
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+// ClientInfo describes the device from which user is authorized
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func NewClientInfo(r *http.Request) *ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return &ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
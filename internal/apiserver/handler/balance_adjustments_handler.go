@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"gophermart/internal/zlog"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const maxAdjustmentReasonLength = 512
+
+var ErrBadAdjustmentRequest = errors.New("bad balance adjustment request")
+
+type BalanceAdjustmentRequest struct {
+	Amount money.Amount `json:"amount"`
+	Reason string       `json:"reason"`
+}
+
+// BalanceAdjustmentResponse is the adjustment as the user sees it in the history, without the operator
+type BalanceAdjustmentResponse struct {
+	Amount      money.Amount `json:"amount"`
+	Reason      string       `json:"reason"`
+	ProcessedAt time.Time    `json:"processed_at"`
+}
+
+// AdminBalanceAdjustmentHandler credits or debits balance of the user from the url, access is checked by the middleware
+type AdminBalanceAdjustmentHandler struct {
+	adjustments storage.BalanceAdjustments
+}
+
+func NewAdminBalanceAdjustmentHandler(adjustments storage.BalanceAdjustments) *AdminBalanceAdjustmentHandler {
+	return &AdminBalanceAdjustmentHandler{
+		adjustments: adjustments,
+	}
+}
+
+func (h *AdminBalanceAdjustmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Admin balance adjustment handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Errorf("Handle balance adjustment err=%s", err)
+
+		if errors.Is(err, ErrBadAdjustmentRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, sql.ErrUserIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else if errors.Is(err, sql.ErrNotEnoughFundsInTheAccount) {
+			w.WriteHeader(http.StatusPaymentRequired)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (h *AdminBalanceAdjustmentHandler) handle(r *http.Request) ([]byte, error) {
+	principal, ok := rbac.PrincipalFromContext(r.Context())
+	if !ok {
+		return nil, errors.New("principal isn't set by the access control")
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read from body err=%w", err)
+	}
+
+	req := &BalanceAdjustmentRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, errors.Join(ErrBadAdjustmentRequest, err)
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	if req.Amount == 0 {
+		return nil, fmt.Errorf("amount=%s, err=%w", req.Amount, ErrBadAdjustmentRequest)
+	}
+
+	if req.Reason == "" || len(req.Reason) > maxAdjustmentReasonLength {
+		return nil, fmt.Errorf("reason is empty or too long, err=%w", ErrBadAdjustmentRequest)
+	}
+
+	adjustment := &sql.BalanceAdjustment{
+		Login:     chi.URLParam(r, "login"),
+		Operator:  principal.Login,
+		Reason:    req.Reason,
+		Amount:    req.Amount,
+		CreatedAt: time.Now(),
+	}
+
+	if err := h.adjustments.AdjustBalance(r.Context(), adjustment); err != nil {
+		return nil, fmt.Errorf("adjust balance of user=%s by operator=%s err=%w", adjustment.Login, adjustment.Operator, err)
+	}
+
+	zlog.Logger.Infof("Balance of user=%s was adjusted on amount=%s by operator=%s", adjustment.Login, adjustment.Amount, adjustment.Operator)
+
+	return json.Marshal(adjustment)
+}
+
+func NewAdminUserBalanceAdjustmentsHandler(adjustments storage.BalanceAdjustments) *AdminUserViewHandler {
+	return &AdminUserViewHandler{
+		view: func(ctx context.Context, login string) (any, error) {
+			return adjustments.GetUserBalanceAdjustments(ctx, login)
+		},
+	}
+}
+
+// BalanceAdjustmentsHandler shows the manual adjustments of the authorized user's balance
+type BalanceAdjustmentsHandler struct {
+	authChecker AuthChecker
+	adjustments storage.BalanceAdjustments
+}
+
+func NewBalanceAdjustmentsHandler(authChecker AuthChecker, adjustments storage.BalanceAdjustments) *BalanceAdjustmentsHandler {
+	return &BalanceAdjustmentsHandler{
+		authChecker: authChecker,
+		adjustments: adjustments,
+	}
+}
+
+func (h *BalanceAdjustmentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Errorf("handle get balance adjustments, err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, ErrAccessIsForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (h *BalanceAdjustmentsHandler) handle(r *http.Request) ([]byte, error) {
+	login, err := checkUserAuthorization(r, h.authChecker, rbac.ScopeBalanceRead)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustments, err := h.adjustments.GetUserBalanceAdjustments(r.Context(), login)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(adjustments) == 0 {
+		return nil, nil
+	}
+
+	response := make([]*BalanceAdjustmentResponse, 0, len(adjustments))
+	for _, adjustment := range adjustments {
+		response = append(response, &BalanceAdjustmentResponse{
+			Amount:      adjustment.Amount,
+			Reason:      adjustment.Reason,
+			ProcessedAt: adjustment.CreatedAt,
+		})
+	}
+
+	return json.Marshal(response)
+}
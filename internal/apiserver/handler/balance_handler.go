@@ -4,25 +4,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"gophermart/internal/sql"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
+	"gophermart/internal/storage"
 	"gophermart/internal/zlog"
 	"net/http"
 )
 
 type BalanceHandler struct {
-	authChecker   AuthChecker
-	sqlController *sql.Controller
+	authChecker AuthChecker
+	withdrawals storage.Withdrawals
 }
 
 type BalanceResponse struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
 }
 
-func NewBalanceHandler(authChecker AuthChecker, ctrl *sql.Controller) *BalanceHandler {
+func NewBalanceHandler(authChecker AuthChecker, withdrawals storage.Withdrawals) *BalanceHandler {
 	return &BalanceHandler{
-		authChecker:   authChecker,
-		sqlController: ctrl,
+		authChecker: authChecker,
+		withdrawals: withdrawals,
 	}
 }
 
@@ -38,6 +40,8 @@ func (h *BalanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		if errors.Is(err, ErrUserIsNotAuthentificated) {
 			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, ErrAccessIsForbidden) {
+			w.WriteHeader(http.StatusForbidden)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -54,12 +58,12 @@ func (h *BalanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *BalanceHandler) handle(r *http.Request) ([]byte, error) {
-	login, err := checkUserAuthorization(r, h.authChecker)
+	login, err := checkUserAuthorization(r, h.authChecker, rbac.ScopeBalanceRead)
 	if err != nil {
 		return nil, err
 	}
 
-	userStatistic, err := h.sqlController.GetUserStatistic(r.Context(), login)
+	userStatistic, err := h.withdrawals.GetUserStatistic(r.Context(), login)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"gophermart/internal/zlog"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultBalanceHistoryLimit = 50
+	maxBalanceHistoryLimit     = 500
+)
+
+var ErrBadBalanceHistoryRequest = errors.New("bad balance history request")
+
+// BalanceHistoryEntryResponse is the change of the balance, credits are positive and debits are negative,
+// order is set for accruals and withdrawals
+type BalanceHistoryEntryResponse struct {
+	Type        sql.LedgerEntryKind `json:"type"`
+	Order       string              `json:"order,omitempty"`
+	Amount      money.Amount        `json:"amount"`
+	Balance     money.Amount        `json:"balance"`
+	ProcessedAt string              `json:"processed_at"`
+}
+
+// BalanceHistoryHandler shows changes of the balance with the balance after each of them,
+// query params: type (accrual, withdrawal, adjustment, opening), from, to, limit and offset
+type BalanceHistoryHandler struct {
+	authChecker AuthChecker
+	history     storage.BalanceHistory
+}
+
+func NewBalanceHistoryHandler(authChecker AuthChecker, history storage.BalanceHistory) *BalanceHistoryHandler {
+	return &BalanceHistoryHandler{
+		authChecker: authChecker,
+		history:     history,
+	}
+}
+
+func (h *BalanceHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Errorf("handle get balance history, err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, ErrAccessIsForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+		} else if errors.Is(err, ErrBadBalanceHistoryRequest) || errors.Is(err, ErrBadPeriod) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (h *BalanceHistoryHandler) handle(r *http.Request) ([]byte, error) {
+	login, err := checkUserAuthorization(r, h.authChecker, rbac.ScopeBalanceRead)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := parseBalanceHistoryFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := h.history.GetBalanceHistory(r.Context(), login, filter)
+	if err != nil {
+		return nil, fmt.Errorf("get balance history of user=%s, err=%w", login, err)
+	}
+
+	if len(history) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(makeBalanceHistoryResponses(history))
+	if err != nil {
+		return nil, fmt.Errorf("marshal balance history of user=%s, err=%w", login, err)
+	}
+
+	return data, nil
+}
+
+func makeBalanceHistoryResponses(history []*sql.BalanceHistoryEntry) []*BalanceHistoryEntryResponse {
+	response := make([]*BalanceHistoryEntryResponse, 0, len(history))
+	for _, entry := range history {
+		item := &BalanceHistoryEntryResponse{
+			Type:        entry.Kind,
+			Amount:      entry.Amount,
+			Balance:     entry.Balance,
+			ProcessedAt: entry.CreatedAt.Format(time.RFC3339),
+		}
+
+		if entry.Kind == sql.LedgerEntryAccrual || entry.Kind == sql.LedgerEntryWithdrawal {
+			item.Order = entry.Reference
+		}
+
+		response = append(response, item)
+	}
+
+	return response
+}
+
+func parseBalanceHistoryFilter(r *http.Request) (sql.BalanceHistoryFilter, error) {
+	var filter sql.BalanceHistoryFilter
+	query := r.URL.Query()
+
+	switch kind := sql.LedgerEntryKind(query.Get("type")); kind {
+	case "", sql.LedgerEntryAccrual, sql.LedgerEntryWithdrawal, sql.LedgerEntryAdjustment, sql.LedgerEntryOpening:
+		filter.Kind = kind
+	default:
+		return filter, fmt.Errorf("type=%s, err=%w", kind, ErrBadBalanceHistoryRequest)
+	}
+
+	limit, err := parseIntParam(query.Get("limit"), defaultBalanceHistoryLimit)
+	if err != nil || limit <= 0 || limit > maxBalanceHistoryLimit {
+		return filter, fmt.Errorf("limit=%s, err=%w", query.Get("limit"), ErrBadBalanceHistoryRequest)
+	}
+
+	offset, err := parseIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		return filter, fmt.Errorf("offset=%s, err=%w", query.Get("offset"), ErrBadBalanceHistoryRequest)
+	}
+
+	period, err := parsePeriod(r)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Limit = limit
+	filter.Offset = offset
+	filter.Period = period
+
+	return filter, nil
+}
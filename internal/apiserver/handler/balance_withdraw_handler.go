@@ -4,20 +4,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
 	"gophermart/internal/sql"
+	"gophermart/internal/storage"
 	"gophermart/internal/zlog"
 	"net/http"
+	"time"
 )
 
+type WithdrawalResponse struct {
+	Order       string       `json:"order"`
+	Sum         money.Amount `json:"sum"`
+	ProcessedAt string       `json:"processed_at"`
+}
+
 type BalanceWithdrawHandler struct {
-	authChecker   AuthChecker
-	sqlController *sql.Controller
+	authChecker AuthChecker
+	withdrawals storage.Withdrawals
 }
 
-func NewBalanceWithdrawHandler(authChecker AuthChecker, ctrl *sql.Controller) *BalanceWithdrawHandler {
+func NewBalanceWithdrawHandler(authChecker AuthChecker, withdrawals storage.Withdrawals) *BalanceWithdrawHandler {
 	return &BalanceWithdrawHandler{
-		authChecker:   authChecker,
-		sqlController: ctrl,
+		authChecker: authChecker,
+		withdrawals: withdrawals,
 	}
 }
 
@@ -33,6 +43,10 @@ func (h *BalanceWithdrawHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 
 		if errors.Is(err, ErrUserIsNotAuthentificated) {
 			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, ErrAccessIsForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+		} else if errors.Is(err, ErrBadPeriod) {
+			w.WriteHeader(http.StatusBadRequest)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -54,12 +68,17 @@ func (h *BalanceWithdrawHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 }
 
 func (h *BalanceWithdrawHandler) handle(r *http.Request) ([]byte, error) {
-	login, err := checkUserAuthorization(r, h.authChecker)
+	login, err := checkUserAuthorization(r, h.authChecker, rbac.ScopeBalanceRead)
+	if err != nil {
+		return nil, err
+	}
+
+	period, err := parsePeriod(r)
 	if err != nil {
 		return nil, err
 	}
 
-	withdrawals, err := h.sqlController.GetUserWithdrawals(r.Context(), login)
+	withdrawals, err := h.withdrawals.GetUserWithdrawals(r.Context(), login, period)
 	if err != nil {
 		return nil, err
 	}
@@ -68,10 +87,23 @@ func (h *BalanceWithdrawHandler) handle(r *http.Request) ([]byte, error) {
 		return nil, nil
 	}
 
-	data, err := json.Marshal(withdrawals)
+	data, err := json.Marshal(makeWithdrawalResponses(withdrawals))
 	if err != nil {
 		return nil, fmt.Errorf("marshal withdrawals of user=%s, err=%w", login, err)
 	}
 
 	return data, nil
 }
+
+func makeWithdrawalResponses(withdrawals []*sql.UserWithdrawRecord) []*WithdrawalResponse {
+	responses := make([]*WithdrawalResponse, 0, len(withdrawals))
+	for _, withdrawal := range withdrawals {
+		responses = append(responses, &WithdrawalResponse{
+			Order:       withdrawal.OrderID,
+			Sum:         withdrawal.Accrual,
+			ProcessedAt: withdrawal.ProcessedAt.Format(time.RFC3339),
+		})
+	}
+
+	return responses
+}
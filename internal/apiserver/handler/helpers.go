@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
 func readAuthInfoFromRequest(r *http.Request) (*AuthInfo, error) {
@@ -21,13 +27,81 @@ func readAuthInfoFromRequest(r *http.Request) (*AuthInfo, error) {
 	return userData, nil
 }
 
-func writeAuthCookie(w http.ResponseWriter, userKey string) {
-	cookie := http.Cookie{Name: "Authorization", Value: string(userKey)}
-	http.SetCookie(w, &cookie)
+const (
+	authCookieName    = "Authorization"
+	refreshCookieName = "Refresh"
+
+	// refresh cookie is needed only for refreshing and closing the session
+	refreshCookiePath = "/api/user"
+
+	bearerPrefix = "Bearer "
+
+	apiKeyHeader = "X-API-Key"
+
+	// endpoints with this scope don't accept API keys
+	sessionOnly rbac.Scope = ""
+)
+
+// writeCredentials sets auth cookies and finishes response with 200 status,
+// tokens are written in the body when refresh token is issued for clients without cookies
+func writeCredentials(w http.ResponseWriter, credentials *Credentials, secure bool) {
+	authCookie := makeCookie(authCookieName, "/", credentials.Token, secure)
+	authCookie.Expires = credentials.ExpiresAt
+	http.SetCookie(w, authCookie)
+
+	if credentials.RefreshToken == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	refreshCookie := makeCookie(refreshCookieName, refreshCookiePath, credentials.RefreshToken, secure)
+	refreshCookie.Expires = credentials.RefreshExpiresAt
+	http.SetCookie(w, refreshCookie)
+
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		zlog.Logger.Errorf("marshal credentials, err=%s", err)
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
+}
+
+func clearAuthCookies(w http.ResponseWriter, secure bool) {
+	for _, cookie := range []*http.Cookie{
+		makeCookie(authCookieName, "/", "", secure),
+		makeCookie(refreshCookieName, refreshCookiePath, "", secure),
+	} {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+func makeCookie(name string, path string, value string, secure bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
 }
 
-func readAuthCookie(r *http.Request) (string, error) {
-	authorizationCookie, err := r.Cookie("Authorization")
+// ReadUserKey returns token from "Authorization: Bearer" header or from Authorization cookie
+func ReadUserKey(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, bearerPrefix) {
+		return strings.TrimPrefix(header, bearerPrefix), nil
+	}
+
+	authorizationCookie, err := r.Cookie(authCookieName)
 	if err != nil {
 		return "", fmt.Errorf("get Authorization cookie, err=%w", err)
 	}
@@ -35,27 +109,99 @@ func readAuthCookie(r *http.Request) (string, error) {
 	return authorizationCookie.Value, nil
 }
 
-var ErrUserIsNotAuthentificated = errors.New("user isn't authentificated")
+var (
+	ErrUserIsNotAuthentificated = errors.New("user isn't authentificated")
+	ErrAccessIsForbidden        = errors.New("access is forbidden")
+)
+
+// returning login and err if user is not exist,
+// API key from X-API-Key header is accepted only by endpoints with scope, others need the user's token
+func checkUserAuthorization(r *http.Request, checker AuthChecker, scope rbac.Scope) (string, error) {
+	if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" && scope != sessionOnly {
+		login, err := checker.CheckAPIKey(r.Context(), apiKey, scope)
+		if err != nil {
+			if errors.Is(err, ErrIsNotAutorized) {
+				return "", errors.Join(ErrUserIsNotAuthentificated, err)
+			}
+
+			return "", fmt.Errorf("check api key, err=%w", err)
+		}
+
+		return login, nil
+	}
 
-// returning login and err if user is not exist
-func checkUserAuthorization(r *http.Request, checker AuthChecker) (string, error) {
-	userKey, err := readAuthCookie(r)
+	userKey, err := ReadUserKey(r)
 	if err != nil {
 		if errors.Is(err, http.ErrNoCookie) {
 			return "", ErrUserIsNotAuthentificated
 		}
 
-		return "", fmt.Errorf("read auth cookie, err=%w", err)
+		return "", fmt.Errorf("read user key, err=%w", err)
 	}
 
 	login, err := checker.Check(r.Context(), userKey)
 	if err != nil {
-		return "", fmt.Errorf("check auth for cookie=%s, err=%w", userKey, err)
+		if errors.Is(err, ErrIsNotAutorized) {
+			return "", errors.Join(ErrUserIsNotAuthentificated, err)
+		}
+
+		return "", fmt.Errorf("check auth, err=%w", err)
 	}
 
 	return login, nil
 }
 
+var ErrBadPeriod = errors.New("bad period")
+
+const dateLayout = "2006-01-02"
+
+// parsePeriod reads optional "from" and "to" query params in RFC3339 or as dates,
+// dates are in UTC and the date in "to" includes the whole day
+func parsePeriod(r *http.Request) (sql.Period, error) {
+	var period sql.Period
+	query := r.URL.Query()
+
+	from, err := parsePeriodBound(query.Get("from"), false)
+	if err != nil {
+		return period, errors.Join(ErrBadPeriod, err)
+	}
+
+	to, err := parsePeriodBound(query.Get("to"), true)
+	if err != nil {
+		return period, errors.Join(ErrBadPeriod, err)
+	}
+
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return period, fmt.Errorf("from=%s isn't before to=%s, err=%w", from, to, ErrBadPeriod)
+	}
+
+	period.From = from
+	period.To = to
+
+	return period, nil
+}
+
+func parsePeriodBound(value string, isEnd bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse time=%s, err=%w", value, err)
+	}
+
+	if isEnd {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
 func validateOrderID(id string) bool {
 	// luna validation algorithm https://ru.wikipedia.org/wiki/%D0%90%D0%BB%D0%B3%D0%BE%D1%80%D0%B8%D1%82%D0%BC_%D0%9B%D1%83%D0%BD%D0%B0
 	sum := 0
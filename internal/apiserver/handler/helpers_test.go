@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -10,3 +12,28 @@ func TestLunaValidation(t *testing.T) {
 	require.False(t, validateOrderID("4561261212345464"))
 	require.True(t, validateOrderID("4561261212345467"))
 }
+
+func TestParsePeriod(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/user/orders", nil)
+	period, err := parsePeriod(r)
+	require.NoError(t, err)
+	require.True(t, period.From.IsZero())
+	require.True(t, period.To.IsZero())
+
+	r = httptest.NewRequest("GET", "/api/user/orders?from=2024-01-10&to=2024-01-10", nil)
+	period, err = parsePeriod(r)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), period.From)
+	require.Equal(t, time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC), period.To)
+
+	r = httptest.NewRequest("GET", "/api/user/orders?from=2024-01-10T12:00:00%2B03:00", nil)
+	period, err = parsePeriod(r)
+	require.NoError(t, err)
+	require.True(t, period.From.Equal(time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC)))
+	require.True(t, period.To.IsZero())
+
+	for _, query := range []string{"from=yesterday", "to=2024-13-01", "from=2024-01-11&to=2024-01-10"} {
+		_, err := parsePeriod(httptest.NewRequest("GET", "/api/user/orders?"+query, nil))
+		require.ErrorIs(t, err, ErrBadPeriod, query)
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/zlog"
+	"net/http"
+)
+
+type UserLogouter interface {
+	AuthChecker
+	Logout(ctx context.Context, userKey string) error
+}
+
+type LogoutHandler struct {
+	logouter     UserLogouter
+	secureCookie bool
+}
+
+func NewLogoutHandler(logouter UserLogouter, secureCookie bool) *LogoutHandler {
+	return &LogoutHandler{
+		logouter:     logouter,
+		secureCookie: secureCookie,
+	}
+}
+
+func (h *LogoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Logout handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.handle(r); err != nil {
+		zlog.Logger.Infof("Handle logout was failed with err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	clearAuthCookies(w, h.secureCookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *LogoutHandler) handle(r *http.Request) error {
+	login, err := checkUserAuthorization(r, h.logouter, sessionOnly)
+	if err != nil {
+		return err
+	}
+
+	userKey, err := ReadUserKey(r)
+	if err != nil {
+		return err
+	}
+
+	if err := h.logouter.Logout(r.Context(), userKey); err != nil {
+		return fmt.Errorf("logout user=%s, err=%w", login, err)
+	}
+
+	return nil
+}
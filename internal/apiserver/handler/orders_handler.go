@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
 	"gophermart/internal/orderscontroller"
+	"gophermart/internal/sql"
 	"gophermart/internal/zlog"
 	"io"
 	"net/http"
+	"time"
 	"unicode"
 )
 
@@ -16,13 +20,14 @@ var ErrUnsuportedMethod = errors.New("unsuported method")
 
 type AuthChecker interface {
 	Check(ctx context.Context, userKey string) (string, error)
+	CheckAPIKey(ctx context.Context, key string, scope rbac.Scope) (string, error)
 }
 
 type OrderResponse struct {
-	Number     string  `json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float64 `json:"accrual"`
-	UploadedAt string  `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual"`
+	UploadedAt string       `json:"uploaded_at"`
 }
 
 type OrdersHandler struct {
@@ -40,12 +45,19 @@ func NewOrdersHandler(authChecker AuthChecker, ordersController *orderscontrolle
 func (h *OrdersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	zlog.Logger.Debugf("Orders handler")
 
-	login, err := checkUserAuthorization(r, h.authChecker)
+	scope := rbac.ScopeOrdersRead
+	if r.Method == http.MethodPost {
+		scope = rbac.ScopeOrdersWrite
+	}
+
+	login, err := checkUserAuthorization(r, h.authChecker, scope)
 	if err != nil {
 		zlog.Logger.Errorf("Check user auth err=%s", err)
 
 		if errors.Is(err, ErrUserIsNotAuthentificated) {
 			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.Is(err, ErrAccessIsForbidden) {
+			w.WriteHeader(http.StatusForbidden)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -156,6 +168,8 @@ func (h *OrdersHandler) serveGetOrderList(w http.ResponseWriter, r *http.Request
 
 		if errors.Is(err, orderscontroller.ErrOrdersListEmpty) {
 			w.WriteHeader(http.StatusNoContent)
+		} else if errors.Is(err, ErrBadPeriod) {
+			w.WriteHeader(http.StatusBadRequest)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
@@ -174,27 +188,35 @@ func (h *OrdersHandler) serveGetOrderList(w http.ResponseWriter, r *http.Request
 }
 
 func (h *OrdersHandler) getUserOrders(r *http.Request, login string) ([]byte, error) {
-	orders, err := h.orderscontroller.GerOrders(r.Context(), login)
+	period, err := parsePeriod(r)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := h.orderscontroller.GerOrders(r.Context(), login, period)
 	if err != nil {
 		return nil, err
 	}
 
-	// reformatting
+	data, err := json.Marshal(makeOrderResponses(orders))
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func makeOrderResponses(orders []*sql.Order) []*OrderResponse {
 	responses := make([]*OrderResponse, 0, len(orders))
 	for _, order := range orders {
 		resp := &OrderResponse{
 			Number:     order.ID,
 			Status:     string(order.Status),
-			Accrual:    float64(order.Accrual),
-			UploadedAt: order.UpdaloadTime,
+			Accrual:    order.Accrual,
+			UploadedAt: order.UploadedAt.Format(time.RFC3339),
 		}
 		responses = append(responses, resp)
 	}
 
-	data, err := json.Marshal(responses)
-	if err != nil {
-		return nil, err
-	}
-
-	return data, nil
+	return responses
 }
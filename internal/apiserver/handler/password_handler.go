@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/zlog"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrDesirializePasswordRequest = errors.New("password request desirialization failed")
+	ErrBadPasswordResetToken      = errors.New("password reset token is unknown, used or expired")
+)
+
+type PasswordChanger interface {
+	AuthChecker
+	ChangePassword(ctx context.Context, login string, userKey string, oldPassword string, newPassword string) error
+}
+
+type PasswordResetter interface {
+	RequestPasswordReset(ctx context.Context, login string) error
+	ResetPassword(ctx context.Context, token string, newPassword string) error
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type PasswordResetConfirmation struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type ChangePasswordHandler struct {
+	changer PasswordChanger
+	policy  RegistrationPolicy
+}
+
+func NewChangePasswordHandler(changer PasswordChanger, policy RegistrationPolicy) *ChangePasswordHandler {
+	return &ChangePasswordHandler{
+		changer: changer,
+		policy:  policy,
+	}
+}
+
+func (h *ChangePasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Change password handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.handle(r); err != nil {
+		zlog.Logger.Infof("Handle change password was failed with err=%s", err)
+
+		var policyErr *PolicyViolationError
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if errors.As(err, &policyErr) {
+			writePolicyViolations(w, policyErr)
+		} else if errors.Is(err, ErrDesirializePasswordRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, ErrIsNotAutorized) {
+			// old password is wrong
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *ChangePasswordHandler) handle(r *http.Request) error {
+	login, err := checkUserAuthorization(r, h.changer, sessionOnly)
+	if err != nil {
+		return err
+	}
+
+	userKey, err := ReadUserKey(r)
+	if err != nil {
+		return err
+	}
+
+	req := &ChangePasswordRequest{}
+	if err := readPasswordRequest(r, req); err != nil {
+		return err
+	}
+
+	if req.OldPassword == "" || req.NewPassword == "" {
+		return ErrDesirializePasswordRequest
+	}
+
+	if violations := h.policy.CheckPassword(req.NewPassword); len(violations) > 0 {
+		return &PolicyViolationError{Violations: violations}
+	}
+
+	if err := h.changer.ChangePassword(r.Context(), login, userKey, req.OldPassword, req.NewPassword); err != nil {
+		return fmt.Errorf("change password of user=%s, err=%w", login, err)
+	}
+
+	return nil
+}
+
+// PasswordResetRequestHandler always answers 202 for the well-formed request
+// regardless of whether the login is registred
+type PasswordResetRequestHandler struct {
+	resetter PasswordResetter
+}
+
+func NewPasswordResetRequestHandler(resetter PasswordResetter) *PasswordResetRequestHandler {
+	return &PasswordResetRequestHandler{
+		resetter: resetter,
+	}
+}
+
+func (h *PasswordResetRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Password reset request handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.handle(r); err != nil {
+		zlog.Logger.Infof("Handle password reset request was failed with err=%s", err)
+
+		if errors.Is(err, ErrDesirializePasswordRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *PasswordResetRequestHandler) handle(r *http.Request) error {
+	req := &PasswordResetRequest{}
+	if err := readPasswordRequest(r, req); err != nil {
+		return err
+	}
+
+	if req.Login == "" {
+		return ErrDesirializePasswordRequest
+	}
+
+	return h.resetter.RequestPasswordReset(r.Context(), req.Login)
+}
+
+type PasswordResetHandler struct {
+	resetter PasswordResetter
+	policy   RegistrationPolicy
+}
+
+func NewPasswordResetHandler(resetter PasswordResetter, policy RegistrationPolicy) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		resetter: resetter,
+		policy:   policy,
+	}
+}
+
+func (h *PasswordResetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Password reset handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.handle(r); err != nil {
+		zlog.Logger.Infof("Handle password reset was failed with err=%s", err)
+
+		var policyErr *PolicyViolationError
+
+		if errors.As(err, &policyErr) {
+			writePolicyViolations(w, policyErr)
+		} else if errors.Is(err, ErrDesirializePasswordRequest) || errors.Is(err, ErrBadPasswordResetToken) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *PasswordResetHandler) handle(r *http.Request) error {
+	req := &PasswordResetConfirmation{}
+	if err := readPasswordRequest(r, req); err != nil {
+		return err
+	}
+
+	if req.Token == "" || req.NewPassword == "" {
+		return ErrDesirializePasswordRequest
+	}
+
+	if violations := h.policy.CheckPassword(req.NewPassword); len(violations) > 0 {
+		return &PolicyViolationError{Violations: violations}
+	}
+
+	return h.resetter.ResetPassword(r.Context(), req.Token, req.NewPassword)
+}
+
+func readPasswordRequest(r *http.Request, req any) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("read from body err=%w", err)
+	}
+
+	if err := json.Unmarshal(data, req); err != nil {
+		return errors.Join(ErrDesirializePasswordRequest, err)
+	}
+
+	return nil
+}
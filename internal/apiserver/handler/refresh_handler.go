@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/zlog"
+	"io"
+	"net/http"
+)
+
+var ErrRefreshTokenIsNotSet = errors.New("refresh token isn't set")
+
+type TokenRefresher interface {
+	Refresh(ctx context.Context, refreshToken string, client *ClientInfo) (*Credentials, error)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshHandler exchanges refresh token from the body or the Refresh cookie for a new pair of tokens
+type RefreshHandler struct {
+	refresher    TokenRefresher
+	secureCookie bool
+}
+
+func NewRefreshHandler(refresher TokenRefresher, secureCookie bool) *RefreshHandler {
+	return &RefreshHandler{
+		refresher:    refresher,
+		secureCookie: secureCookie,
+	}
+}
+
+func (h *RefreshHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Refresh handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentials, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Infof("Handle refresh was failed with err=%s", err)
+
+		if errors.Is(err, ErrRefreshTokenIsNotSet) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, ErrIsNotAutorized) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	writeCredentials(w, credentials, h.secureCookie)
+}
+
+func (h *RefreshHandler) handle(r *http.Request) (*Credentials, error) {
+	refreshToken, err := readRefreshToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := h.refresher.Refresh(r.Context(), refreshToken, NewClientInfo(r))
+	if err != nil {
+		return nil, fmt.Errorf("refresh tokens, err=%w", err)
+	}
+
+	return credentials, nil
+}
+
+func readRefreshToken(r *http.Request) (string, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("read from body err=%w", err)
+	}
+
+	if len(data) > 0 {
+		req := &RefreshRequest{}
+		if err := json.Unmarshal(data, req); err != nil {
+			return "", errors.Join(ErrRefreshTokenIsNotSet, err)
+		}
+
+		if req.RefreshToken != "" {
+			return req.RefreshToken, nil
+		}
+	}
+
+	cookie, err := r.Cookie(refreshCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", ErrRefreshTokenIsNotSet
+	}
+
+	return cookie.Value, nil
+}
@@ -2,29 +2,38 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"gophermart/internal/authservice/policy"
 	"gophermart/internal/zlog"
 	"net/http"
 )
 
-type UserKey = string
-
 type UserRegistrator interface {
-	Register(ctx context.Context, login string, password string) (UserKey, error)
+	Register(ctx context.Context, login string, password string, client *ClientInfo) (*Credentials, error)
 }
 
 var (
 	ErrIsAlreadyRegistred = errors.New("user is already registred")
 )
 
+type RegistrationPolicy interface {
+	Check(login string, password string) []policy.Violation
+	CheckPassword(password string) []policy.Violation
+}
+
 type RegistrationHandler struct {
-	registrator UserRegistrator
+	registrator  UserRegistrator
+	policy       RegistrationPolicy
+	secureCookie bool
 }
 
-func NewRegistrationHandler(registrator UserRegistrator) *RegistrationHandler {
+func NewRegistrationHandler(registrator UserRegistrator, policy RegistrationPolicy, secureCookie bool) *RegistrationHandler {
 	return &RegistrationHandler{
-		registrator: registrator,
+		registrator:  registrator,
+		policy:       policy,
+		secureCookie: secureCookie,
 	}
 }
 
@@ -36,11 +45,15 @@ func (h *RegistrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	authKey, err := h.handle(r)
+	credentials, err := h.handle(r)
 	if err != nil {
 		zlog.Logger.Infof("Handle request was failed with err=%s", err)
 
-		if errors.Is(err, ErrDesirializeAuthInfo) || errors.Is(err, ErrBadAuthInfo) {
+		var policyErr *PolicyViolationError
+
+		if errors.As(err, &policyErr) {
+			writePolicyViolations(w, policyErr)
+		} else if errors.Is(err, ErrDesirializeAuthInfo) || errors.Is(err, ErrBadAuthInfo) {
 			w.WriteHeader(http.StatusBadRequest)
 		} else if errors.Is(err, ErrIsAlreadyRegistred) {
 			w.WriteHeader(http.StatusConflict)
@@ -51,20 +64,40 @@ func (h *RegistrationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	writeAuthCookie(w, authKey)
-	w.WriteHeader(http.StatusOK)
+	writeCredentials(w, credentials, h.secureCookie)
 }
 
-func (h *RegistrationHandler) handle(r *http.Request) (UserKey, error) {
+func (h *RegistrationHandler) handle(r *http.Request) (*Credentials, error) {
 	userData, err := readAuthInfoFromRequest(r)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	userKey, err := h.registrator.Register(r.Context(), userData.Login, userData.Password)
+	if violations := h.policy.Check(userData.Login, userData.Password); len(violations) > 0 {
+		return nil, &PolicyViolationError{Violations: violations}
+	}
+
+	credentials, err := h.registrator.Register(r.Context(), userData.Login, userData.Password, NewClientInfo(r))
 	if err != nil {
-		return "", fmt.Errorf("registration failed, err=%w", err)
+		return nil, fmt.Errorf("registration failed, err=%w", err)
 	}
 
-	return userKey, nil
+	return credentials, nil
+}
+
+func writePolicyViolations(w http.ResponseWriter, policyErr *PolicyViolationError) {
+	data, err := json.Marshal(policyErr)
+	if err != nil {
+		zlog.Logger.Errorf("marshal policy violations, err=%s", err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
 }
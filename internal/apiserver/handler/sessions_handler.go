@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrBadSessionID = errors.New("bad session id")
+
+type SessionManager interface {
+	AuthChecker
+	GetSessions(ctx context.Context, login string, userKey string) ([]*sql.Session, error)
+	DeleteSession(ctx context.Context, login string, id int64) error
+	DeleteOtherSessions(ctx context.Context, login string, userKey string) error
+}
+
+// SessionsHandler serves list of user's sessions and allows to kill them.
+// DELETE without session id kills all sessions except the current one.
+type SessionsHandler struct {
+	manager SessionManager
+}
+
+func NewSessionsHandler(manager SessionManager) *SessionsHandler {
+	return &SessionsHandler{
+		manager: manager,
+	}
+}
+
+func (h *SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Sessions handler")
+
+	login, err := checkUserAuthorization(r, h.manager, sessionOnly)
+	if err != nil {
+		zlog.Logger.Errorf("Check user auth err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.serveGetSessions(w, r, login)
+	case http.MethodDelete:
+		h.serveDeleteSessions(w, r, login)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *SessionsHandler) serveGetSessions(w http.ResponseWriter, r *http.Request, login string) {
+	data, err := h.getSessions(r, login)
+	if err != nil {
+		zlog.Logger.Errorf("Get user=%s sessions err=%s", login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("write data, err=%s", err)
+	}
+}
+
+func (h *SessionsHandler) getSessions(r *http.Request, login string) ([]byte, error) {
+	userKey, err := ReadUserKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := h.manager.GetSessions(r.Context(), login, userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return nil, fmt.Errorf("marshal sessions of user=%s, err=%w", login, err)
+	}
+
+	return data, nil
+}
+
+func (h *SessionsHandler) serveDeleteSessions(w http.ResponseWriter, r *http.Request, login string) {
+	if err := h.deleteSessions(r, login); err != nil {
+		zlog.Logger.Errorf("Delete user=%s sessions err=%s", login, err)
+
+		if errors.Is(err, ErrBadSessionID) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, sql.ErrSessionIsNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *SessionsHandler) deleteSessions(r *http.Request, login string) error {
+	param := chi.URLParam(r, "id")
+	if param == "" {
+		userKey, err := ReadUserKey(r)
+		if err != nil {
+			return err
+		}
+
+		return h.manager.DeleteOtherSessions(r.Context(), login, userKey)
+	}
+
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		return errors.Join(ErrBadSessionID, err)
+	}
+
+	return h.manager.DeleteSession(r.Context(), login, id)
+}
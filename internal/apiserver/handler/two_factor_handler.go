@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/zlog"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrBadSecondFactorRequest       = errors.New("bad second factor request")
+	ErrBadSecondFactorCode          = errors.New("bad second factor code")
+	ErrSecondFactorIsNotEnrolled    = errors.New("second factor isn't enrolled")
+	ErrSecondFactorIsAlreadyEnabled = errors.New("second factor is already enabled")
+)
+
+type SecondFactorAuthorizer interface {
+	AuthorizeSecondFactor(ctx context.Context, challenge string, code string, client *ClientInfo) (*Credentials, error)
+}
+
+type TwoFactorManager interface {
+	AuthChecker
+	EnrollTOTP(ctx context.Context, login string) (*TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, login string, code string, client *ClientInfo) ([]string, error)
+	DisableTOTP(ctx context.Context, login string, code string, client *ClientInfo) error
+}
+
+// TOTPEnrollment is shown to the user once, the uri is usually rendered as QR code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type SecondFactorLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// SecondFactorCodeRequest's code is the totp code or one of the recovery codes
+type SecondFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// SecondFactorLoginHandler completes the login started by AutentifiactionHandler and sets the Authorization cookie
+type SecondFactorLoginHandler struct {
+	authorizer   SecondFactorAuthorizer
+	secureCookie bool
+}
+
+func NewSecondFactorLoginHandler(authorizer SecondFactorAuthorizer, secureCookie bool) *SecondFactorLoginHandler {
+	return &SecondFactorLoginHandler{
+		authorizer:   authorizer,
+		secureCookie: secureCookie,
+	}
+}
+
+func (h *SecondFactorLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Second factor login handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentials, err := h.handle(r)
+	if err != nil {
+		zlog.Logger.Infof("Handle second factor was failed with err=%s", err)
+
+		var tooManyAttemptsErr *TooManyAttemptsError
+
+		if errors.Is(err, ErrBadSecondFactorRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.As(err, &tooManyAttemptsErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttemptsErr.RetryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if errors.Is(err, ErrUserIsBlocked) {
+			w.WriteHeader(http.StatusForbidden)
+		} else if errors.Is(err, ErrIsNotAutorized) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	writeCredentials(w, credentials, h.secureCookie)
+}
+
+func (h *SecondFactorLoginHandler) handle(r *http.Request) (*Credentials, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read from body err=%w", err)
+	}
+
+	req := &SecondFactorLoginRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, errors.Join(ErrBadSecondFactorRequest, err)
+	}
+
+	if req.Challenge == "" || req.Code == "" {
+		return nil, ErrBadSecondFactorRequest
+	}
+
+	return h.authorizer.AuthorizeSecondFactor(r.Context(), req.Challenge, req.Code, NewClientInfo(r))
+}
+
+// TwoFactorHandler serves one step of totp setup: enrollment, confirmation or disabling
+type TwoFactorHandler struct {
+	manager TwoFactorManager
+	step    func(r *http.Request, login string) ([]byte, error)
+}
+
+func NewTOTPEnrollHandler(manager TwoFactorManager) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		manager: manager,
+		step: func(r *http.Request, login string) ([]byte, error) {
+			enrollment, err := manager.EnrollTOTP(r.Context(), login)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.Marshal(enrollment)
+		},
+	}
+}
+
+func NewTOTPConfirmHandler(manager TwoFactorManager) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		manager: manager,
+		step: func(r *http.Request, login string) ([]byte, error) {
+			code, err := readSecondFactorCode(r)
+			if err != nil {
+				return nil, err
+			}
+
+			recoveryCodes, err := manager.ConfirmTOTP(r.Context(), login, code, NewClientInfo(r))
+			if err != nil {
+				return nil, err
+			}
+
+			return json.Marshal(&RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+		},
+	}
+}
+
+func NewTOTPDisableHandler(manager TwoFactorManager) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		manager: manager,
+		step: func(r *http.Request, login string) ([]byte, error) {
+			code, err := readSecondFactorCode(r)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, manager.DisableTOTP(r.Context(), login, code, NewClientInfo(r))
+		},
+	}
+}
+
+func (h *TwoFactorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	zlog.Logger.Debugf("Two factor handler")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	login, err := checkUserAuthorization(r, h.manager, sessionOnly)
+	if err != nil {
+		zlog.Logger.Errorf("Check user auth err=%s", err)
+
+		if errors.Is(err, ErrUserIsNotAuthentificated) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	data, err := h.step(r, login)
+	if err != nil {
+		zlog.Logger.Infof("Two factor step uri=%s of user=%s was failed with err=%s", r.RequestURI, login, err)
+
+		var tooManyAttemptsErr *TooManyAttemptsError
+
+		if errors.Is(err, ErrBadSecondFactorRequest) || errors.Is(err, ErrBadSecondFactorCode) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.As(err, &tooManyAttemptsErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttemptsErr.RetryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else if errors.Is(err, ErrSecondFactorIsNotEnrolled) || errors.Is(err, ErrSecondFactorIsAlreadyEnabled) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if data == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func readSecondFactorCode(r *http.Request) (string, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", fmt.Errorf("read from body err=%w", err)
+	}
+
+	req := &SecondFactorCodeRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return "", errors.Join(ErrBadSecondFactorRequest, err)
+	}
+
+	if req.Code == "" {
+		return "", ErrBadSecondFactorRequest
+	}
+
+	return req.Code, nil
+}
@@ -4,26 +4,40 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
 	"gophermart/internal/sql"
+	"gophermart/internal/storage"
 	"gophermart/internal/zlog"
 	"io"
 	"net/http"
 )
 
+const (
+	// the client sends the same key when it retries the withdraw request, so the points are spent once
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	ErrBadWithdrawSum    = errors.New("withdraw sum isn't positive or is more precise than the hundredth")
+	ErrBadIdempotencyKey = errors.New("idempotency key is too long")
+)
+
 type WithdrawalsHandler struct {
 	authChecker AuthChecker
-	sqlCtrl     *sql.Controller
+	withdrawals storage.Withdrawals
 }
 
 type WithdrawRequest struct {
-	OrderID string  `json:"order"`
-	Sum     float64 `json:"sum"`
+	OrderID string       `json:"order"`
+	Sum     money.Amount `json:"sum"`
 }
 
-func NewWithdrawalsHandler(authChecker AuthChecker, sqlCtrl *sql.Controller) *WithdrawalsHandler {
+func NewWithdrawalsHandler(authChecker AuthChecker, withdrawals storage.Withdrawals) *WithdrawalsHandler {
 	return &WithdrawalsHandler{
 		authChecker: authChecker,
-		sqlCtrl:     sqlCtrl,
+		withdrawals: withdrawals,
 	}
 }
 
@@ -41,8 +55,14 @@ func (h *WithdrawalsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 		if errors.Is(err, ErrUserIsNotAuthentificated) {
 			w.WriteHeader(http.StatusUnauthorized)
-		} else if errors.Is(err, ErrBadOrderID) {
+		} else if errors.Is(err, ErrAccessIsForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+		} else if errors.Is(err, ErrBadIdempotencyKey) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if errors.Is(err, ErrBadOrderID) || errors.Is(err, ErrBadWithdrawSum) || errors.Is(err, sql.ErrIdempotencyKeyIsReused) {
 			w.WriteHeader(http.StatusUnprocessableEntity)
+		} else if errors.Is(err, sql.ErrWithdrawalAlreadyExist) {
+			w.WriteHeader(http.StatusConflict)
 		} else if errors.Is(err, sql.ErrNotEnoughFundsInTheAccount) {
 			w.WriteHeader(http.StatusPaymentRequired)
 		} else {
@@ -56,11 +76,16 @@ func (h *WithdrawalsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *WithdrawalsHandler) handle(r *http.Request) error {
-	login, err := checkUserAuthorization(r, h.authChecker)
+	login, err := checkUserAuthorization(r, h.authChecker, rbac.ScopeBalanceWithdraw)
 	if err != nil {
 		return err
 	}
 
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return fmt.Errorf("length=%d, err=%w", len(idempotencyKey), ErrBadIdempotencyKey)
+	}
+
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		return fmt.Errorf("read from bidy err=%w", err)
@@ -68,6 +93,10 @@ func (h *WithdrawalsHandler) handle(r *http.Request) error {
 
 	req := &WithdrawRequest{}
 	if err := json.Unmarshal(data, req); err != nil {
+		if errors.Is(err, money.ErrBadAmount) {
+			return errors.Join(ErrBadWithdrawSum, err)
+		}
+
 		return fmt.Errorf("unmarsgal data=%s err=%w", string(data), err)
 	}
 
@@ -75,7 +104,11 @@ func (h *WithdrawalsHandler) handle(r *http.Request) error {
 		return ErrBadOrderID
 	}
 
-	if err := h.sqlCtrl.Withdraw(r.Context(), login, req.OrderID, req.Sum); err != nil {
+	if req.Sum <= 0 {
+		return fmt.Errorf("sum=%s err=%w", req.Sum, ErrBadWithdrawSum)
+	}
+
+	if err := h.withdrawals.Withdraw(r.Context(), login, req.OrderID, req.Sum, idempotencyKey); err != nil {
 		return fmt.Errorf("withdraw req=%v, err=%w", req, err)
 	}
 
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/zlog"
+	"net/http"
+)
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, userKey string) (*rbac.Principal, error)
+}
+
+// AccessControl allows request only for users whose role has the route's permission
+// and puts the authenticated user to the request's context
+type AccessControl struct {
+	authenticator Authenticator
+}
+
+func NewAccessControl(authenticator Authenticator) *AccessControl {
+	return &AccessControl{
+		authenticator: authenticator,
+	}
+}
+
+func (a *AccessControl) Require(permission rbac.Permission) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		accessHandler := func(w http.ResponseWriter, r *http.Request) {
+			userKey, err := handler.ReadUserKey(r)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := a.authenticator.Authenticate(r.Context(), userKey)
+			if err != nil {
+				zlog.Logger.Infof("Authenticate for permission=%s, err=%s", permission, err)
+
+				if errors.Is(err, handler.ErrIsNotAutorized) {
+					w.WriteHeader(http.StatusUnauthorized)
+				} else {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+
+				return
+			}
+
+			if !rbac.HasPermission(principal.Role, permission) {
+				zlog.Logger.Infof("User=%s with role=%s hasn't permission=%s", principal.Login, principal.Role, permission)
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(rbac.WithPrincipal(r.Context(), principal)))
+		}
+
+		return http.HandlerFunc(accessHandler)
+	}
+}
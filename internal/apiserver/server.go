@@ -7,12 +7,17 @@ import (
 	"gophermart/internal/apiserver/middleware"
 	"gophermart/internal/authservice"
 	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/authservice/jwt"
+	"gophermart/internal/authservice/policy"
+	"gophermart/internal/authservice/rbac"
 	"gophermart/internal/config"
+	"gophermart/internal/notifier"
 	"gophermart/internal/orderscontroller"
 	"gophermart/internal/orderscontroller/accrual"
 	"gophermart/internal/sql"
 	"gophermart/internal/zlog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -25,6 +30,7 @@ type GophermartServer struct {
 	accrualCtrl *accrual.AccrualController
 
 	authService *authservice.AuthService
+	policy      *policy.Policy
 	ordersCtrl  *orderscontroller.OrdersController
 
 	waitingShutdownCh chan struct{}
@@ -49,51 +55,201 @@ func StartNew() (*GophermartServer, error) {
 
 func newServer(config *config.Config) (*GophermartServer, error) {
 
-	sqlController, err := sql.StartNewController(config.DatabaseURI)
+	sqlController, err := sql.StartNewController(config.DatabaseURI, makePoolSettings(config))
 	if err != nil {
 		return nil, fmt.Errorf("start new sql controller, err=%w", err)
 	}
 
-	cryptographer, err := cryptographer.NewAesCryptographer()
+	legacyCryptographer, err := cryptographer.NewAesCryptographer()
 	if err != nil {
 		return nil, fmt.Errorf("new aes cryptographer, err=%w", err)
 	}
 
-	authService := authservice.NewAuthService(sqlController, cryptographer)
+	accessTokenSettings, err := makeAccessTokenSettings(config)
+	if err != nil {
+		return nil, fmt.Errorf("make access token settings, err=%w", err)
+	}
+
+	authService := authservice.NewAuthService(
+		sqlController,
+		cryptographer.NewArgon2Hasher(),
+		legacyCryptographer,
+		makeNotifier(config),
+		authservice.Settings{
+			Session:          authservice.SessionSettings{IdleTimeout: config.SessionIdleTimeout, TTL: config.SessionTTL},
+			AccessToken:      accessTokenSettings,
+			PasswordResetTTL: config.PasswordResetTTL,
+			APIKeyMaxTTL:     config.APIKeyMaxTTL,
+			TOTPIssuer:       config.TOTPIssuer,
+			Throttle: authservice.ThrottleSettings{
+				MaxFailuresPerLogin: config.LoginMaxFailures,
+				MaxFailuresPerIP:    config.LoginMaxFailuresPerIP,
+				BaseDelay:           config.LoginBaseDelay,
+				Lockout:             config.LoginLockout,
+			},
+		},
+	)
+	grantAdmins(authService, config.Admins)
+
+	registrationPolicy, err := policy.New(policy.Settings{
+		LoginMinLength:      config.LoginMinLength,
+		LoginMaxLength:      config.LoginMaxLength,
+		LoginPattern:        config.LoginPattern,
+		PasswordMinLength:   config.PasswordMinLength,
+		PasswordMinClasses:  config.PasswordMinClasses,
+		CommonPasswordsFile: config.CommonPasswordsFile,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("new registration policy, err=%w", err)
+	}
+
 	ordersCtrl := orderscontroller.NewOrdersController(sqlController)
 
-	accrualCtrl := accrual.StartNewController(sqlController, config.AccrualAddress)
+	accrualCtrl := accrual.StartNewController(sqlController, config.AccrualAddress, makeAccrualSettings(config))
 
 	server := &GophermartServer{
 		sqlCtrl:           sqlController,
 		accrualCtrl:       accrualCtrl,
 		authService:       authService,
+		policy:            registrationPolicy,
 		ordersCtrl:        ordersCtrl,
 		waitingShutdownCh: make(chan struct{}),
 	}
 
-	server.initHTTPServer(config.RunAddress)
+	server.initHTTPServer(config.RunAddress, config.SecureCookie, accessTokenSettings != nil)
 
 	return server, nil
 
 }
 
-func (s *GophermartServer) initHTTPServer(addr string) {
+func grantAdmins(authService *authservice.AuthService, admins string) {
+	for _, login := range strings.Split(admins, ",") {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+
+		if err := authService.SetRole(context.Background(), login, rbac.RoleAdmin); err != nil {
+			zlog.Logger.Warnf("Grant admin role to user=%s, err=%s", login, err)
+		}
+	}
+}
+
+func makePoolSettings(cfg *config.Config) sql.PoolSettings {
+	return sql.PoolSettings{
+		MaxConns:          int32(cfg.DBMaxConns),
+		MinConns:          int32(cfg.DBMinConns),
+		MaxConnIdleTime:   cfg.DBMaxConnIdleTime,
+		HealthCheckPeriod: cfg.DBHealthCheckPeriod,
+		StatementTimeout:  cfg.DBStatementTimeout,
+	}
+}
+
+func makeAccrualSettings(cfg *config.Config) accrual.Settings {
+	return accrual.Settings{
+		Workers:         cfg.AccrualWorkers,
+		BatchSize:       cfg.AccrualBatchSize,
+		PollingInterval: cfg.AccrualPollingInterval,
+		MinBackoff:      cfg.AccrualMinBackoff,
+		MaxBackoff:      cfg.AccrualMaxBackoff,
+		MaxAttempts:     cfg.AccrualMaxAttempts,
+		MaxAge:          cfg.AccrualMaxOrderAge,
+		LeaseTTL:        cfg.AccrualLeaseTTL,
+		InstanceID:      cfg.AccrualInstanceID,
+	}
+}
+
+func makeNotifier(cfg *config.Config) notifier.Notifier {
+	if cfg.NotificationsFile != "" {
+		return notifier.NewFileNotifier(cfg.NotificationsFile)
+	}
+
+	return notifier.NewLogNotifier()
+}
+
+func makeAccessTokenSettings(cfg *config.Config) (*authservice.AccessTokenSettings, error) {
+	if cfg.AuthMode != config.AuthModeJWT {
+		return nil, nil
+	}
+
+	signer, err := jwt.NewSigner(cfg.JWTAlgorithm, cfg.JWTKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authservice.AccessTokenSettings{Signer: signer, TTL: cfg.AccessTokenTTL}, nil
+}
+
+func (s *GophermartServer) initHTTPServer(addr string, secureCookie bool, withRefresh bool) {
 	router := chi.NewRouter()
 
 	router.Use(middleware.LoggingHTTPHandler)
 
-	router.Handle(registerEndpoint, handler.NewRegistrationHandler(s.authService))
-	router.Handle(loginEndpoint, handler.NewAutentifiactionHandler(s.authService))
+	router.Handle(registerEndpoint, handler.NewRegistrationHandler(s.authService, s.policy, secureCookie))
+	router.Handle(loginEndpoint, handler.NewAutentifiactionHandler(s.authService, secureCookie))
+	router.Handle(secondFactorLoginEndpoint, handler.NewSecondFactorLoginHandler(s.authService, secureCookie))
+	router.Handle(totpEnrollEndpoint, handler.NewTOTPEnrollHandler(s.authService))
+	router.Handle(totpConfirmEndpoint, handler.NewTOTPConfirmHandler(s.authService))
+	router.Handle(totpDisableEndpoint, handler.NewTOTPDisableHandler(s.authService))
+	router.Handle(logoutEndpoint, handler.NewLogoutHandler(s.authService, secureCookie))
+	router.Handle(passwordEndpoint, handler.NewChangePasswordHandler(s.authService, s.policy))
+	router.Handle(passwordResetRequestEndpoint, handler.NewPasswordResetRequestHandler(s.authService))
+	router.Handle(passwordResetEndpoint, handler.NewPasswordResetHandler(s.authService, s.policy))
+	if withRefresh {
+		router.Handle(refreshEndpoint, handler.NewRefreshHandler(s.authService, secureCookie))
+	}
+	router.Handle(sessionsEndpoint, handler.NewSessionsHandler(s.authService))
+	router.Handle(sessionEndpoint, handler.NewSessionsHandler(s.authService))
+	router.Handle(accountEndpoint, handler.NewDeleteAccountHandler(s.authService, secureCookie))
+	router.Handle(exportEndpoint, handler.NewExportHandler(s.authService, s.sqlCtrl))
+	router.Handle(apiKeysEndpoint, handler.NewAPIKeysHandler(s.authService))
+	router.Handle(apiKeyEndpoint, handler.NewAPIKeysHandler(s.authService))
 
 	router.Handle(ordersEndpoint, handler.NewOrdersHandler(s.authService, s.ordersCtrl))
 	router.Handle(balanceEndpoint, handler.NewBalanceHandler(s.authService, s.sqlCtrl))
 	router.Handle(allWithdrawalsEndpoint, handler.NewBalanceWithdrawHandler(s.authService, s.sqlCtrl))
 	router.Handle(balanceWithdrawEndpoint, handler.NewWithdrawalsHandler(s.authService, s.sqlCtrl))
+	router.Handle(balanceAdjustmentsEndpoint, handler.NewBalanceAdjustmentsHandler(s.authService, s.sqlCtrl))
+	router.Handle(balanceHistoryEndpoint, handler.NewBalanceHistoryHandler(s.authService, s.sqlCtrl))
+
+	s.initAdminRoutes(router, middleware.NewAccessControl(s.authService))
 
 	s.srvr = http.Server{Addr: addr, Handler: router}
 }
 
+func (s *GophermartServer) initAdminRoutes(router chi.Router, access *middleware.AccessControl) {
+	router.With(access.Require(rbac.PermissionUsersRead)).Group(func(r chi.Router) {
+		r.Handle(adminUsersEndpoint, handler.NewAdminUsersSearchHandler(s.sqlCtrl))
+		r.Handle(adminUserEndpoint, handler.NewAdminUserProfileHandler(s.sqlCtrl))
+		r.Handle(adminUserOrdersEndpoint, handler.NewAdminUserOrdersHandler(s.sqlCtrl))
+		r.Handle(adminUserWithdrawalsEndpoint, handler.NewAdminUserWithdrawalsHandler(s.sqlCtrl))
+		r.Method(http.MethodGet, adminUserBalanceAdjustmentsEndpoint, handler.NewAdminUserBalanceAdjustmentsHandler(s.sqlCtrl))
+	})
+
+	router.With(access.Require(rbac.PermissionUsersBlock)).Group(func(r chi.Router) {
+		r.Handle(adminUserBlockEndpoint, handler.NewAdminUserBlockHandler(s.authService))
+		r.Handle(adminUserUnblockEndpoint, handler.NewAdminUserUnblockHandler(s.authService))
+	})
+
+	router.With(access.Require(rbac.PermissionSessionsManage)).
+		Handle(adminUserLogoutEndpoint, handler.NewAdminUserLogoutHandler(s.authService))
+
+	router.With(access.Require(rbac.PermissionBalanceAdjust)).
+		Method(http.MethodPost, adminUserBalanceAdjustmentsEndpoint, handler.NewAdminBalanceAdjustmentHandler(s.sqlCtrl))
+
+	router.With(access.Require(rbac.PermissionRolesManage)).
+		Handle(adminUserRoleEndpoint, handler.NewAdminRoleHandler(s.authService))
+
+	router.With(access.Require(rbac.PermissionSystemRead)).
+		Handle(adminDBPoolEndpoint, handler.NewAdminDBPoolStatsHandler(s.sqlCtrl))
+
+	router.With(access.Require(rbac.PermissionSystemRead)).
+		Handle(adminStuckOrdersEndpoint, handler.NewAdminStuckOrdersHandler(s.sqlCtrl))
+
+	router.With(access.Require(rbac.PermissionOrdersManage)).
+		Handle(adminOrderRetryEndpoint, handler.NewAdminOrderRetryHandler(s.sqlCtrl))
+}
+
 func (s *GophermartServer) start(hostport string) {
 	go func() {
 		defer close(s.waitingShutdownCh)
@@ -108,7 +264,20 @@ func (s *GophermartServer) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
-	return s.srvr.Shutdown(ctx)
+	shutdownErr := s.srvr.Shutdown(ctx)
+
+	// the accrual controller writes checked orders and releases its leases through the pool, so it's stopped first
+	s.accrualCtrl.Stop()
+
+	if err := s.sqlCtrl.Stop(); err != nil {
+		return fmt.Errorf("stop sql controller, err=%w", err)
+	}
+
+	if shutdownErr != nil {
+		return fmt.Errorf("shutdown http server, err=%w", shutdownErr)
+	}
+
+	return nil
 }
 
 func (s *GophermartServer) Wait() <-chan struct{} {
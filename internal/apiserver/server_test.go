@@ -0,0 +1,74 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/orderscontroller/accrual"
+	"gophermart/internal/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStopReleasesLeases runs against the database from TEST_DATABASE_URI, the rows made by the test are left there
+func TestStopReleasesLeases(t *testing.T) {
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI isn't set")
+	}
+
+	release := make(chan struct{})
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrualSystem.Close()
+	defer close(release)
+
+	ctx := context.Background()
+	login := fmt.Sprintf("stop-%d", time.Now().UnixNano())
+	orderID := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	sqlCtrl, err := sql.StartNewController(uri, sql.PoolSettings{})
+	require.NoError(t, err)
+	require.NoError(t, sqlCtrl.CreateUser(ctx, login, "hash"))
+	require.NoError(t, sqlCtrl.CreateOrder(ctx, login, orderID))
+
+	server := &GophermartServer{
+		sqlCtrl: sqlCtrl,
+		accrualCtrl: accrual.StartNewController(sqlCtrl, accrualSystem.URL, accrual.Settings{
+			Workers:         1,
+			BatchSize:       1000,
+			PollingInterval: time.Millisecond * 10,
+			LeaseTTL:        time.Hour,
+		}),
+		waitingShutdownCh: make(chan struct{}),
+	}
+
+	require.Eventually(t, func() bool {
+		order, err := sqlCtrl.FindOrder(ctx, orderID)
+		return err == nil && order.LeaseOwner != nil
+	}, time.Second*5, time.Millisecond*10)
+
+	require.NoError(t, server.Stop())
+
+	// the pool of the stopped server is closed, so the order is read by another controller
+	checker, err := sql.StartNewController(uri, sql.PoolSettings{})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, checker.Stop())
+	}()
+
+	order, err := checker.FindOrder(ctx, orderID)
+	require.NoError(t, err)
+	require.Nil(t, order.LeaseOwner)
+}
@@ -0,0 +1,52 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+)
+
+const anonymizedLoginPrefix = "deleted:"
+
+// DeleteAccount checks the password and removes the user with all user's data, sessions are closed too.
+// Wrong passwords are counted by the login throttle, so the stolen session can't be used to guess the password
+func (s *AuthService) DeleteAccount(ctx context.Context, login string, password string, client *handler.ClientInfo) error {
+	if err := s.checkLoginThrottle(ctx, login, client); err != nil {
+		s.audit(ctx, login, sql.AuthEventLoginThrottled, err.Error(), client)
+		return err
+	}
+
+	user, err := s.store.FindUser(ctx, login)
+	if err != nil {
+		return fmt.Errorf("get user info login=%s, err=%w", login, err)
+	}
+
+	if err := s.checkPassword(ctx, user, password); err != nil {
+		if errors.Is(err, handler.ErrIsNotAutorized) {
+			s.registerLoginFailure(ctx, login, client)
+			s.audit(ctx, login, sql.AuthEventLoginFailed, err.Error(), client)
+		}
+
+		return err
+	}
+
+	anonymizedLogin := anonymizeLogin(login)
+
+	if err := s.store.DeleteUser(ctx, login, anonymizedLogin); err != nil {
+		return fmt.Errorf("delete user=%s, err=%w", login, err)
+	}
+
+	s.audit(ctx, anonymizedLogin, sql.AuthEventUserDeleted, "", client)
+	zlog.Logger.Infof("User=%s was deleted", anonymizedLogin)
+
+	return nil
+}
+
+// anonymizeLogin keeps the records of the same deleted user linked without revealing the login
+func anonymizeLogin(login string) string {
+	return anonymizedLoginPrefix + cryptographer.HashToken(login)[:16]
+}
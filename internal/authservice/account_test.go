@@ -0,0 +1,37 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"gophermart/internal/apiserver/handler"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteAccountPasswordIsThrottled(t *testing.T) {
+	ctx := context.Background()
+	client := &handler.ClientInfo{UserAgent: "test", IP: "127.0.0.1"}
+	s := newTestService(t, nil)
+
+	_, err := s.Register(ctx, "user", "password", client)
+	require.NoError(t, err)
+
+	// after half of the max failures every next attempt is delayed
+	for i := 0; i < s.throttleSettings.MaxFailuresPerLogin/2; i++ {
+		require.ErrorIs(t, s.DeleteAccount(ctx, "user", "wrong", client), handler.ErrIsNotAutorized)
+	}
+
+	var tooManyAttemptsErr *handler.TooManyAttemptsError
+
+	// the right password isn't checked while the login is locked, neither by login nor by deletion
+	err = s.DeleteAccount(ctx, "user", "password", client)
+	require.True(t, errors.As(err, &tooManyAttemptsErr))
+
+	_, err = s.Authorize(ctx, "user", "password", client)
+	require.True(t, errors.As(err, &tooManyAttemptsErr))
+
+	_, err = s.store.FindUser(ctx, "user")
+	assert.NoError(t, err)
+}
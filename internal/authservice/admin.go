@@ -0,0 +1,74 @@
+package authservice
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+)
+
+// SetRole changes user's role, in jwt auth mode the new role is applied after the access token refreshing
+func (s *AuthService) SetRole(ctx context.Context, login string, role rbac.Role) error {
+	if err := s.store.UpdateUserRole(ctx, login, string(role)); err != nil {
+		return fmt.Errorf("update role of user=%s, err=%w", login, err)
+	}
+
+	s.auditOperatorAction(ctx, login, sql.AuthEventUserRoleChanged, "role="+string(role))
+
+	return nil
+}
+
+// BlockUser forbids user's login and closes all user's sessions
+func (s *AuthService) BlockUser(ctx context.Context, login string) error {
+	if err := s.store.BlockUser(ctx, login); err != nil {
+		return fmt.Errorf("block user=%s, err=%w", login, err)
+	}
+
+	s.auditOperatorAction(ctx, login, sql.AuthEventUserBlocked, "")
+
+	return nil
+}
+
+func (s *AuthService) UnblockUser(ctx context.Context, login string) error {
+	if err := s.store.UnblockUser(ctx, login); err != nil {
+		return fmt.Errorf("unblock user=%s, err=%w", login, err)
+	}
+
+	s.auditOperatorAction(ctx, login, sql.AuthEventUserUnblocked, "")
+
+	return nil
+}
+
+// LogoutUser closes all user's sessions
+func (s *AuthService) LogoutUser(ctx context.Context, login string) error {
+	if _, err := s.store.FindUser(ctx, login); err != nil {
+		return fmt.Errorf("find user=%s, err=%w", login, err)
+	}
+
+	if err := s.store.DeleteUserSessions(ctx, login); err != nil {
+		return fmt.Errorf("logout user=%s, err=%w", login, err)
+	}
+
+	s.auditOperatorAction(ctx, login, sql.AuthEventUserLoggedOut, "")
+
+	return nil
+}
+
+// auditOperatorAction records action of the operator from the context,
+// actions without operator are made by the service itself on start
+func (s *AuthService) auditOperatorAction(ctx context.Context, login string, event sql.AuthEvent, details string) {
+	operator := "system"
+	if principal, ok := rbac.PrincipalFromContext(ctx); ok {
+		operator = principal.Login
+	}
+
+	if details != "" {
+		details += ", "
+	}
+	details += "operator=" + operator
+
+	zlog.Logger.Infof("User=%s event=%s %s", login, event, details)
+	s.audit(ctx, login, event, details, &handler.ClientInfo{})
+}
@@ -0,0 +1,103 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"time"
+)
+
+const (
+	apiKeyPrefix       = "gm_"
+	apiKeyPrefixLength = len(apiKeyPrefix) + 6
+
+	// last usage time is approximate so the key isn't updated in the database on every request
+	apiKeyTouchInterval = time.Minute
+)
+
+// CreateAPIKey issues a key for server-to-server requests on behalf of the user,
+// the key itself is returned only here, the database keeps its digest
+func (s *AuthService) CreateAPIKey(
+	ctx context.Context,
+	login string,
+	name string,
+	scopes rbac.Scopes,
+	expiresAt time.Time,
+) (*sql.APIKey, string, error) {
+	now := time.Now()
+	maxExpiresAt := now.Add(s.apiKeyMaxTTL)
+
+	if expiresAt.IsZero() {
+		expiresAt = maxExpiresAt
+	}
+
+	if !expiresAt.After(now) || expiresAt.After(maxExpiresAt) {
+		return nil, "", fmt.Errorf("expires_at=%s isn't in (now, now+%s], err=%w", expiresAt, s.apiKeyMaxTTL, handler.ErrBadAPIKeyRequest)
+	}
+
+	token, err := cryptographer.GenerateToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate api key, err=%w", err)
+	}
+
+	key := apiKeyPrefix + token
+
+	apiKey := &sql.APIKey{
+		Login:     login,
+		Name:      name,
+		KeyHash:   cryptographer.HashToken(key),
+		Prefix:    key[:apiKeyPrefixLength],
+		Scopes:    scopes.String(),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := s.store.CreateAPIKey(ctx, apiKey); err != nil {
+		return nil, "", fmt.Errorf("create api key for user=%s, err=%w", login, err)
+	}
+
+	return apiKey, key, nil
+}
+
+func (s *AuthService) GetAPIKeys(ctx context.Context, login string) ([]*sql.APIKey, error) {
+	return s.store.GetUserAPIKeys(ctx, login)
+}
+
+func (s *AuthService) RevokeAPIKey(ctx context.Context, login string, id int64) error {
+	return s.store.DeleteAPIKey(ctx, login, id)
+}
+
+// CheckAPIKey returns owner of the key if the key is valid and has the scope
+func (s *AuthService) CheckAPIKey(ctx context.Context, key string, scope rbac.Scope) (string, error) {
+	apiKey, err := s.store.FindAPIKey(ctx, cryptographer.HashToken(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrAPIKeyIsNotFound) {
+			return "", fmt.Errorf("api key isn't found, err=%w", handler.ErrIsNotAutorized)
+		}
+
+		return "", err
+	}
+
+	now := time.Now()
+
+	if apiKey.Blocked || now.After(apiKey.ExpiresAt) {
+		return "", fmt.Errorf("api key=%d of user=%s is expired or user is blocked, err=%w", apiKey.ID, apiKey.Login, handler.ErrIsNotAutorized)
+	}
+
+	if !rbac.SplitScopes(apiKey.Scopes).Contains(scope) {
+		return "", fmt.Errorf("api key=%d hasn't scope=%s, err=%w", apiKey.ID, scope, handler.ErrAccessIsForbidden)
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.store.TouchAPIKey(ctx, apiKey.ID, now); err != nil {
+			zlog.Logger.Errorf("touch api key=%d, err=%s", apiKey.ID, err)
+		}
+	}
+
+	return apiKey.Login, nil
+}
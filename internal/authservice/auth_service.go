@@ -6,91 +6,219 @@ import (
 	"fmt"
 	"gophermart/internal/apiserver/handler"
 	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/notifier"
 	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"gophermart/internal/zlog"
+	"time"
 )
 
 var ErrIsAlreadySaved = errors.New("is already saved")
 var ErrIsNotContains = sql.ErrUserIsNotFound
 
+type Settings struct {
+	Session SessionSettings
+	// access tokens are issued only in jwt auth mode, nil otherwise
+	AccessToken *AccessTokenSettings
+
+	PasswordResetTTL time.Duration
+	Throttle         ThrottleSettings
+
+	// keys are issued for this period if the user doesn't ask for less
+	APIKeyMaxTTL time.Duration
+
+	// is shown in the authenticator app next to the login
+	TOTPIssuer string
+}
+
 type AuthService struct {
-	sqlCtrl       *sql.Controller
-	cryptographer cryptographer.Cryptographer
+	store    storage.AuthStorage
+	hasher   cryptographer.PasswordHasher
+	notifier notifier.Notifier
+
+	sessionSettings     SessionSettings
+	accessTokenSettings *AccessTokenSettings
+	passwordResetTTL    time.Duration
+	throttleSettings    ThrottleSettings
+	apiKeyMaxTTL        time.Duration
+	totpIssuer          string
+
+	// is used only for checking credentials of users registred before password hashing
+	legacyCryptographer cryptographer.Cryptographer
 }
 
-func NewAuthService(sqlCtrl *sql.Controller, cryptographer cryptographer.Cryptographer) *AuthService {
+func NewAuthService(
+	store storage.AuthStorage,
+	hasher cryptographer.PasswordHasher,
+	legacyCryptographer cryptographer.Cryptographer,
+	notifier notifier.Notifier,
+	settings Settings,
+) *AuthService {
 	return &AuthService{
-		sqlCtrl:       sqlCtrl,
-		cryptographer: cryptographer,
+		store:               store,
+		hasher:              hasher,
+		notifier:            notifier,
+		sessionSettings:     settings.Session,
+		accessTokenSettings: settings.AccessToken,
+		passwordResetTTL:    settings.PasswordResetTTL,
+		throttleSettings:    settings.Throttle,
+		apiKeyMaxTTL:        settings.APIKeyMaxTTL,
+		totpIssuer:          settings.TOTPIssuer,
+		legacyCryptographer: legacyCryptographer,
 	}
 }
 
-func (s *AuthService) Register(ctx context.Context, login string, password string) (string, error) {
-	key, err := s.calcUserKey(login, password)
+func (s *AuthService) Register(
+	ctx context.Context,
+	login string,
+	password string,
+	client *handler.ClientInfo,
+) (*handler.Credentials, error) {
+	passwordHash, err := s.hasher.Hash(password)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("hash password of user=%s, err=%w", login, err)
 	}
 
-	if err := s.saveUser(ctx, login, key); err != nil {
+	if err := s.saveUser(ctx, login, passwordHash); err != nil {
 		if errors.Is(err, ErrIsAlreadySaved) {
-			return "", fmt.Errorf("user with login=%s already registred, err=%w", login, handler.ErrIsAlreadyRegistred)
+			return nil, fmt.Errorf("user with login=%s already registred, err=%w", login, handler.ErrIsAlreadyRegistred)
 		}
 
-		return "", fmt.Errorf("save user's info login=%s, err=%w", login, err)
+		return nil, fmt.Errorf("save user's info login=%s, err=%w", login, err)
 	}
 
-	return key, nil
+	return s.newSession(ctx, login, rbac.RoleUser, client)
 }
 
-func (s *AuthService) saveUser(ctx context.Context, login string, token string) error {
-	_, err := s.sqlCtrl.FindUser(ctx, login)
-	if err != nil {
-		if errors.Is(err, sql.ErrUserIsNotFound) {
-			return s.sqlCtrl.CreateUser(ctx, login, token)
-		}
+func (s *AuthService) saveUser(ctx context.Context, login string, passwordHash string) error {
+	_, err := s.store.FindUserIgnoreCase(ctx, login)
+	if err == nil {
+		return ErrIsAlreadySaved
+	}
 
+	if !errors.Is(err, sql.ErrUserIsNotFound) {
 		return fmt.Errorf("find user=%s, err=%w", login, err)
 	}
 
-	return ErrIsAlreadySaved
+	if err := s.store.CreateUser(ctx, login, passwordHash); err != nil {
+		if errors.Is(err, sql.ErrUserAlreadyExist) {
+			return ErrIsAlreadySaved
+		}
+
+		return err
+	}
+
+	return nil
 }
 
-func (s *AuthService) Authorize(ctx context.Context, login string, password string) (string, error) {
-	userInfo, err := s.sqlCtrl.FindUser(ctx, login)
+func (s *AuthService) Authorize(
+	ctx context.Context,
+	login string,
+	password string,
+	client *handler.ClientInfo,
+) (*handler.Credentials, error) {
+	if err := s.checkLoginThrottle(ctx, login, client); err != nil {
+		s.audit(ctx, login, sql.AuthEventLoginThrottled, err.Error(), client)
+		return nil, err
+	}
+
+	user, err := s.authorize(ctx, login, password)
 	if err != nil {
-		if errors.Is(err, ErrIsNotContains) {
-			return "", fmt.Errorf("wasn't registred, err=%w", handler.ErrIsNotAutorized)
+		if errors.Is(err, handler.ErrIsNotAutorized) {
+			s.registerLoginFailure(ctx, login, client)
+			s.audit(ctx, login, sql.AuthEventLoginFailed, err.Error(), client)
 		}
 
-		return "", fmt.Errorf("get user info login=%s, err=%w", login, err)
+		return nil, err
 	}
 
-	key, err := s.calcUserKey(login, password)
+	secondFactor, err := s.hasSecondFactor(ctx, login)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if userInfo.AuthToken != key {
-		return "", fmt.Errorf("bad password, err=%w", handler.ErrIsNotAutorized)
+	if secondFactor {
+		s.audit(ctx, login, sql.AuthEventSecondFactorRequired, "", client)
+		return nil, s.newLoginChallenge(ctx, login)
 	}
 
-	return userInfo.AuthToken, nil
+	return s.completeLogin(ctx, user, client)
 }
 
-func (s *AuthService) Check(ctx context.Context, userKey string) (string, error) {
-	info, err := s.sqlCtrl.FindUserByToken(ctx, userKey)
+// completeLogin opens session of the user who passed all checks
+func (s *AuthService) completeLogin(ctx context.Context, user *sql.User, client *handler.ClientInfo) (*handler.Credentials, error) {
+	s.resetLoginThrottle(ctx, user.Login)
+	s.audit(ctx, user.Login, sql.AuthEventLoginSucceeded, "", client)
+
+	return s.newSession(ctx, user.Login, rbac.Role(user.Role), client)
+}
+
+func (s *AuthService) authorize(ctx context.Context, login string, password string) (*sql.User, error) {
+	userInfo, err := s.store.FindUser(ctx, login)
 	if err != nil {
 		if errors.Is(err, ErrIsNotContains) {
-			return "", fmt.Errorf("wasn't registred, err=%w", handler.ErrIsNotAutorized)
+			return nil, fmt.Errorf("wasn't registred, err=%w", handler.ErrIsNotAutorized)
+		}
+
+		return nil, fmt.Errorf("get user info login=%s, err=%w", login, err)
+	}
+
+	if err := s.checkPassword(ctx, userInfo, password); err != nil {
+		return nil, err
+	}
+
+	if userInfo.Blocked {
+		return nil, fmt.Errorf("login=%s, err=%w", login, handler.ErrUserIsBlocked)
+	}
+
+	return userInfo, nil
+}
+
+func (s *AuthService) checkPassword(ctx context.Context, user *sql.User, password string) error {
+	if user.PasswordHash == "" {
+		return s.checkLegacyPassword(ctx, user, password)
+	}
+
+	if err := s.hasher.Compare(user.PasswordHash, password); err != nil {
+		if errors.Is(err, cryptographer.ErrPasswordMismatch) {
+			return fmt.Errorf("bad password, err=%w", handler.ErrIsNotAutorized)
 		}
 
-		return "", err
+		return fmt.Errorf("compare password of user=%s, err=%w", user.Login, err)
+	}
+
+	return nil
+}
+
+// checkLegacyPassword compares password with the token of the old scheme
+// and replaces it by the password hash on success
+func (s *AuthService) checkLegacyPassword(ctx context.Context, user *sql.User, password string) error {
+	key, err := s.calcLegacyUserKey(user.Login, password)
+	if err != nil {
+		return err
 	}
 
-	return info.Login, nil
+	if user.LegacyToken == "" || user.LegacyToken != key {
+		return fmt.Errorf("bad password, err=%w", handler.ErrIsNotAutorized)
+	}
+
+	passwordHash, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("hash password of user=%s, err=%w", user.Login, err)
+	}
+
+	if err := s.store.UpdateUserPasswordHash(ctx, user.Login, passwordHash); err != nil {
+		return fmt.Errorf("migrate password of user=%s, err=%w", user.Login, err)
+	}
+
+	zlog.Logger.Infof("User=%s password was migrated to hash", user.Login)
+
+	return nil
 }
 
-func (s *AuthService) calcUserKey(login string, password string) (string, error) {
-	key, err := s.cryptographer.Encrypt(userDataToString(login, password))
+func (s *AuthService) calcLegacyUserKey(login string, password string) (string, error) {
+	key, err := s.legacyCryptographer.Encrypt(userDataToString(login, password))
 	if err != nil {
 		return "", fmt.Errorf("calc user key, err=%w", err)
 	}
@@ -0,0 +1,97 @@
+package cryptographer
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password doesn't match hash")
+	ErrBadPasswordHash  = errors.New("password hash has unsupported format")
+)
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Compare(hash string, password string) error
+}
+
+// argon2id parameters recommended by RFC 9106 for memory constrained environments
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 2
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// Argon2Hasher stores passwords in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2Hasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+func NewArgon2Hasher() *Argon2Hasher {
+	return &Argon2Hasher{
+		time:    argon2Time,
+		memory:  argon2Memory,
+		threads: argon2Threads,
+	}
+}
+
+func (h *Argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt, err=%w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2Hasher) Compare(hash string, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return ErrBadPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return ErrBadPasswordHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return errors.Join(ErrBadPasswordHash, err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return errors.Join(ErrBadPasswordHash, err)
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return errors.Join(ErrBadPasswordHash, err)
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(expected, actual) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
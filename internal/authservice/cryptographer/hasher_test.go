@@ -0,0 +1,22 @@
+package cryptographer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArgon2Hasher(t *testing.T) {
+	hasher := NewArgon2Hasher()
+
+	hash, err := hasher.Hash("1234")
+	require.NoError(t, err)
+
+	require.NoError(t, hasher.Compare(hash, "1234"))
+	require.ErrorIs(t, hasher.Compare(hash, "4321"), ErrPasswordMismatch)
+	require.ErrorIs(t, hasher.Compare("K7Cask9SYldqCOzkOJAOFLLpXARQLsqVoVY=", "1234"), ErrBadPasswordHash)
+
+	otherHash, err := hasher.Hash("1234")
+	require.NoError(t, err)
+	require.NotEqual(t, hash, otherHash)
+}
@@ -0,0 +1,105 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const minHS256KeySize = 32
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported jwt signing algorithm")
+	ErrBadSigningKey        = errors.New("bad jwt signing key")
+)
+
+type Signer interface {
+	Algorithm() string
+	Sign(data []byte) ([]byte, error)
+	Verify(data []byte, signature []byte) bool
+}
+
+// NewSigner makes signer by algorithm name. HS256 key is a secret string at least 32 bytes long,
+// EdDSA key is a base64 encoded 32 bytes seed of the ed25519 private key.
+func NewSigner(algorithm string, key string) (Signer, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		return NewHS256Signer([]byte(key))
+	case AlgorithmEdDSA:
+		seed, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, errors.Join(ErrBadSigningKey, err)
+		}
+
+		return NewEdDSASigner(seed)
+	default:
+		return nil, fmt.Errorf("algorithm=%s, err=%w", algorithm, ErrUnsupportedAlgorithm)
+	}
+}
+
+type HS256Signer struct {
+	key []byte
+}
+
+func NewHS256Signer(key []byte) (*HS256Signer, error) {
+	if len(key) < minHS256KeySize {
+		return nil, fmt.Errorf("key must be at least %d bytes long, err=%w", minHS256KeySize, ErrBadSigningKey)
+	}
+
+	return &HS256Signer{key: key}, nil
+}
+
+func (s *HS256Signer) Algorithm() string {
+	return AlgorithmHS256
+}
+
+func (s *HS256Signer) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+
+	return mac.Sum(nil), nil
+}
+
+func (s *HS256Signer) Verify(data []byte, signature []byte) bool {
+	expected, _ := s.Sign(data)
+
+	return hmac.Equal(expected, signature)
+}
+
+type EdDSASigner struct {
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+func NewEdDSASigner(seed []byte) (*EdDSASigner, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("seed must be %d bytes long, err=%w", ed25519.SeedSize, ErrBadSigningKey)
+	}
+
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	return &EdDSASigner{
+		privateKey: privateKey,
+		publicKey:  privateKey.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+func (s *EdDSASigner) Algorithm() string {
+	return AlgorithmEdDSA
+}
+
+func (s *EdDSASigner) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+func (s *EdDSASigner) Verify(data []byte, signature []byte) bool {
+	return ed25519.Verify(s.publicKey, data, signature)
+}
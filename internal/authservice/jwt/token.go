@@ -0,0 +1,112 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken      = errors.New("malformed jwt")
+	ErrUnexpectedAlgorithm = errors.New("unexpected jwt algorithm")
+	ErrBadSignature        = errors.New("bad jwt signature")
+	ErrTokenExpired        = errors.New("jwt is expired")
+)
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role,omitempty"`
+	SessionID int64  `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func Encode(signer Signer, claims *Claims) (string, error) {
+	headerData, err := json.Marshal(&header{Algorithm: signer.Algorithm(), Type: "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("marshal jwt header, err=%w", err)
+	}
+
+	claimsData, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwt claims, err=%w", err)
+	}
+
+	signingInput := encodeSegment(headerData) + "." + encodeSegment(claimsData)
+
+	signature, err := signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("sign jwt, err=%w", err)
+	}
+
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+// Decode verifies token's signature and expiration time. The algorithm from the token's header
+// must be the same as the signer's algorithm, so the token can't be downgraded to "none".
+func Decode(signer Signer, token string, now time.Time) (*Claims, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	headerData, err := decodeSegment(segments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	h := &header{}
+	if err := json.Unmarshal(headerData, h); err != nil {
+		return nil, errors.Join(ErrMalformedToken, err)
+	}
+
+	if h.Algorithm != signer.Algorithm() {
+		return nil, fmt.Errorf("alg=%s, err=%w", h.Algorithm, ErrUnexpectedAlgorithm)
+	}
+
+	signature, err := decodeSegment(segments[2])
+	if err != nil {
+		return nil, err
+	}
+
+	if !signer.Verify([]byte(segments[0]+"."+segments[1]), signature) {
+		return nil, ErrBadSignature
+	}
+
+	claimsData, err := decodeSegment(segments[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := json.Unmarshal(claimsData, claims); err != nil {
+		return nil, errors.Join(ErrMalformedToken, err)
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return nil, errors.Join(ErrMalformedToken, err)
+	}
+
+	return data, nil
+}
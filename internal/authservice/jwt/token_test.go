@@ -0,0 +1,48 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	seed := make([]byte, 32)
+	_, err := rand.Read(seed)
+	require.NoError(t, err)
+
+	hs256, err := NewHS256Signer([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	eddsa, err := NewEdDSASigner(seed)
+	require.NoError(t, err)
+
+	now := time.Now()
+	claims := &Claims{Subject: "user1", SessionID: 7, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+
+	for _, signer := range []Signer{hs256, eddsa} {
+		token, err := Encode(signer, claims)
+		require.NoError(t, err)
+
+		decoded, err := Decode(signer, token, now)
+		require.NoError(t, err)
+		require.Equal(t, claims, decoded)
+
+		_, err = Decode(signer, token, now.Add(time.Hour))
+		require.ErrorIs(t, err, ErrTokenExpired)
+
+		_, err = Decode(signer, token[:len(token)-2]+"AA", now)
+		require.Error(t, err)
+	}
+
+	token, err := Encode(hs256, claims)
+	require.NoError(t, err)
+
+	_, err = Decode(eddsa, token, now)
+	require.ErrorIs(t, err, ErrUnexpectedAlgorithm)
+
+	_, err = NewHS256Signer([]byte("short"))
+	require.ErrorIs(t, err, ErrBadSigningKey)
+}
@@ -0,0 +1,119 @@
+package authservice
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"time"
+)
+
+// ThrottleSettings limits failed login attempts. After half of the max failures every next attempt
+// is delayed twice longer than the previous one starting from BaseDelay, after max failures
+// the key is locked for Lockout. Failures are forgotten after Lockout without new failures.
+type ThrottleSettings struct {
+	MaxFailuresPerLogin int
+	MaxFailuresPerIP    int
+	BaseDelay           time.Duration
+	Lockout             time.Duration
+}
+
+func loginThrottleKey(login string) string {
+	return "login:" + login
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLoginThrottle returns error if the login or the client address is blocked
+func (s *AuthService) checkLoginThrottle(ctx context.Context, login string, client *handler.ClientInfo) error {
+	throttles, err := s.store.GetLoginThrottles(ctx, []string{loginThrottleKey(login), ipThrottleKey(client.IP)})
+	if err != nil {
+		return fmt.Errorf("get login throttles of user=%s, err=%w", login, err)
+	}
+
+	var blockedUntil time.Time
+	for _, throttle := range throttles {
+		if throttle.BlockedUntil.After(blockedUntil) {
+			blockedUntil = throttle.BlockedUntil
+		}
+	}
+
+	retryAfter := time.Until(blockedUntil)
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	return &handler.TooManyAttemptsError{RetryAfter: retryAfter}
+}
+
+func (s *AuthService) registerLoginFailure(ctx context.Context, login string, client *handler.ClientInfo) {
+	s.addLoginFailure(ctx, loginThrottleKey(login), s.throttleSettings.MaxFailuresPerLogin)
+	s.addLoginFailure(ctx, ipThrottleKey(client.IP), s.throttleSettings.MaxFailuresPerIP)
+}
+
+func (s *AuthService) addLoginFailure(ctx context.Context, key string, maxFailures int) {
+	now := time.Now()
+
+	failures, err := s.store.AddLoginFailure(ctx, key, now, now.Add(-s.throttleSettings.Lockout))
+	if err != nil {
+		zlog.Logger.Errorf("add login failure key=%s, err=%s", key, err)
+		return
+	}
+
+	delay := failureDelay(failures, maxFailures, s.throttleSettings.BaseDelay, s.throttleSettings.Lockout)
+	if delay == 0 {
+		return
+	}
+
+	if err := s.store.BlockLogin(ctx, key, now.Add(delay)); err != nil {
+		zlog.Logger.Errorf("block login key=%s, err=%s", key, err)
+	}
+}
+
+// resetLoginThrottle forgets failures of the login, failures of the client address are kept,
+// otherwise attacker could reset them by logging into own account
+func (s *AuthService) resetLoginThrottle(ctx context.Context, login string) {
+	if err := s.store.DeleteLoginThrottle(ctx, loginThrottleKey(login)); err != nil {
+		zlog.Logger.Errorf("reset login throttle of user=%s, err=%s", login, err)
+	}
+}
+
+func failureDelay(failures int, maxFailures int, baseDelay time.Duration, lockout time.Duration) time.Duration {
+	if failures >= maxFailures {
+		return lockout
+	}
+
+	delayed := failures - maxFailures/2
+	if delayed < 0 {
+		return 0
+	}
+
+	delay := baseDelay
+	for i := 0; i < delayed && delay < lockout; i++ {
+		delay *= 2
+	}
+
+	if delay > lockout {
+		return lockout
+	}
+
+	return delay
+}
+
+func (s *AuthService) audit(ctx context.Context, login string, event sql.AuthEvent, details string, client *handler.ClientInfo) {
+	record := &sql.AuthAuditRecord{
+		Login:     login,
+		Event:     event,
+		Details:   details,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.store.AddAuthAuditRecord(ctx, record); err != nil {
+		zlog.Logger.Errorf("add auth audit record=%+v, err=%s", record, err)
+	}
+}
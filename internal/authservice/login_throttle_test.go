@@ -0,0 +1,21 @@
+package authservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureDelay(t *testing.T) {
+	base := time.Second
+	lockout := time.Minute
+
+	require.Equal(t, time.Duration(0), failureDelay(1, 10, base, lockout))
+	require.Equal(t, time.Duration(0), failureDelay(4, 10, base, lockout))
+	require.Equal(t, time.Second, failureDelay(5, 10, base, lockout))
+	require.Equal(t, 2*time.Second, failureDelay(6, 10, base, lockout))
+	require.Equal(t, 16*time.Second, failureDelay(9, 10, base, lockout))
+	require.Equal(t, lockout, failureDelay(10, 10, base, lockout))
+	require.Equal(t, lockout, failureDelay(40, 50, base, lockout))
+}
@@ -0,0 +1,98 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"time"
+)
+
+// ChangePassword checks the old password, sets the new one and closes all user's sessions except the current one
+func (s *AuthService) ChangePassword(ctx context.Context, login string, userKey string, oldPassword string, newPassword string) error {
+	user, err := s.store.FindUser(ctx, login)
+	if err != nil {
+		return fmt.Errorf("get user info login=%s, err=%w", login, err)
+	}
+
+	if err := s.checkPassword(ctx, user, oldPassword); err != nil {
+		return err
+	}
+
+	current, err := s.currentSession(ctx, userKey)
+	if err != nil {
+		return fmt.Errorf("get current session of user=%s, err=%w", login, err)
+	}
+
+	passwordHash, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("hash password of user=%s, err=%w", login, err)
+	}
+
+	if err := s.store.ChangePassword(ctx, login, passwordHash, current.ID); err != nil {
+		return fmt.Errorf("change password of user=%s, err=%w", login, err)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset sends single-use reset token to user. Unknown login isn't reported
+// to the caller, so the request can't be used for checking which logins are registred.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, login string) error {
+	if _, err := s.store.FindUser(ctx, login); err != nil {
+		if errors.Is(err, sql.ErrUserIsNotFound) {
+			zlog.Logger.Infof("Password reset is requested for unknown user=%s", login)
+			return nil
+		}
+
+		return fmt.Errorf("get user info login=%s, err=%w", login, err)
+	}
+
+	token, err := cryptographer.GenerateToken()
+	if err != nil {
+		return fmt.Errorf("generate password reset token, err=%w", err)
+	}
+
+	now := time.Now()
+	resetToken := &sql.PasswordResetToken{
+		Token:     cryptographer.HashToken(token),
+		Login:     login,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.passwordResetTTL),
+	}
+
+	if err := s.store.CreatePasswordResetToken(ctx, resetToken); err != nil {
+		return fmt.Errorf("save password reset token of user=%s, err=%w", login, err)
+	}
+
+	message := fmt.Sprintf("Password reset token: %s, it expires at %s", token, resetToken.ExpiresAt.Format(time.RFC3339))
+	if err := s.notifier.Notify(ctx, login, message); err != nil {
+		return fmt.Errorf("send password reset token to user=%s, err=%w", login, err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets the new password by the reset token and closes all user's sessions
+func (s *AuthService) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	passwordHash, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("hash password, err=%w", err)
+	}
+
+	login, err := s.store.ResetPassword(ctx, cryptographer.HashToken(token), passwordHash, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrPasswordResetTokenIsNotFound) {
+			return fmt.Errorf("reset password, err=%w", handler.ErrBadPasswordResetToken)
+		}
+
+		return fmt.Errorf("reset password, err=%w", err)
+	}
+
+	zlog.Logger.Infof("Password of user=%s was reset", login)
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	RuleLoginLength        = "login_length"
+	RuleLoginCharset       = "login_charset"
+	RulePasswordLength     = "password_length"
+	RulePasswordComplexity = "password_complexity"
+	RulePasswordCommon     = "password_common"
+)
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Settings struct {
+	LoginMinLength int
+	LoginMaxLength int
+	// login must match the pattern if it's set
+	LoginPattern string
+
+	PasswordMinLength int
+	// number of character classes (lower case, upper case, digits, others) required in password
+	PasswordMinClasses int
+	// file with common passwords, one per line
+	CommonPasswordsFile string
+}
+
+type Policy struct {
+	settings        Settings
+	loginPattern    *regexp.Regexp
+	commonPasswords map[string]struct{}
+}
+
+func New(settings Settings) (*Policy, error) {
+	p := &Policy{settings: settings, commonPasswords: map[string]struct{}{}}
+
+	if settings.LoginPattern != "" {
+		pattern, err := regexp.Compile(settings.LoginPattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile login pattern=%s, err=%w", settings.LoginPattern, err)
+		}
+
+		p.loginPattern = pattern
+	}
+
+	if settings.CommonPasswordsFile != "" {
+		if err := p.loadCommonPasswords(settings.CommonPasswordsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Policy) loadCommonPasswords(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open common passwords file=%s, err=%w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.commonPasswords[strings.ToLower(password)] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read common passwords file=%s, err=%w", path, err)
+	}
+
+	return nil
+}
+
+// Check returns all rules which login and password violate
+func (p *Policy) Check(login string, password string) []Violation {
+	violations := make([]Violation, 0)
+
+	loginLength := utf8.RuneCountInString(login)
+	if loginLength < p.settings.LoginMinLength || (p.settings.LoginMaxLength > 0 && loginLength > p.settings.LoginMaxLength) {
+		violations = append(violations, Violation{
+			Rule:    RuleLoginLength,
+			Message: fmt.Sprintf("login must be from %d to %d characters long", p.settings.LoginMinLength, p.settings.LoginMaxLength),
+		})
+	}
+
+	if p.loginPattern != nil && !p.loginPattern.MatchString(login) {
+		violations = append(violations, Violation{
+			Rule:    RuleLoginCharset,
+			Message: fmt.Sprintf("login must match %s", p.settings.LoginPattern),
+		})
+	}
+
+	return append(violations, p.CheckPassword(password)...)
+}
+
+// CheckPassword returns password rules which password violates
+func (p *Policy) CheckPassword(password string) []Violation {
+	violations := make([]Violation, 0)
+
+	if utf8.RuneCountInString(password) < p.settings.PasswordMinLength {
+		violations = append(violations, Violation{
+			Rule:    RulePasswordLength,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.settings.PasswordMinLength),
+		})
+	}
+
+	if countCharClasses(password) < p.settings.PasswordMinClasses {
+		violations = append(violations, Violation{
+			Rule: RulePasswordComplexity,
+			Message: fmt.Sprintf("password must contain at least %d of: lower case letters, upper case letters, digits, other characters",
+				p.settings.PasswordMinClasses),
+		})
+	}
+
+	if _, ok := p.commonPasswords[strings.ToLower(password)]; ok {
+		violations = append(violations, Violation{
+			Rule:    RulePasswordCommon,
+			Message: "password is too common",
+		})
+	}
+
+	return violations
+}
+
+func countCharClasses(password string) int {
+	var lower, upper, digit, other int
+
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rules(violations []Violation) []string {
+	result := make([]string, 0, len(violations))
+	for _, v := range violations {
+		result = append(result, v.Rule)
+	}
+
+	return result
+}
+
+func TestPolicyCheck(t *testing.T) {
+	commonPasswords := filepath.Join(t.TempDir(), "common.txt")
+	require.NoError(t, os.WriteFile(commonPasswords, []byte("qwerty123\nPassword1!\n"), 0600))
+
+	p, err := New(Settings{
+		LoginMinLength:      3,
+		LoginMaxLength:      8,
+		LoginPattern:        `^[a-z0-9]+$`,
+		PasswordMinLength:   8,
+		PasswordMinClasses:  3,
+		CommonPasswordsFile: commonPasswords,
+	})
+	require.NoError(t, err)
+
+	require.Empty(t, p.Check("user1", "Str0ngPass"))
+	require.Equal(t, []string{RuleLoginLength}, rules(p.Check("u1", "Str0ngPass")))
+	require.Equal(t, []string{RuleLoginLength, RuleLoginCharset}, rules(p.Check("User-Name-1", "Str0ngPass")))
+	require.Equal(t, []string{RulePasswordLength, RulePasswordComplexity}, rules(p.Check("user1", "abc")))
+	require.Equal(t, []string{RulePasswordCommon}, rules(p.Check("user1", "PASSWORD1!")))
+}
@@ -0,0 +1,87 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleSupport Role = "support"
+	RoleAdmin   Role = "admin"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+func ParseRole(role string) (Role, error) {
+	switch r := Role(role); r {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return r, nil
+	default:
+		return "", fmt.Errorf("role=%s, err=%w", role, ErrUnknownRole)
+	}
+}
+
+type Permission string
+
+const (
+	// viewing any user's account: profile, balance, orders and withdrawals
+	PermissionUsersRead Permission = "users:read"
+	// blocking and unblocking accounts
+	PermissionUsersBlock Permission = "users:block"
+	// closing any user's sessions
+	PermissionSessionsManage Permission = "sessions:manage"
+	// changing user's role
+	PermissionRolesManage Permission = "roles:manage"
+	// manual crediting and debiting of user's balance
+	PermissionBalanceAdjust Permission = "balance:adjust"
+	// viewing the state of the service for monitoring
+	PermissionSystemRead Permission = "system:read"
+	// returning orders stuck in the accrual queue
+	PermissionOrdersManage Permission = "orders:manage"
+)
+
+var rolePermissions = map[Role]map[Permission]struct{}{
+	RoleUser: {},
+	RoleSupport: {
+		PermissionUsersRead:      {},
+		PermissionSessionsManage: {},
+	},
+	RoleAdmin: {
+		PermissionUsersRead:      {},
+		PermissionUsersBlock:     {},
+		PermissionSessionsManage: {},
+		PermissionRolesManage:    {},
+		PermissionBalanceAdjust:  {},
+		PermissionSystemRead:     {},
+		PermissionOrdersManage:   {},
+	},
+}
+
+func HasPermission(role Role, permission Permission) bool {
+	_, ok := rolePermissions[role][permission]
+
+	return ok
+}
+
+// Principal is the authenticated user of the request
+type Principal struct {
+	Login string
+	Role  Role
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns principal which was put by the access control middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+
+	return principal, ok
+}
@@ -0,0 +1,38 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasPermission(t *testing.T) {
+	require.False(t, HasPermission(RoleUser, PermissionUsersRead))
+	require.True(t, HasPermission(RoleSupport, PermissionUsersRead))
+	require.False(t, HasPermission(RoleSupport, PermissionRolesManage))
+	require.True(t, HasPermission(RoleAdmin, PermissionRolesManage))
+	require.False(t, HasPermission(RoleSupport, PermissionBalanceAdjust))
+	require.True(t, HasPermission(RoleAdmin, PermissionBalanceAdjust))
+	require.False(t, HasPermission(RoleSupport, PermissionSystemRead))
+	require.True(t, HasPermission(RoleAdmin, PermissionSystemRead))
+	require.False(t, HasPermission(RoleSupport, PermissionOrdersManage))
+	require.True(t, HasPermission(RoleAdmin, PermissionOrdersManage))
+	require.False(t, HasPermission(Role("root"), PermissionUsersRead))
+
+	_, err := ParseRole("root")
+	require.ErrorIs(t, err, ErrUnknownRole)
+}
+
+func TestScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"orders:write", "balance:read", "orders:write"})
+	require.NoError(t, err)
+	require.Equal(t, Scopes{ScopeOrdersWrite, ScopeBalanceRead}, scopes)
+	require.Equal(t, "orders:write balance:read", scopes.String())
+
+	restored := SplitScopes(scopes.String())
+	require.True(t, restored.Contains(ScopeOrdersWrite))
+	require.False(t, restored.Contains(ScopeBalanceWithdraw))
+
+	_, err = ParseScopes([]string{"orders:delete"})
+	require.ErrorIs(t, err, ErrUnknownScope)
+}
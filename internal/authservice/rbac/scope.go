@@ -0,0 +1,79 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Scope limits the endpoints which are available by an API key
+type Scope string
+
+const (
+	ScopeOrdersRead      Scope = "orders:read"
+	ScopeOrdersWrite     Scope = "orders:write"
+	ScopeBalanceRead     Scope = "balance:read"
+	ScopeBalanceWithdraw Scope = "balance:withdraw"
+)
+
+var ErrUnknownScope = errors.New("unknown scope")
+
+func ParseScope(scope string) (Scope, error) {
+	switch s := Scope(scope); s {
+	case ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeBalanceWithdraw:
+		return s, nil
+	default:
+		return "", fmt.Errorf("scope=%s, err=%w", scope, ErrUnknownScope)
+	}
+}
+
+// Scopes are stored as space separated list like in OAuth
+type Scopes []Scope
+
+func ParseScopes(scopes []string) (Scopes, error) {
+	result := make(Scopes, 0, len(scopes))
+
+	for _, scope := range scopes {
+		s, err := ParseScope(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if !result.Contains(s) {
+			result = append(result, s)
+		}
+	}
+
+	return result, nil
+}
+
+func (s Scopes) Contains(scope Scope) bool {
+	for _, v := range s {
+		if v == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Scopes) String() string {
+	values := make([]string, 0, len(s))
+	for _, v := range s {
+		values = append(values, string(v))
+	}
+
+	return strings.Join(values, " ")
+}
+
+// SplitScopes is the reverse of Scopes.String, unknown scopes are kept and never match
+func SplitScopes(value string) Scopes {
+	fields := strings.Fields(value)
+
+	result := make(Scopes, 0, len(fields))
+	for _, field := range fields {
+		result = append(result, Scope(field))
+	}
+
+	return result
+}
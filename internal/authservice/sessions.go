@@ -0,0 +1,258 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/authservice/jwt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"time"
+)
+
+var ErrRefreshIsNotSupported = errors.New("tokens refreshing is supported only in jwt auth mode")
+
+// SessionSettings limits session's lifetime: the session expires after IdleTimeout
+// without requests or after TTL since it was created, whatever comes first
+type SessionSettings struct {
+	IdleTimeout time.Duration
+	TTL         time.Duration
+}
+
+// AccessTokenSettings enables jwt auth mode. In this mode the session token becomes a refresh token
+// and user is authorized by the short-lived signed access token without the database lookup,
+// so access token of closed session or blocked user stays valid until it expires.
+type AccessTokenSettings struct {
+	Signer jwt.Signer
+	TTL    time.Duration
+}
+
+func (s *AuthService) Check(ctx context.Context, userKey string) (string, error) {
+	principal, err := s.Authenticate(ctx, userKey)
+	if err != nil {
+		return "", err
+	}
+
+	return principal.Login, nil
+}
+
+// Authenticate returns user of the key together with user's role
+func (s *AuthService) Authenticate(ctx context.Context, userKey string) (*rbac.Principal, error) {
+	if s.accessTokenSettings != nil {
+		claims, err := s.decodeAccessToken(userKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rbac.Principal{Login: claims.Subject, Role: rbac.Role(claims.Role)}, nil
+	}
+
+	session, err := s.checkSession(ctx, userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rbac.Principal{Login: session.Login, Role: rbac.Role(session.Role)}, nil
+}
+
+// Refresh closes the session of the refresh token and opens a new one with the same expiration time
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string, client *handler.ClientInfo) (*handler.Credentials, error) {
+	if s.accessTokenSettings == nil {
+		return nil, ErrRefreshIsNotSupported
+	}
+
+	session, err := s.checkSession(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// the refresh token is single-use, the concurrent refresh with the same token finds the session already deleted
+	session, err = s.store.TakeSession(ctx, session.Token)
+	if err != nil {
+		if errors.Is(err, sql.ErrSessionIsNotFound) {
+			return nil, fmt.Errorf("refresh token is already used, err=%w", handler.ErrIsNotAutorized)
+		}
+
+		return nil, fmt.Errorf("take refreshed session, err=%w", err)
+	}
+
+	return s.openSession(ctx, session.Login, rbac.Role(session.Role), client, session.ExpiresAt)
+}
+
+func (s *AuthService) Logout(ctx context.Context, userKey string) error {
+	session, err := s.currentSession(ctx, userKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrSessionIsNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	return s.store.DeleteSession(ctx, session.Token)
+}
+
+func (s *AuthService) GetSessions(ctx context.Context, login string, userKey string) ([]*sql.Session, error) {
+	current, err := s.currentSession(ctx, userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.store.GetUserSessions(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == current.ID
+	}
+
+	return sessions, nil
+}
+
+func (s *AuthService) DeleteSession(ctx context.Context, login string, id int64) error {
+	return s.store.DeleteUserSession(ctx, login, id)
+}
+
+func (s *AuthService) DeleteOtherSessions(ctx context.Context, login string, userKey string) error {
+	current, err := s.currentSession(ctx, userKey)
+	if err != nil {
+		return err
+	}
+
+	return s.store.DeleteOtherSessions(ctx, login, current.ID)
+}
+
+func (s *AuthService) newSession(
+	ctx context.Context,
+	login string,
+	role rbac.Role,
+	client *handler.ClientInfo,
+) (*handler.Credentials, error) {
+	return s.openSession(ctx, login, role, client, time.Now().Add(s.sessionSettings.TTL))
+}
+
+func (s *AuthService) openSession(
+	ctx context.Context,
+	login string,
+	role rbac.Role,
+	client *handler.ClientInfo,
+	expiresAt time.Time,
+) (*handler.Credentials, error) {
+	token, err := cryptographer.GenerateToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate session token, err=%w", err)
+	}
+
+	now := time.Now()
+	session := &sql.Session{
+		Token:     cryptographer.HashToken(token),
+		Login:     login,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		Role:      string(role),
+	}
+
+	if err := s.store.CreateSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("create session for user=%s, err=%w", login, err)
+	}
+
+	if s.accessTokenSettings == nil {
+		return &handler.Credentials{Token: token, ExpiresAt: session.ExpiresAt}, nil
+	}
+
+	accessToken, accessExpiresAt, err := s.issueAccessToken(session, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler.Credentials{
+		Token:            accessToken,
+		ExpiresAt:        accessExpiresAt,
+		RefreshToken:     token,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// checkSession finds session by the token and checks its expiration
+func (s *AuthService) checkSession(ctx context.Context, userKey string) (*sql.Session, error) {
+	token := cryptographer.HashToken(userKey)
+
+	session, err := s.store.FindSession(ctx, token)
+	if err != nil {
+		if errors.Is(err, sql.ErrSessionIsNotFound) {
+			return nil, fmt.Errorf("session isn't found, err=%w", handler.ErrIsNotAutorized)
+		}
+
+		return nil, err
+	}
+
+	if session.Blocked {
+		return nil, fmt.Errorf("user=%s of session=%d, err=%w", session.Login, session.ID, handler.ErrIsNotAutorized)
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) || now.Sub(session.LastSeen) > s.sessionSettings.IdleTimeout {
+		if err := s.store.DeleteSession(ctx, token); err != nil {
+			zlog.Logger.Errorf("delete expired session=%d of user=%s, err=%s", session.ID, session.Login, err)
+		}
+
+		return nil, fmt.Errorf("session=%d is expired, err=%w", session.ID, handler.ErrIsNotAutorized)
+	}
+
+	if err := s.store.TouchSession(ctx, token, now); err != nil {
+		return nil, fmt.Errorf("touch session=%d, err=%w", session.ID, err)
+	}
+
+	return session, nil
+}
+
+// currentSession returns session of the already checked user key
+func (s *AuthService) currentSession(ctx context.Context, userKey string) (*sql.Session, error) {
+	if s.accessTokenSettings == nil {
+		return s.store.FindSession(ctx, cryptographer.HashToken(userKey))
+	}
+
+	claims, err := s.decodeAccessToken(userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.store.FindSessionByID(ctx, claims.SessionID)
+}
+
+func (s *AuthService) issueAccessToken(session *sql.Session, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(s.accessTokenSettings.TTL)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	claims := &jwt.Claims{
+		Subject:   session.Login,
+		Role:      session.Role,
+		SessionID: session.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+
+	token, err := jwt.Encode(s.accessTokenSettings.Signer, claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("encode access token of user=%s, err=%w", session.Login, err)
+	}
+
+	return token, expiresAt, nil
+}
+
+func (s *AuthService) decodeAccessToken(userKey string) (*jwt.Claims, error) {
+	claims, err := jwt.Decode(s.accessTokenSettings.Signer, userKey, time.Now())
+	if err != nil {
+		return nil, errors.Join(handler.ErrIsNotAutorized, err)
+	}
+
+	return claims, nil
+}
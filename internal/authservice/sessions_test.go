@@ -0,0 +1,103 @@
+package authservice
+
+import (
+	"context"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/authservice/jwt"
+	"gophermart/internal/notifier"
+	"gophermart/internal/storage/memory"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, accessToken *AccessTokenSettings) *AuthService {
+	legacyCryptographer, err := cryptographer.NewAesCryptographer()
+	require.NoError(t, err)
+
+	return NewAuthService(memory.New(), cryptographer.NewArgon2Hasher(), legacyCryptographer, notifier.NewLogNotifier(), Settings{
+		Session:     SessionSettings{IdleTimeout: time.Hour, TTL: time.Hour * 24},
+		AccessToken: accessToken,
+		Throttle: ThrottleSettings{
+			MaxFailuresPerLogin: 4,
+			MaxFailuresPerIP:    100,
+			BaseDelay:           time.Minute,
+			Lockout:             time.Hour,
+		},
+	})
+}
+
+func TestSessionIsCheckedUntilLogout(t *testing.T) {
+	ctx := context.Background()
+	client := &handler.ClientInfo{UserAgent: "test", IP: "127.0.0.1"}
+	s := newTestService(t, nil)
+
+	credentials, err := s.Register(ctx, "user", "password", client)
+	require.NoError(t, err)
+
+	login, err := s.Check(ctx, credentials.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "user", login)
+
+	sessions, err := s.GetSessions(ctx, "user", credentials.Token)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.True(t, sessions[0].Current)
+
+	require.NoError(t, s.Logout(ctx, credentials.Token))
+
+	_, err = s.Check(ctx, credentials.Token)
+	assert.ErrorIs(t, err, handler.ErrIsNotAutorized)
+}
+
+func TestRefreshTokenIsUsedOnce(t *testing.T) {
+	ctx := context.Background()
+	client := &handler.ClientInfo{UserAgent: "test", IP: "127.0.0.1"}
+
+	signer, err := jwt.NewHS256Signer([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	s := newTestService(t, &AccessTokenSettings{Signer: signer, TTL: time.Minute})
+
+	credentials, err := s.Register(ctx, "user", "password", client)
+	require.NoError(t, err)
+
+	const refreshes = 5
+
+	results := make(chan error, refreshes)
+	refreshed := make(chan *handler.Credentials, refreshes)
+	for i := 0; i < refreshes; i++ {
+		go func() {
+			newCredentials, err := s.Refresh(ctx, credentials.RefreshToken, client)
+			if err == nil {
+				refreshed <- newCredentials
+			}
+
+			results <- err
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < refreshes; i++ {
+		if err := <-results; err != nil {
+			assert.ErrorIs(t, err, handler.ErrIsNotAutorized)
+			continue
+		}
+
+		succeeded++
+	}
+	require.Equal(t, 1, succeeded)
+
+	newCredentials := <-refreshed
+	// the refreshed session keeps the expiration time, the storage keeps it in microseconds
+	assert.WithinDuration(t, credentials.RefreshExpiresAt, newCredentials.RefreshExpiresAt, time.Microsecond)
+
+	_, err = s.Refresh(ctx, credentials.RefreshToken, client)
+	assert.ErrorIs(t, err, handler.ErrIsNotAutorized)
+
+	_, err = s.Refresh(ctx, newCredentials.RefreshToken, client)
+	assert.NoError(t, err)
+}
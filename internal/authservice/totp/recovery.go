@@ -0,0 +1,50 @@
+package totp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+const (
+	RecoveryCodesCount = 10
+	recoveryCodeSize   = 10
+
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// GenerateRecoveryCodes returns one-time codes which replace the totp code when the device is lost,
+// codes look like "xxxxx-xxxxx" and are compared ignoring case and dashes
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, RecoveryCodesCount)
+
+	buf := make([]byte, recoveryCodeSize)
+	for i := 0; i < RecoveryCodesCount; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("read random bytes, err=%w", err)
+		}
+
+		var code strings.Builder
+		for j, b := range buf {
+			if j == recoveryCodeSize/2 {
+				code.WriteByte('-')
+			}
+
+			code.WriteByte(recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+
+		codes = append(codes, code.String())
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode brings user's input to the form in which codes are generated
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != recoveryCodeSize {
+		return code
+	}
+
+	return code[:recoveryCodeSize/2] + "-" + code[recoveryCodeSize/2:]
+}
@@ -0,0 +1,117 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	secretSize = 20
+	digits     = 6
+	period     = 30
+
+	// codes of the previous and the next periods are accepted because of clock drift
+	allowedSkew = 1
+)
+
+var (
+	ErrBadSecret = errors.New("bad totp secret")
+	ErrBadCode   = errors.New("bad totp code")
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns random base32 secret which is shared with the authenticator app
+func GenerateSecret() (string, error) {
+	buf := make([]byte, secretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("read random bytes, err=%w", err)
+	}
+
+	return secretEncoding.EncodeToString(buf), nil
+}
+
+// URI returns otpauth uri which is usually shown to user as QR code
+func URI(issuer string, account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(digits))
+	params.Set("period", fmt.Sprint(period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns number of the period which contains the moment
+func Step(t time.Time) int64 {
+	return t.Unix() / period
+}
+
+// Code returns the code of the period which contains the moment
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return code(key, Step(t)), nil
+}
+
+// Validate checks the code against the periods around the moment and returns step of the matched period,
+// the caller should reject steps which were already used
+func Validate(secret string, value string, t time.Time) (int64, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+
+	value = strings.TrimSpace(value)
+	if len(value) != digits {
+		return 0, ErrBadCode
+	}
+
+	current := Step(t)
+	for step := current - allowedSkew; step <= current+allowedSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, step)), []byte(value)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, ErrBadCode
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, errors.Join(ErrBadSecret, err)
+	}
+
+	return key, nil
+}
+
+// code is HOTP value of RFC 4226 for the counter
+func code(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
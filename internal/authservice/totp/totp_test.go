@@ -0,0 +1,67 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	// test vectors of RFC 6238 for SHA1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	for unix, expected := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	} {
+		code, err := Code(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, expected, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	code, err := Code(secret, now.Add(-period*time.Second))
+	require.NoError(t, err)
+
+	step, err := Validate(secret, code, now)
+	require.NoError(t, err)
+	require.Equal(t, Step(now)-1, step)
+
+	code, err = Code(secret, now.Add(-3*period*time.Second))
+	require.NoError(t, err)
+
+	_, err = Validate(secret, code, now)
+	require.ErrorIs(t, err, ErrBadCode)
+
+	_, err = Validate("not base32!", "123456", now)
+	require.ErrorIs(t, err, ErrBadSecret)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Gophermart", "user 1", "JBSWY3DPEHPK3PXP")
+
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/Gophermart:user%201?"))
+	require.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	require.Contains(t, uri, "issuer=Gophermart")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodesCount)
+
+	for _, code := range codes {
+		require.Equal(t, code, NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	}
+}
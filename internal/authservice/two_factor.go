@@ -0,0 +1,245 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/cryptographer"
+	"gophermart/internal/authservice/totp"
+	"gophermart/internal/sql"
+	"gophermart/internal/zlog"
+	"time"
+)
+
+const (
+	loginChallengeTTL = time.Minute * 5
+
+	// challenge is dropped after this number of checks, the password has to be entered again
+	maxSecondFactorAttempts = 5
+)
+
+// EnrollTOTP generates new secret for the authenticator app, the second factor is enabled only after confirmation
+func (s *AuthService) EnrollTOTP(ctx context.Context, login string) (*handler.TOTPEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("generate totp secret of user=%s, err=%w", login, err)
+	}
+
+	if err := s.store.SaveUserTOTP(ctx, login, secret, time.Now()); err != nil {
+		if errors.Is(err, sql.ErrTOTPIsAlreadyConfirmed) {
+			return nil, fmt.Errorf("user=%s, err=%w", login, handler.ErrSecondFactorIsAlreadyEnabled)
+		}
+
+		return nil, fmt.Errorf("save totp secret of user=%s, err=%w", login, err)
+	}
+
+	return &handler.TOTPEnrollment{Secret: secret, URI: totp.URI(s.totpIssuer, login, secret)}, nil
+}
+
+// ConfirmTOTP enables the second factor by the first code from the app and returns new recovery codes
+func (s *AuthService) ConfirmTOTP(ctx context.Context, login string, code string, client *handler.ClientInfo) ([]string, error) {
+	userTOTP, err := s.store.FindUserTOTP(ctx, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrTOTPIsNotFound) {
+			return nil, fmt.Errorf("user=%s, err=%w", login, handler.ErrSecondFactorIsNotEnrolled)
+		}
+
+		return nil, err
+	}
+
+	if userTOTP.Confirmed {
+		return nil, fmt.Errorf("user=%s, err=%w", login, handler.ErrSecondFactorIsAlreadyEnabled)
+	}
+
+	step, err := totp.Validate(userTOTP.Secret, code, time.Now())
+	if err != nil {
+		return nil, errors.Join(handler.ErrBadSecondFactorCode, err)
+	}
+
+	recoveryCodes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("generate recovery codes of user=%s, err=%w", login, err)
+	}
+
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, recoveryCode := range recoveryCodes {
+		hashes = append(hashes, cryptographer.HashToken(recoveryCode))
+	}
+
+	if err := s.store.ConfirmUserTOTP(ctx, login, step, hashes); err != nil {
+		if errors.Is(err, sql.ErrTOTPIsAlreadyConfirmed) {
+			return nil, fmt.Errorf("user=%s, err=%w", login, handler.ErrSecondFactorIsAlreadyEnabled)
+		}
+
+		return nil, fmt.Errorf("confirm totp of user=%s, err=%w", login, err)
+	}
+
+	s.audit(ctx, login, sql.AuthEventSecondFactorEnabled, "", client)
+
+	return recoveryCodes, nil
+}
+
+// DisableTOTP turns off the second factor, the current code or a recovery code is required,
+// wrong codes are counted as failed logins, so the session alone doesn't allow to guess them
+func (s *AuthService) DisableTOTP(ctx context.Context, login string, code string, client *handler.ClientInfo) error {
+	if err := s.checkLoginThrottle(ctx, login, client); err != nil {
+		s.audit(ctx, login, sql.AuthEventLoginThrottled, err.Error(), client)
+		return err
+	}
+
+	if err := s.checkSecondFactor(ctx, login, code, client); err != nil {
+		if errors.Is(err, handler.ErrBadSecondFactorCode) {
+			s.registerLoginFailure(ctx, login, client)
+			s.audit(ctx, login, sql.AuthEventSecondFactorFailed, err.Error(), client)
+		}
+
+		return err
+	}
+
+	if err := s.store.DeleteUserTOTP(ctx, login); err != nil {
+		return fmt.Errorf("delete totp of user=%s, err=%w", login, err)
+	}
+
+	s.audit(ctx, login, sql.AuthEventSecondFactorDisabled, "", client)
+
+	return nil
+}
+
+// AuthorizeSecondFactor completes the login which was started with the right password
+func (s *AuthService) AuthorizeSecondFactor(
+	ctx context.Context,
+	challengeToken string,
+	code string,
+	client *handler.ClientInfo,
+) (*handler.Credentials, error) {
+	token := cryptographer.HashToken(challengeToken)
+
+	challenge, err := s.store.UseLoginChallenge(ctx, token, time.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrLoginChallengeIsNotFound) {
+			return nil, errors.Join(handler.ErrIsNotAutorized, err)
+		}
+
+		return nil, err
+	}
+
+	if err := s.checkLoginThrottle(ctx, challenge.Login, client); err != nil {
+		s.audit(ctx, challenge.Login, sql.AuthEventLoginThrottled, err.Error(), client)
+		return nil, err
+	}
+
+	if challenge.Attempts > maxSecondFactorAttempts {
+		s.deleteLoginChallenge(ctx, token)
+		return nil, fmt.Errorf("too many second factor attempts, err=%w", handler.ErrIsNotAutorized)
+	}
+
+	if err := s.checkSecondFactor(ctx, challenge.Login, code, client); err != nil {
+		if errors.Is(err, handler.ErrBadSecondFactorCode) {
+			s.registerLoginFailure(ctx, challenge.Login, client)
+			s.audit(ctx, challenge.Login, sql.AuthEventSecondFactorFailed, err.Error(), client)
+
+			return nil, errors.Join(handler.ErrIsNotAutorized, err)
+		}
+
+		return nil, err
+	}
+
+	s.deleteLoginChallenge(ctx, token)
+
+	user, err := s.store.FindUser(ctx, challenge.Login)
+	if err != nil {
+		return nil, fmt.Errorf("get user info login=%s, err=%w", challenge.Login, err)
+	}
+
+	if user.Blocked {
+		return nil, fmt.Errorf("login=%s, err=%w", user.Login, handler.ErrUserIsBlocked)
+	}
+
+	return s.completeLogin(ctx, user, client)
+}
+
+// hasSecondFactor reports whether the user has confirmed totp
+func (s *AuthService) hasSecondFactor(ctx context.Context, login string) (bool, error) {
+	userTOTP, err := s.store.FindUserTOTP(ctx, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrTOTPIsNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("find totp of user=%s, err=%w", login, err)
+	}
+
+	return userTOTP.Confirmed, nil
+}
+
+// newLoginChallenge returns the state of login which waits for the second factor
+func (s *AuthService) newLoginChallenge(ctx context.Context, login string) error {
+	token, err := cryptographer.GenerateToken()
+	if err != nil {
+		return fmt.Errorf("generate login challenge, err=%w", err)
+	}
+
+	challenge := &sql.LoginChallenge{
+		Token:     cryptographer.HashToken(token),
+		Login:     login,
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	}
+
+	if err := s.store.CreateLoginChallenge(ctx, challenge); err != nil {
+		return fmt.Errorf("create login challenge for user=%s, err=%w", login, err)
+	}
+
+	return &handler.SecondFactorRequiredError{Challenge: token, ExpiresAt: challenge.ExpiresAt}
+}
+
+func (s *AuthService) deleteLoginChallenge(ctx context.Context, token string) {
+	if err := s.store.DeleteLoginChallenge(ctx, token); err != nil {
+		zlog.Logger.Errorf("delete login challenge, err=%s", err)
+	}
+}
+
+// checkSecondFactor accepts the current totp code or an unused recovery code
+func (s *AuthService) checkSecondFactor(ctx context.Context, login string, code string, client *handler.ClientInfo) error {
+	userTOTP, err := s.store.FindUserTOTP(ctx, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrTOTPIsNotFound) {
+			return fmt.Errorf("user=%s, err=%w", login, handler.ErrSecondFactorIsNotEnrolled)
+		}
+
+		return err
+	}
+
+	if !userTOTP.Confirmed {
+		return fmt.Errorf("user=%s, err=%w", login, handler.ErrSecondFactorIsNotEnrolled)
+	}
+
+	now := time.Now()
+
+	step, err := totp.Validate(userTOTP.Secret, code, now)
+	if err == nil {
+		if err := s.store.UseTOTPStep(ctx, login, step); err != nil {
+			if errors.Is(err, sql.ErrTOTPCodeIsAlreadyUsed) {
+				return errors.Join(handler.ErrBadSecondFactorCode, err)
+			}
+
+			return fmt.Errorf("use totp step of user=%s, err=%w", login, err)
+		}
+
+		return nil
+	}
+
+	codeHash := cryptographer.HashToken(totp.NormalizeRecoveryCode(code))
+
+	if err := s.store.UseRecoveryCode(ctx, login, codeHash, now); err != nil {
+		if errors.Is(err, sql.ErrRecoveryCodeIsNotFound) {
+			return errors.Join(handler.ErrBadSecondFactorCode, err)
+		}
+
+		return fmt.Errorf("use recovery code of user=%s, err=%w", login, err)
+	}
+
+	s.audit(ctx, login, sql.AuthEventRecoveryCodeUsed, "", client)
+
+	return nil
+}
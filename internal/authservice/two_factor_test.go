@@ -0,0 +1,50 @@
+package authservice
+
+import (
+	"context"
+	"errors"
+	"gophermart/internal/apiserver/handler"
+	"gophermart/internal/authservice/totp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisableTOTPCodeIsThrottled(t *testing.T) {
+	ctx := context.Background()
+	client := &handler.ClientInfo{UserAgent: "test", IP: "127.0.0.1"}
+	s := newTestService(t, nil)
+
+	_, err := s.Register(ctx, "user", "password", client)
+	require.NoError(t, err)
+
+	enrollment, err := s.EnrollTOTP(ctx, "user")
+	require.NoError(t, err)
+
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+
+	recoveryCodes, err := s.ConfirmTOTP(ctx, "user", code, client)
+	require.NoError(t, err)
+	require.NotEmpty(t, recoveryCodes)
+
+	// after half of the max failures every next attempt is delayed
+	for i := 0; i < s.throttleSettings.MaxFailuresPerLogin/2; i++ {
+		require.ErrorIs(t, s.DisableTOTP(ctx, "user", "wrong", client), handler.ErrBadSecondFactorCode)
+	}
+
+	var tooManyAttemptsErr *handler.TooManyAttemptsError
+
+	// the right code isn't checked while the login is locked, neither by disabling nor by login
+	err = s.DisableTOTP(ctx, "user", recoveryCodes[0], client)
+	require.True(t, errors.As(err, &tooManyAttemptsErr))
+
+	_, err = s.Authorize(ctx, "user", "password", client)
+	require.True(t, errors.As(err, &tooManyAttemptsErr))
+
+	enabled, err := s.hasSecondFactor(ctx, "user")
+	require.NoError(t, err)
+	assert.True(t, enabled)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -12,12 +13,107 @@ var (
 	ErrRunAddressIsNotSet           = errors.New("servers's addres is not set")
 	ErrDatabaseURIIsNotSet          = errors.New("servers's database's URI is not set")
 	ErrAccrualSystemAddressIsNotSet = errors.New("accrual system's address is not set")
+	ErrBadSessionTimeouts           = errors.New("session's and token's timeouts must be positive")
+	ErrUnknownAuthMode              = errors.New("unknown auth mode")
+	ErrBadLoginThrottleSettings     = errors.New("login throttle settings must be positive")
+	ErrBadLoginLengthLimits         = errors.New("login length limits must be positive and min must not exceed max")
+	ErrJWTKeyIsNotSet               = errors.New("jwt signing key is not set")
+	ErrBadDBPoolSettings            = errors.New("database pool settings must not be negative and min conns must not exceed max conns")
+	ErrBadAccrualSettings           = errors.New("accrual workers, batch size, polling interval, backoff and lease ttl must be positive")
+)
+
+const (
+	// opaque session token is checked in the database on every request
+	AuthModeSession = "session"
+	// signed access token is checked without the database, session is used as refresh token
+	AuthModeJWT = "jwt"
+)
+
+const (
+	defaultSessionIdleTimeout = time.Minute * 30
+	defaultSessionTTL         = time.Hour * 24
+	defaultAccessTokenTTL     = time.Minute * 15
+	defaultPasswordResetTTL   = time.Minute * 30
+	defaultAPIKeyMaxTTL       = time.Hour * 24 * 365
+
+	defaultLoginMaxFailures      = 10
+	defaultLoginMaxFailuresPerIP = 100
+	defaultLoginBaseDelay        = time.Second
+	defaultLoginLockout          = time.Minute * 15
+
+	defaultLoginMinLength    = 1
+	defaultLoginMaxLength    = 128
+	defaultPasswordMinLength = 1
+
+	defaultDBMaxConns          = 10
+	defaultDBMinConns          = 0
+	defaultDBMaxConnIdleTime   = time.Minute * 30
+	defaultDBHealthCheckPeriod = time.Minute
+	defaultDBStatementTimeout  = time.Second * 30
+
+	defaultAccrualWorkers         = 4
+	defaultAccrualBatchSize       = 100
+	defaultAccrualPollingInterval = time.Second
+	defaultAccrualMinBackoff      = time.Second
+	defaultAccrualMaxBackoff      = time.Minute * 10
+	defaultAccrualMaxAttempts     = 50
+	defaultAccrualMaxOrderAge     = time.Hour * 24 * 7
+	defaultAccrualLeaseTTL        = time.Minute * 5
 )
 
 type Config struct {
 	RunAddress     string `env:"RUN_ADDRESS"`
 	DatabaseURI    string `env:"DATABASE_URI"`
 	AccrualAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+
+	AccrualWorkers         int           `env:"ACCRUAL_WORKERS"`
+	AccrualBatchSize       int           `env:"ACCRUAL_BATCH_SIZE"`
+	AccrualPollingInterval time.Duration `env:"ACCRUAL_POLLING_INTERVAL"`
+	AccrualMinBackoff      time.Duration `env:"ACCRUAL_MIN_BACKOFF"`
+	AccrualMaxBackoff      time.Duration `env:"ACCRUAL_MAX_BACKOFF"`
+	// zero doesn't limit attempts or age of orders
+	AccrualMaxAttempts int           `env:"ACCRUAL_MAX_ATTEMPTS"`
+	AccrualMaxOrderAge time.Duration `env:"ACCRUAL_MAX_ORDER_AGE"`
+	AccrualLeaseTTL    time.Duration `env:"ACCRUAL_LEASE_TTL"`
+	// it's generated on every start if empty
+	AccrualInstanceID string `env:"ACCRUAL_INSTANCE_ID"`
+
+	DBMaxConns          int           `env:"DB_MAX_CONNS"`
+	DBMinConns          int           `env:"DB_MIN_CONNS"`
+	DBMaxConnIdleTime   time.Duration `env:"DB_MAX_CONN_IDLE_TIME"`
+	DBHealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD"`
+	// zero doesn't limit statements
+	DBStatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT"`
+
+	SessionIdleTimeout time.Duration `env:"SESSION_IDLE_TIMEOUT"`
+	SessionTTL         time.Duration `env:"SESSION_TTL"`
+	SecureCookie       bool          `env:"SECURE_COOKIE"`
+
+	AuthMode       string        `env:"AUTH_MODE"`
+	JWTAlgorithm   string        `env:"JWT_ALGORITHM"`
+	JWTKey         string        `env:"JWT_KEY"`
+	AccessTokenTTL time.Duration `env:"ACCESS_TOKEN_TTL"`
+
+	PasswordResetTTL  time.Duration `env:"PASSWORD_RESET_TTL"`
+	NotificationsFile string        `env:"NOTIFICATIONS_FILE"`
+
+	APIKeyMaxTTL time.Duration `env:"API_KEY_MAX_TTL"`
+	TOTPIssuer   string        `env:"TOTP_ISSUER"`
+
+	LoginMaxFailures      int           `env:"LOGIN_MAX_FAILURES"`
+	LoginMaxFailuresPerIP int           `env:"LOGIN_MAX_FAILURES_PER_IP"`
+	LoginBaseDelay        time.Duration `env:"LOGIN_BASE_DELAY"`
+	LoginLockout          time.Duration `env:"LOGIN_LOCKOUT"`
+
+	LoginMinLength      int    `env:"LOGIN_MIN_LENGTH"`
+	LoginMaxLength      int    `env:"LOGIN_MAX_LENGTH"`
+	LoginPattern        string `env:"LOGIN_PATTERN"`
+	PasswordMinLength   int    `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMinClasses  int    `env:"PASSWORD_MIN_CLASSES"`
+	CommonPasswordsFile string `env:"COMMON_PASSWORDS_FILE"`
+
+	// comma separated logins which are granted admin role on start
+	Admins string `env:"ADMINS"`
 }
 
 func Make() (*Config, error) {
@@ -26,6 +122,42 @@ func Make() (*Config, error) {
 	flag.StringVar(&config.RunAddress, "a", "", "Server's host:port")
 	flag.StringVar(&config.DatabaseURI, "d", "", "Database uri")
 	flag.StringVar(&config.AccrualAddress, "r", "", "Accrual system's address")
+	flag.IntVar(&config.AccrualWorkers, "accrual-workers", defaultAccrualWorkers, "Number of concurrent requests to the accrual system")
+	flag.IntVar(&config.AccrualBatchSize, "accrual-batch-size", defaultAccrualBatchSize, "Max number of orders taken for the accrual check at once")
+	flag.DurationVar(&config.AccrualPollingInterval, "accrual-polling-interval", defaultAccrualPollingInterval, "Period of taking orders for the accrual check")
+	flag.DurationVar(&config.AccrualMinBackoff, "accrual-min-backoff", defaultAccrualMinBackoff, "Wait before the next check of the unfinished order")
+	flag.DurationVar(&config.AccrualMaxBackoff, "accrual-max-backoff", defaultAccrualMaxBackoff, "Max wait between checks of the unfinished order")
+	flag.IntVar(&config.AccrualMaxAttempts, "accrual-max-attempts", defaultAccrualMaxAttempts, "Checks before the order is marked stuck, 0 doesn't limit them")
+	flag.DurationVar(&config.AccrualMaxOrderAge, "accrual-max-order-age", defaultAccrualMaxOrderAge, "Age of the unfinished order when it's marked stuck, 0 doesn't limit it")
+	flag.DurationVar(&config.AccrualLeaseTTL, "accrual-lease-ttl", defaultAccrualLeaseTTL, "Time the order is leased to this instance for the accrual check")
+	flag.StringVar(&config.AccrualInstanceID, "accrual-instance-id", "", "Name of this instance in order leases, it's generated if empty")
+	flag.IntVar(&config.DBMaxConns, "db-max-conns", defaultDBMaxConns, "Max number of database connections")
+	flag.IntVar(&config.DBMinConns, "db-min-conns", defaultDBMinConns, "Number of database connections which are kept open when idle")
+	flag.DurationVar(&config.DBMaxConnIdleTime, "db-max-conn-idle-time", defaultDBMaxConnIdleTime, "Idle database connection is closed after this period")
+	flag.DurationVar(&config.DBHealthCheckPeriod, "db-health-check-period", defaultDBHealthCheckPeriod, "Period of checking idle database connections")
+	flag.DurationVar(&config.DBStatementTimeout, "db-statement-timeout", defaultDBStatementTimeout, "Database cancels statements running longer, 0 doesn't limit them")
+	flag.DurationVar(&config.SessionIdleTimeout, "session-idle-timeout", defaultSessionIdleTimeout, "Session is expired after this period of inactivity")
+	flag.DurationVar(&config.SessionTTL, "session-ttl", defaultSessionTTL, "Session is expired after this period since login")
+	flag.BoolVar(&config.SecureCookie, "secure-cookie", false, "Send Authorization cookie only over https")
+	flag.StringVar(&config.AuthMode, "auth-mode", AuthModeSession, "Authorization mode: session or jwt")
+	flag.StringVar(&config.JWTAlgorithm, "jwt-algorithm", "HS256", "JWT signing algorithm: HS256 or EdDSA")
+	flag.StringVar(&config.JWTKey, "jwt-key", "", "JWT signing key: secret for HS256 or base64 ed25519 seed for EdDSA")
+	flag.DurationVar(&config.AccessTokenTTL, "access-token-ttl", defaultAccessTokenTTL, "Lifetime of JWT access token")
+	flag.DurationVar(&config.PasswordResetTTL, "password-reset-ttl", defaultPasswordResetTTL, "Lifetime of password reset token")
+	flag.DurationVar(&config.APIKeyMaxTTL, "api-key-max-ttl", defaultAPIKeyMaxTTL, "Max lifetime of user's API key")
+	flag.StringVar(&config.TOTPIssuer, "totp-issuer", "Gophermart", "Issuer name which is shown in authenticator apps")
+	flag.IntVar(&config.LoginMaxFailures, "login-max-failures", defaultLoginMaxFailures, "Failed login attempts before the login lockout")
+	flag.IntVar(&config.LoginMaxFailuresPerIP, "login-max-failures-per-ip", defaultLoginMaxFailuresPerIP, "Failed login attempts before the client address lockout")
+	flag.DurationVar(&config.LoginBaseDelay, "login-base-delay", defaultLoginBaseDelay, "First delay between failed login attempts")
+	flag.DurationVar(&config.LoginLockout, "login-lockout", defaultLoginLockout, "Lockout duration after max failed login attempts")
+	flag.IntVar(&config.LoginMinLength, "login-min-length", defaultLoginMinLength, "Min length of login")
+	flag.IntVar(&config.LoginMaxLength, "login-max-length", defaultLoginMaxLength, "Max length of login")
+	flag.StringVar(&config.LoginPattern, "login-pattern", "", "Regular expression which login must match")
+	flag.IntVar(&config.PasswordMinLength, "password-min-length", defaultPasswordMinLength, "Min length of password")
+	flag.IntVar(&config.PasswordMinClasses, "password-min-classes", 0, "Min number of character classes in password")
+	flag.StringVar(&config.CommonPasswordsFile, "common-passwords-file", "", "File with denied common passwords, one per line")
+	flag.StringVar(&config.Admins, "admins", "", "Comma separated logins which are granted admin role on start")
+	flag.StringVar(&config.NotificationsFile, "notifications-file", "", "File for users notifications, notifications are logged if it isn't set")
 	flag.Parse()
 
 	if err := env.Parse(&config); err != nil {
@@ -46,9 +178,58 @@ func Make() (*Config, error) {
 		err = errors.Join(err, ErrAccrualSystemAddressIsNotSet)
 	}
 
+	if config.AccrualWorkers <= 0 || config.AccrualBatchSize <= 0 || config.AccrualPollingInterval <= 0 ||
+		config.AccrualMinBackoff <= 0 || config.AccrualMaxBackoff < config.AccrualMinBackoff ||
+		config.AccrualMaxAttempts < 0 || config.AccrualMaxOrderAge < 0 || config.AccrualLeaseTTL <= 0 {
+		err = errors.Join(err, ErrBadAccrualSettings)
+	}
+
+	if config.DBMaxConns <= 0 || config.DBMinConns < 0 || config.DBMinConns > config.DBMaxConns ||
+		config.DBMaxConnIdleTime < 0 || config.DBHealthCheckPeriod < 0 || config.DBStatementTimeout < 0 {
+		err = errors.Join(err, ErrBadDBPoolSettings)
+	}
+
+	if config.SessionIdleTimeout <= 0 || config.SessionTTL <= 0 || config.PasswordResetTTL <= 0 || config.APIKeyMaxTTL <= 0 {
+		err = errors.Join(err, ErrBadSessionTimeouts)
+	}
+
+	if config.LoginMaxFailures <= 0 || config.LoginMaxFailuresPerIP <= 0 || config.LoginBaseDelay <= 0 || config.LoginLockout <= 0 {
+		err = errors.Join(err, ErrBadLoginThrottleSettings)
+	}
+
+	if config.LoginMinLength < 1 || config.LoginMaxLength < config.LoginMinLength {
+		err = errors.Join(err, ErrBadLoginLengthLimits)
+	}
+
+	switch config.AuthMode {
+	case AuthModeSession:
+	case AuthModeJWT:
+		if config.JWTKey == "" {
+			err = errors.Join(err, ErrJWTKeyIsNotSet)
+		}
+
+		if config.AccessTokenTTL <= 0 {
+			err = errors.Join(err, ErrBadSessionTimeouts)
+		}
+	default:
+		err = errors.Join(err, fmt.Errorf("mode=%s, err=%w", config.AuthMode, ErrUnknownAuthMode))
+	}
+
 	if err != nil {
 		return nil, fmt.Errorf("bad config, err=%w", err)
 	}
 
 	return &config, nil
 }
+
+// String hides the jwt signing key from logs
+func (c *Config) String() string {
+	type plainConfig Config
+
+	masked := plainConfig(*c)
+	if masked.JWTKey != "" {
+		masked.JWTKey = "***"
+	}
+
+	return fmt.Sprintf("%+v", masked)
+}
@@ -0,0 +1,186 @@
+// Package money keeps amounts of points as fixed-point numbers with two fractional digits.
+//
+// Rounding rules:
+//   - amounts coming from the users must have at most two fractional digits, more precise ones are rejected;
+//   - amounts coming from the accrual system are rounded to the hundredth half away from zero,
+//     the same way round(numeric, 2) of postgres rounds the legacy double precision columns in the migration;
+//   - the arithmetic on amounts is exact, nothing is rounded after parsing.
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of minor units in one point
+const Scale = 100
+
+const fractionDigits = 2
+
+var (
+	ErrBadAmount       = errors.New("bad amount")
+	ErrTooPreciseValue = errors.New("amount has more than two fractional digits")
+	ErrOutOfRange      = errors.New("amount is out of range")
+)
+
+// Amount is the number of hundredths of a point
+type Amount int64
+
+// New makes the amount from whole points and hundredths, e.g. New(10, 5) is 10.05
+func New(points int64, hundredths int64) Amount {
+	return Amount(points*Scale + hundredths)
+}
+
+// Parse reads the exact decimal value, values with more than two fractional digits are rejected
+func Parse(s string) (Amount, error) {
+	r, err := parseRat(s)
+	if err != nil {
+		return 0, err
+	}
+
+	r.Mul(r, big.NewRat(Scale, 1))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("value=%s err=%w", s, ErrTooPreciseValue)
+	}
+
+	return fromInt(r.Num(), s)
+}
+
+// Round reads the decimal value rounding it to the hundredth half away from zero
+func Round(s string) (Amount, error) {
+	r, err := parseRat(s)
+	if err != nil {
+		return 0, err
+	}
+
+	r.Mul(r, big.NewRat(Scale, 1))
+
+	// |num| / den rounded half up, the sign is restored afterwards
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+
+	if r.Sign() < 0 {
+		quo.Neg(quo)
+	}
+
+	return fromInt(quo, s)
+}
+
+func parseRat(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrBadAmount
+	}
+
+	// big.Rat also accepts fractions like 1/3 which aren't decimals
+	if strings.ContainsRune(s, '/') {
+		return nil, fmt.Errorf("value=%s err=%w", s, ErrBadAmount)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("value=%s err=%w", s, ErrBadAmount)
+	}
+
+	return r, nil
+}
+
+func fromInt(i *big.Int, s string) (Amount, error) {
+	if !i.IsInt64() {
+		return 0, fmt.Errorf("value=%s err=%w", s, ErrOutOfRange)
+	}
+
+	return Amount(i.Int64()), nil
+}
+
+// String formats the amount with exactly two fractional digits, e.g. 10.50
+func (a Amount) String() string {
+	sign := ""
+	abs := uint64(a)
+	if a < 0 {
+		sign = "-"
+		abs = uint64(-a)
+	}
+
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/Scale, fractionDigits, abs%Scale)
+}
+
+// MarshalJSON writes the amount as the json number without trailing zeros, e.g. 10.5 or 42
+func (a Amount) MarshalJSON() ([]byte, error) {
+	s := a.String()
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+
+	return []byte(s), nil
+}
+
+// UnmarshalJSON accepts the json number or the string with the number, the value must be exact to the hundredth,
+// every error wraps ErrBadAmount
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	amount, err := Parse(s)
+	if err != nil {
+		if !errors.Is(err, ErrBadAmount) {
+			err = errors.Join(ErrBadAmount, err)
+		}
+
+		return err
+	}
+
+	*a = amount
+
+	return nil
+}
+
+// Value stores the amount as the decimal string, postgres converts it to numeric without losses
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads numeric columns, NULL is read as zero (e.g. SUM of no rows)
+func (a *Amount) Scan(src interface{}) error {
+	var (
+		amount Amount
+		err    error
+	)
+
+	switch v := src.(type) {
+	case nil:
+		amount = 0
+	case int64:
+		amount = Amount(v * Scale)
+	case float64:
+		amount, err = Round(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		amount, err = Parse(v)
+	case []byte:
+		amount, err = Parse(string(v))
+	default:
+		err = fmt.Errorf("unsupported type %T, err=%w", src, ErrBadAmount)
+	}
+
+	if err != nil {
+		return fmt.Errorf("scan amount err=%w", err)
+	}
+
+	*a = amount
+
+	return nil
+}
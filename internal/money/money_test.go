@@ -0,0 +1,115 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for s, expected := range map[string]Amount{
+		"0":       0,
+		"42":      New(42, 0),
+		"500.5":   New(500, 50),
+		"0.01":    New(0, 1),
+		"-10.25":  -New(10, 25),
+		"1e2":     New(100, 0),
+		"729.980": New(729, 98),
+	} {
+		amount, err := Parse(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, amount, s)
+	}
+
+	for s, expectedErr := range map[string]error{
+		"":                       ErrBadAmount,
+		"abc":                    ErrBadAmount,
+		"1/3":                    ErrBadAmount,
+		"0.001":                  ErrTooPreciseValue,
+		"10.005":                 ErrTooPreciseValue,
+		"100000000000000000000":  ErrOutOfRange,
+		"-100000000000000000000": ErrOutOfRange,
+	} {
+		_, err := Parse(s)
+		require.ErrorIs(t, err, expectedErr, s)
+	}
+}
+
+func TestRound(t *testing.T) {
+	for s, expected := range map[string]Amount{
+		"10.004":  New(10, 0),
+		"10.005":  New(10, 1),
+		"-10.005": -New(10, 1),
+		"0.125":   New(0, 13),
+		"0.1249":  New(0, 12),
+		"99.999":  New(100, 0),
+	} {
+		amount, err := Round(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, amount, s)
+	}
+}
+
+func TestSumIsExact(t *testing.T) {
+	// 0.1 + 0.2 != 0.3 in float64
+	a, err := Parse("0.1")
+	require.NoError(t, err)
+	b, err := Parse("0.2")
+	require.NoError(t, err)
+
+	require.Equal(t, "0.30", (a + b).String())
+}
+
+func TestJSON(t *testing.T) {
+	for amount, expected := range map[Amount]string{
+		0:              "0",
+		New(42, 0):     "42",
+		New(500, 50):   "500.5",
+		New(0, 5):      "0.05",
+		-New(729, 98):  "-729.98",
+		New(100, 10):   "100.1",
+		-New(0, 1):     "-0.01",
+		New(10000, 0):  "10000",
+		New(1000, 1):   "1000.01",
+		New(123456, 0): "123456",
+	} {
+		data, err := json.Marshal(amount)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data))
+
+		var parsed Amount
+		require.NoError(t, json.Unmarshal(data, &parsed))
+		require.Equal(t, amount, parsed)
+	}
+
+	var parsed Amount
+	require.NoError(t, json.Unmarshal([]byte(`"12.34"`), &parsed))
+	require.Equal(t, New(12, 34), parsed)
+
+	err := json.Unmarshal([]byte(`0.001`), &parsed)
+	require.ErrorIs(t, err, ErrTooPreciseValue)
+	require.ErrorIs(t, err, ErrBadAmount)
+}
+
+func TestScan(t *testing.T) {
+	for src, expected := range map[interface{}]Amount{
+		nil:           0,
+		int64(7):      New(7, 0),
+		"10.50":       New(10, 50),
+		0.1 + 0.2:     New(0, 30),
+		"-0.01":       -New(0, 1),
+		float64(1e-3): 0,
+	} {
+		var amount Amount
+		require.NoError(t, amount.Scan(src))
+		require.Equal(t, expected, amount)
+	}
+
+	var amount Amount
+	require.NoError(t, amount.Scan([]byte("3.14")))
+	require.Equal(t, New(3, 14), amount)
+
+	require.ErrorIs(t, amount.Scan("NaN"), ErrBadAmount)
+	require.ErrorIs(t, amount.Scan(true), ErrBadAmount)
+}
@@ -0,0 +1,56 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/zlog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers messages to users. Users are known only by login,
+// so a real implementation is responsible for resolving user's contacts.
+type Notifier interface {
+	Notify(ctx context.Context, login string, message string) error
+}
+
+// LogNotifier writes messages to the service log, it's intended only for local use
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(_ context.Context, login string, message string) error {
+	zlog.Logger.Infof("Notification for user=%s: %s", login, message)
+
+	return nil
+}
+
+// FileNotifier appends messages to the file, it's intended only for local use
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(_ context.Context, login string, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open notifications file=%s, err=%w", n.path, err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), login, message); err != nil {
+		return fmt.Errorf("write notification to file=%s, err=%w", n.path, err)
+	}
+
+	return nil
+}
@@ -2,62 +2,141 @@ package accrual
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"gophermart/internal/orderscontroller/accrual/client"
 	"gophermart/internal/sql"
+	"gophermart/internal/storage"
 	"gophermart/internal/zlog"
+	"sync"
 	"time"
 )
 
-const pollingInterval = time.Second * 1
 const shutdownTimeout = time.Second * 10
 
+const defaultLeaseTTL = time.Minute * 5
+
+var (
+	ErrNegativeAccrual      = errors.New("accrual system answered negative accrual")
+	ErrUnknownAccrualStatus = errors.New("unknown accrual order status")
+)
+
+// the writer applies the updates which are already waiting together, but not more than this number
+const writerBatchSize = 50
+
+// Settings bound the load made on the accrual system and on the database
+type Settings struct {
+	// Workers is the number of concurrent requests to the accrual system
+	Workers int
+	// BatchSize limits the orders taken from the queue on every tick
+	BatchSize int
+	// PollingInterval is the period of taking orders from the queue
+	PollingInterval time.Duration
+
+	// the order which isn't finished by the check waits MinBackoff, every next attempt doubles the wait up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// the order is marked stuck after MaxAttempts checks or when it's older than MaxAge, zero doesn't limit
+	MaxAttempts int
+	MaxAge      time.Duration
+
+	// LeaseTTL is the time the order is given to this instance, it must be enough to check and write the order,
+	// zero is replaced by the default
+	LeaseTTL time.Duration
+	// InstanceID tells the leases of this instance from others, it's generated if empty
+	InstanceID string
+}
+
+// AccrualController polls the accrual system for NEW and PROCESSING orders.
+// The poller hands due orders to the pool of workers, workers pass results of checks to the single writer,
+// which saves new statuses and schedules the next checks of unfinished orders.
+// Channels are bounded, so the slow writer holds workers and busy workers hold the poller,
+// whose ticks are dropped instead of piling up.
+// Requests of all workers are spaced by the shared limiter, 429 of the accrual system pauses polling.
+// Orders are leased, so instances sharing the database don't check the same order
 type AccrualController struct {
-	client        *client.AccrualClient
-	sqlController *sql.Controller
+	client   *client.AccrualClient
+	queue    storage.AccrualQueue
+	settings Settings
+	limiter  *limiter
+
+	jobs    chan *sql.Order
+	results chan *checkResult
 
-	updaterCh chan []*sql.Order
+	// orders which are being checked or written, they aren't taken from the queue again
+	inFlightMu sync.Mutex
+	inFlight   map[string]struct{}
 
-	done chan struct{}
-	wait chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wait   chan struct{}
 }
 
 func StartNewController(
-	sqlController *sql.Controller,
+	queue storage.AccrualQueue,
 	addr string,
+	settings Settings,
 ) *AccrualController {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if settings.InstanceID == "" {
+		settings.InstanceID = makeInstanceID()
+	}
+
+	if settings.LeaseTTL <= 0 {
+		settings.LeaseTTL = defaultLeaseTTL
+	}
+
 	controller := &AccrualController{
-		client:        client.New(addr),
-		sqlController: sqlController,
+		client:   client.New(addr),
+		queue:    queue,
+		settings: settings,
+		limiter:  newLimiter(),
 
-		updaterCh: make(chan []*sql.Order, 1),
-		done:      make(chan struct{}),
-		wait:      make(chan struct{}),
+		jobs:    make(chan *sql.Order, settings.BatchSize),
+		results: make(chan *checkResult, settings.BatchSize),
+
+		inFlight: make(map[string]struct{}),
+
+		ctx:    ctx,
+		cancel: cancel,
+		wait:   make(chan struct{}),
 	}
 
-	go func() {
-		checkAccrualTicker := time.NewTicker(pollingInterval)
+	var workers sync.WaitGroup
+	for i := 0; i < settings.Workers; i++ {
+		workers.Add(1)
 
-		for {
-			select {
-			case <-checkAccrualTicker.C:
-				if err := controller.checkAccrual(); err != nil {
-					zlog.Logger.Errorf("check accrual err=%s", err)
-				}
-			case <-controller.done:
-				close(controller.wait)
+		go func() {
+			defer workers.Done()
+			controller.checkOrders()
+		}()
+	}
 
-				return
-			}
-		}
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		controller.writeResults()
 	}()
 
-	controller.startOrdersUpdater()
+	// every stage is stopped after the previous one, so the checked orders are still written
+	go func() {
+		controller.poll()
+
+		close(controller.jobs)
+		workers.Wait()
+
+		close(controller.results)
+		<-writerDone
+
+		close(controller.wait)
+	}()
 
 	return controller
 }
 
 func (c *AccrualController) Stop() {
-	close(c.done)
+	c.cancel()
 
 	select {
 	case <-c.wait:
@@ -67,82 +146,263 @@ func (c *AccrualController) Stop() {
 	}
 }
 
-func (c *AccrualController) checkAccrual() error {
-	ctx := context.Background()
+func (c *AccrualController) poll() {
+	ticker := time.NewTicker(c.settings.PollingInterval)
+	defer ticker.Stop()
 
-	orders, err := c.sqlController.GetUnexecutedOrders(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			// orders aren't taken while the accrual system asks to wait, workers would only hold them
+			if c.limiter.Paused() {
+				continue
+			}
+
+			if err := c.checkAccrual(); err != nil {
+				zlog.Logger.Errorf("check accrual err=%s", err)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// checkAccrual leases the batch of due orders and hands them to workers, it blocks while all workers are busy
+func (c *AccrualController) checkAccrual() error {
+	orders, err := c.queue.LeaseOrders(c.ctx, c.settings.InstanceID, c.settings.BatchSize, c.settings.LeaseTTL)
 	if err != nil {
 		return err
 	}
 
-	updatedOrders := c.checkOrdersStatus(ctx, orders)
-	c.handleUpdatedOrders(updatedOrders)
+	for i, order := range orders {
+		// the lease expired while the order is still checked, it's just prolonged
+		if !c.take(order.ID) {
+			continue
+		}
+
+		select {
+		case c.jobs <- order:
+		case <-c.ctx.Done():
+			// orders still checked by workers keep their leases, only the rest of the batch is released
+			undispatched := []*sql.Order{order}
+			for _, rest := range orders[i+1:] {
+				if c.take(rest.ID) {
+					undispatched = append(undispatched, rest)
+				}
+			}
+
+			c.releaseLeases(undispatched)
+			for _, rest := range undispatched {
+				c.release(rest.ID)
+			}
+
+			return nil
+		}
+	}
 
 	return nil
 }
 
-func (c *AccrualController) handleUpdatedOrders(orders []*sql.Order) {
-	if len(orders) > 0 {
-		c.updaterCh <- orders
+// releaseLeases returns the orders which won't be checked to other instances without waiting for the leases to expire
+func (c *AccrualController) releaseLeases(orders []*sql.Order) {
+	orderIDs := make([]string, 0, len(orders))
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.ID)
+	}
+
+	// leases are released on stop, so the context of the controller isn't used
+	if err := c.queue.ReleaseOrderLeases(context.Background(), c.settings.InstanceID, orderIDs); err != nil {
+		zlog.Logger.Errorf("release order leases err=%s", err)
 	}
 }
 
-func (c *AccrualController) startOrdersUpdater() {
-	go func() {
-		for {
+// checkResult is what the worker learned about the order
+type checkResult struct {
+	order *sql.Order
+	// changed is true if the order got the new status
+	changed bool
+	// err is the reason the order wasn't changed, it's saved as the last error of the order
+	err error
+	// skipped is true if the check wasn't made, the order is only released
+	skipped bool
+}
+
+// checkOrders is the worker, it asks the accrual system about orders and passes results to the writer
+func (c *AccrualController) checkOrders() {
+	for order := range c.jobs {
+		result := c.checkOrderStatus(c.ctx, order)
+		if result == nil {
+			result = &checkResult{order: order, skipped: true}
+		}
+
+		c.results <- result
+	}
+}
+
+// writeResults is the writer, one writer keeps the number of connections taken for updates low
+func (c *AccrualController) writeResults() {
+	batch := make([]*checkResult, 0, writerBatchSize)
+
+	for result := range c.results {
+		batch = append(batch, result)
+
+	collect:
+		for len(batch) < writerBatchSize {
 			select {
-			case orders := <-c.updaterCh:
-				for _, order := range orders {
-					c.updateOrder(order)
-					time.Sleep(time.Millisecond * 100)
+			case result, ok := <-c.results:
+				if !ok {
+					break collect
 				}
-			case <-c.done:
-				return
+
+				batch = append(batch, result)
+			default:
+				break collect
 			}
 		}
-	}()
+
+		c.writeBatch(batch)
+		batch = batch[:0]
+	}
 }
 
-func (c *AccrualController) updateOrder(order *sql.Order) {
+// writeBatch applies every result in its own transaction, so the rejected update doesn't affect others
+// and the rows of users aren't locked for the whole batch
+func (c *AccrualController) writeBatch(results []*checkResult) {
+	// results are written on stop too, so the context of the controller isn't used
 	ctx := context.Background()
 
-	zlog.Logger.Debugf("write new order state to db order=%+v", order)
+	written := 0
+	skipped := make([]*sql.Order, 0)
+	for _, result := range results {
+		if result.skipped {
+			skipped = append(skipped, result.order)
+		} else if c.writeResult(ctx, result) {
+			written++
+		}
+	}
 
-	err := c.sqlController.UpdateAccrual(ctx, order)
-	if err != nil {
-		zlog.Logger.Errorf("update accrual err=%s", err)
+	if len(skipped) > 0 {
+		c.releaseLeases(skipped)
+	}
+
+	for _, result := range results {
+		c.release(result.order.ID)
 	}
+
+	zlog.Logger.Debugf("accrual check results are written=%d skipped=%d of=%d", written, len(skipped), len(results))
 }
 
-func (c *AccrualController) checkOrdersStatus(ctx context.Context, orders []*sql.Order) []*sql.Order {
-	updatedOrders := make([]*sql.Order, 0)
+func (c *AccrualController) writeResult(ctx context.Context, result *checkResult) bool {
+	order := result.order
 
-	for _, order := range orders {
+	if result.changed {
+		zlog.Logger.Debugf("write new order state to db order=%+v", order)
 
-		accrualResponse, err := c.client.UpdateOrderStatus(ctx, order.ID)
-		if err != nil {
-			zlog.Logger.Infof("accrual order=%s, err=%s", order.ID, err)
-			continue
+		err := c.queue.UpdateAccrual(ctx, order)
+		if errors.Is(err, sql.ErrIllegalOrderTransition) {
+			// the order was already updated by another instance, the lease is released if it's still ours
+			zlog.Logger.Warnf("update accrual is rejected, err=%s", err)
+			c.releaseLeases([]*sql.Order{order})
+			return false
+		} else if err != nil {
+			zlog.Logger.Errorf("update accrual err=%s", err)
+			// the answer is asked again after the backoff
+			result.err = err
 		}
+	}
 
-		switch accrualResponse.Status {
-		case client.RegistredStatus:
-			if order.Status == sql.OrderStatusNew {
-				order.Status = sql.OrderStatusProcessing
-				updatedOrders = append(updatedOrders, order)
-			}
-		case client.InvalidStatus:
-			order.Status = sql.OrderStatusInvalid
-			updatedOrders = append(updatedOrders, order)
-		case client.ProcessedStatus:
-			order.Status = sql.OrderStatusProcessed
-			order.Accrual = accrualResponse.Accrual
-			updatedOrders = append(updatedOrders, order)
-		case client.ProcessingStatus:
-		default:
-			zlog.Logger.Errorf("unknown accrual order status=%s", accrualResponse.Status)
+	if result.err == nil && !isUnfinished(order) {
+		return true
+	}
+
+	check := makeOrderCheck(order, result.err, c.settings, time.Now())
+	if check.Stuck {
+		zlog.Logger.Warnf("order=%s is stuck after attempts=%d, last err=%s", order.ID, order.Attempts+1, check.LastError)
+	}
+
+	if err := c.queue.RecordOrderCheck(ctx, check); err != nil {
+		zlog.Logger.Errorf("record order check err=%s", err)
+		return false
+	}
+
+	return true
+}
+
+// checkOrderStatus applies the answer of the accrual system to the order,
+// nil is returned if the check wasn't made and the order may be taken again without waiting
+func (c *AccrualController) checkOrderStatus(ctx context.Context, order *sql.Order) *checkResult {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil
+	}
+
+	accrualResponse, err := c.client.UpdateOrderStatus(ctx, order.ID)
+
+	var rateLimitErr *client.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		c.limiter.Limited(rateLimitErr.RetryAfter, rateLimitErr.RequestsPerMinute)
+		zlog.Logger.Warnf("accrual system limits requests, order=%s, err=%s", order.ID, err)
+		return nil
+	}
+
+	if err == nil || errors.Is(err, client.ErrOrderIsNotRegisted) {
+		c.limiter.Succeeded()
+	}
+
+	if err != nil {
+		// the request is interrupted by the stop, it isn't the fault of the order
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		zlog.Logger.Infof("accrual order=%s, err=%s", order.ID, err)
+		return &checkResult{order: order, err: err}
+	}
+
+	switch accrualResponse.Status {
+	case client.RegistredStatus:
+		if order.Status == sql.OrderStatusNew {
+			order.Status = sql.OrderStatusProcessing
+			return &checkResult{order: order, changed: true}
 		}
+	case client.InvalidStatus:
+		order.Status = sql.OrderStatusInvalid
+		return &checkResult{order: order, changed: true}
+	case client.ProcessedStatus:
+		if accrualResponse.Accrual < 0 {
+			err := fmt.Errorf("accrual=%s, err=%w", accrualResponse.Accrual, ErrNegativeAccrual)
+			zlog.Logger.Errorf("order=%s, err=%s", order.ID, err)
+			return &checkResult{order: order, err: err}
+		}
+
+		order.Status = sql.OrderStatusProcessed
+		order.Accrual = accrualResponse.Accrual
+		return &checkResult{order: order, changed: true}
+	case client.ProcessingStatus:
+	default:
+		err := fmt.Errorf("status=%s, err=%w", accrualResponse.Status, ErrUnknownAccrualStatus)
+		zlog.Logger.Errorf("order=%s, err=%s", order.ID, err)
+		return &checkResult{order: order, err: err}
 	}
 
-	return updatedOrders
+	return &checkResult{order: order}
+}
+
+func (c *AccrualController) take(orderID string) bool {
+	c.inFlightMu.Lock()
+	defer c.inFlightMu.Unlock()
+
+	if _, ok := c.inFlight[orderID]; ok {
+		return false
+	}
+
+	c.inFlight[orderID] = struct{}{}
+
+	return true
+}
+
+func (c *AccrualController) release(orderID string) {
+	c.inFlightMu.Lock()
+	defer c.inFlightMu.Unlock()
+
+	delete(c.inFlight, orderID)
 }
@@ -0,0 +1,414 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControllerProcessesOrdersWithBoundedWorkers(t *testing.T) {
+	const workers = 3
+	const ordersNum = 20
+
+	var active, maxActive atomic.Int32
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := active.Add(1)
+		defer active.Add(-1)
+
+		for {
+			seen := maxActive.Load()
+			if now <= seen || maxActive.CompareAndSwap(seen, now) {
+				break
+			}
+		}
+
+		orderID := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+
+		time.Sleep(time.Millisecond * 5)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":"%s","status":"PROCESSED","accrual":1.5}`, orderID)
+	}))
+	defer accrualSystem.Close()
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	for i := 0; i < ordersNum; i++ {
+		require.NoError(t, storage.CreateOrder(ctx, "user", fmt.Sprintf("order-%d", i)))
+	}
+
+	controller := StartNewController(storage, accrualSystem.URL, Settings{
+		Workers:         workers,
+		BatchSize:       7,
+		PollingInterval: time.Millisecond * 10,
+	})
+
+	require.Eventually(t, func() bool {
+		orders, err := storage.GetUnexecutedOrders(ctx, ordersNum)
+		return err == nil && len(orders) == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	controller.Stop()
+
+	user, err := storage.FindUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "30.00", user.Balance.String())
+
+	assert.LessOrEqual(t, maxActive.Load(), int32(workers))
+
+	for i := 0; i < ordersNum; i++ {
+		order, err := storage.FindOrder(ctx, fmt.Sprintf("order-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, sql.OrderStatusProcessed, order.Status)
+	}
+}
+
+func TestControllerStopsWhileAccrualSystemIsSlow(t *testing.T) {
+	release := make(chan struct{})
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrualSystem.Close()
+	defer close(release)
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, storage.CreateOrder(ctx, "user", fmt.Sprintf("order-%d", i)))
+	}
+
+	controller := StartNewController(storage, accrualSystem.URL, Settings{
+		Workers:         1,
+		BatchSize:       1,
+		PollingInterval: time.Millisecond,
+	})
+
+	time.Sleep(time.Millisecond * 50)
+
+	stopped := make(chan struct{})
+	go func() {
+		controller.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		t.Fatal("controller isn't stopped")
+	}
+}
+
+func TestControllerPausesOnRequestLimit(t *testing.T) {
+	var mu sync.Mutex
+	var requestTimes []time.Time
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestTimes = append(requestTimes, time.Now())
+		first := len(requestTimes) == 1
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte("No more than 600 requests per minute allowed"))
+
+			return
+		}
+
+		orderID := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":"%s","status":"INVALID"}`, orderID)
+	}))
+	defer accrualSystem.Close()
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, storage.CreateOrder(ctx, "user", fmt.Sprintf("order-%d", i)))
+	}
+
+	controller := StartNewController(storage, accrualSystem.URL, Settings{
+		Workers:         1,
+		BatchSize:       5,
+		PollingInterval: time.Millisecond * 10,
+	})
+	defer controller.Stop()
+
+	require.Eventually(t, func() bool {
+		orders, err := storage.GetUnexecutedOrders(ctx, 5)
+		return err == nil && len(orders) == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, requestTimes, 6)
+	assert.GreaterOrEqual(t, requestTimes[1].Sub(requestTimes[0]), time.Millisecond*900)
+
+	// 600 requests per minute keep requests 100ms apart after the pause
+	for i := 2; i < len(requestTimes); i++ {
+		assert.GreaterOrEqual(t, requestTimes[i].Sub(requestTimes[i-1]), time.Millisecond*90)
+	}
+}
+
+func TestControllerMarksOrderStuck(t *testing.T) {
+	var requests atomic.Int32
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		orderID := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":"%s","status":"REGISTERED"}`, orderID)
+	}))
+	defer accrualSystem.Close()
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	require.NoError(t, storage.CreateOrder(ctx, "user", "order"))
+
+	controller := StartNewController(storage, accrualSystem.URL, Settings{
+		Workers:         2,
+		BatchSize:       10,
+		PollingInterval: time.Millisecond,
+		MinBackoff:      time.Millisecond * 20,
+		MaxBackoff:      time.Millisecond * 40,
+		MaxAttempts:     3,
+	})
+	defer controller.Stop()
+
+	require.Eventually(t, func() bool {
+		orders, err := storage.GetStuckOrders(ctx, 10, 0)
+		return err == nil && len(orders) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// the stuck order isn't checked anymore
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int32(3), requests.Load())
+
+	order, err := storage.FindOrder(ctx, "order")
+	require.NoError(t, err)
+	assert.Equal(t, sql.OrderStatusProcessing, order.Status)
+	assert.Equal(t, 3, order.Attempts)
+}
+
+func TestControllersShareQueueWithoutDuplicates(t *testing.T) {
+	const ordersNum = 30
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderID := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+
+		mu.Lock()
+		requests[orderID]++
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 2)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":"%s","status":"PROCESSED","accrual":1}`, orderID)
+	}))
+	defer accrualSystem.Close()
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	for i := 0; i < ordersNum; i++ {
+		require.NoError(t, storage.CreateOrder(ctx, "user", fmt.Sprintf("order-%d", i)))
+	}
+
+	settings := Settings{
+		Workers:         3,
+		BatchSize:       4,
+		PollingInterval: time.Millisecond,
+		LeaseTTL:        time.Minute,
+	}
+
+	first := StartNewController(storage, accrualSystem.URL, settings)
+	defer first.Stop()
+
+	second := StartNewController(storage, accrualSystem.URL, settings)
+	defer second.Stop()
+
+	require.NotEqual(t, first.settings.InstanceID, second.settings.InstanceID)
+
+	require.Eventually(t, func() bool {
+		orders, err := storage.GetUnexecutedOrders(ctx, ordersNum)
+		return err == nil && len(orders) == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, requests, ordersNum)
+	for orderID, n := range requests {
+		assert.Equal(t, 1, n, "order=%s", orderID)
+	}
+
+	user, err := storage.FindUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "30.00", user.Balance.String())
+}
+
+func TestControllerReleasesLeasesOnStop(t *testing.T) {
+	release := make(chan struct{})
+
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer accrualSystem.Close()
+	defer close(release)
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, storage.CreateOrder(ctx, "user", fmt.Sprintf("order-%d", i)))
+	}
+
+	controller := StartNewController(storage, accrualSystem.URL, Settings{
+		Workers:         1,
+		BatchSize:       5,
+		PollingInterval: time.Millisecond,
+		LeaseTTL:        time.Hour,
+	})
+
+	require.Eventually(t, func() bool {
+		for i := 0; i < 5; i++ {
+			order, err := storage.FindOrder(ctx, fmt.Sprintf("order-%d", i))
+			if err != nil || order.LeaseOwner == nil {
+				return false
+			}
+		}
+
+		return true
+	}, time.Second, time.Millisecond*5)
+
+	controller.Stop()
+
+	// the orders of the stopped instance are taken without waiting for the leases to expire
+	orders, err := storage.LeaseOrders(ctx, "other", 5, time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, orders, 5)
+}
+
+// rejectingQueue rejects updates of orders as if they were already updated by another instance
+type rejectingQueue struct {
+	*memory.Storage
+
+	rejected atomic.Bool
+}
+
+func (q *rejectingQueue) LeaseOrders(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*sql.Order, error) {
+	// the order isn't taken again after the rejection, so its lease is checked without races
+	if q.rejected.Load() {
+		return nil, nil
+	}
+
+	return q.Storage.LeaseOrders(ctx, owner, limit, ttl)
+}
+
+func (q *rejectingQueue) UpdateAccrual(_ context.Context, order *sql.Order) error {
+	q.rejected.Store(true)
+
+	return fmt.Errorf("order=%s, err=%w", order.ID, sql.ErrIllegalOrderTransition)
+}
+
+func TestControllerReleasesLeaseOfRejectedUpdate(t *testing.T) {
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderID := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":"%s","status":"INVALID"}`, orderID)
+	}))
+	defer accrualSystem.Close()
+
+	ctx := context.Background()
+	queue := &rejectingQueue{Storage: memory.New()}
+
+	require.NoError(t, queue.CreateUser(ctx, "user", "hash"))
+	require.NoError(t, queue.CreateOrder(ctx, "user", "order"))
+
+	controller := StartNewController(queue, accrualSystem.URL, Settings{
+		Workers:         1,
+		BatchSize:       1,
+		PollingInterval: time.Millisecond,
+		LeaseTTL:        time.Hour,
+	})
+	defer controller.Stop()
+
+	require.Eventually(t, func() bool {
+		order, err := queue.FindOrder(ctx, "order")
+		return err == nil && queue.rejected.Load() && order.LeaseOwner == nil
+	}, time.Second*5, time.Millisecond*5)
+}
+
+func TestStoppedPollerKeepsLeasesOfCheckedOrders(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+
+	require.NoError(t, storage.CreateUser(ctx, "user", "hash"))
+	// the checked order is leased after the one the poller is stopped on
+	require.NoError(t, storage.CreateOrder(ctx, "user", "first"))
+	require.NoError(t, storage.CreateOrder(ctx, "user", "second"))
+
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+
+	// nobody takes jobs, so the poller is stopped on the first order it dispatches
+	controller := &AccrualController{
+		queue:    storage,
+		settings: Settings{BatchSize: 2, LeaseTTL: time.Hour, InstanceID: "instance"},
+		jobs:     make(chan *sql.Order),
+		inFlight: map[string]struct{}{"second": {}},
+		ctx:      stopped,
+	}
+
+	require.NoError(t, controller.checkAccrual())
+
+	checked, err := storage.FindOrder(ctx, "second")
+	require.NoError(t, err)
+	require.NotNil(t, checked.LeaseOwner)
+	assert.Equal(t, "instance", *checked.LeaseOwner)
+
+	waiting, err := storage.FindOrder(ctx, "first")
+	require.NoError(t, err)
+	assert.Nil(t, waiting.LeaseOwner)
+
+	assert.Equal(t, map[string]struct{}{"second": {}}, controller.inFlight)
+}
@@ -13,6 +13,8 @@ import (
 
 const accrualEndpoint = "/api/orders/"
 
+const maxRateLimitMessageSize = 1024
+
 type AccrualClient struct {
 	cl  http.Client
 	url string
@@ -58,7 +60,10 @@ func (c *AccrualClient) UpdateOrderStatus(ctx context.Context, orderID string) (
 	case orderIsNotRegistredStatusCode:
 		return nil, ErrOrderIsNotRegisted
 	case requestLimitExcededStatusCode:
-		return nil, ErrRequestLimitExceeded
+		// the message is short, the limit keeps a broken response from being read whole
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxRateLimitMessageSize))
+
+		return nil, makeRateLimitError(resp.Header, body, time.Now())
 	default:
 		zlog.Logger.Errorf("Unknown status code from accrual system code=%d", resp.StatusCode)
 		return nil, ErrUnknownStatusCode
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// the accrual system is asked again after this period if 429 comes without Retry-After
+const defaultRetryAfter = time.Second * 60
+
+// the accrual system explains the limit as "No more than N requests per minute allowed"
+var rateLimitMessage = regexp.MustCompile(`(?i)no more than (\d+) requests per minute`)
+
+// RateLimitError is returned on 429, it's matched by ErrRequestLimitExceeded
+type RateLimitError struct {
+	RetryAfter time.Duration
+	// RequestsPerMinute is zero if the accrual system doesn't tell the limit
+	RequestsPerMinute int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("retry after=%s requests per minute=%d, err=%s", e.RetryAfter, e.RequestsPerMinute, ErrRequestLimitExceeded)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRequestLimitExceeded
+}
+
+func makeRateLimitError(header http.Header, body []byte, now time.Time) *RateLimitError {
+	return &RateLimitError{
+		RetryAfter:        parseRetryAfter(header.Get("Retry-After"), now),
+		RequestsPerMinute: parseRequestsPerMinute(body),
+	}
+}
+
+// parseRetryAfter accepts both forms of the header: delay in seconds and http date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return defaultRetryAfter
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := at.Sub(now); delay > 0 {
+			return delay
+		}
+
+		return 0
+	}
+
+	return defaultRetryAfter
+}
+
+func parseRequestsPerMinute(body []byte) int {
+	match := rateLimitMessage.FindSubmatch(body)
+	if match == nil {
+		return 0
+	}
+
+	limit, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+
+	return limit
+}
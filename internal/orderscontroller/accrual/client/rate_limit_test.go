@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateOrderStatusReturnsRateLimitError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 10 requests per minute allowed"))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL).UpdateOrderStatus(context.Background(), "12345678903")
+	require.ErrorIs(t, err, ErrRequestLimitExceeded)
+
+	var rateLimitErr *RateLimitError
+	require.True(t, errors.As(err, &rateLimitErr))
+	assert.Equal(t, time.Minute, rateLimitErr.RetryAfter)
+	assert.Equal(t, 10, rateLimitErr.RequestsPerMinute)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "seconds", value: "5", want: time.Second * 5},
+		{name: "zero", value: "0", want: 0},
+		{name: "http date", value: now.Add(time.Second * 30).Format(http.TimeFormat), want: time.Second * 30},
+		{name: "past http date", value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "missing", value: "", want: defaultRetryAfter},
+		{name: "negative", value: "-1", want: defaultRetryAfter},
+		{name: "garbage", value: "soon", want: defaultRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.value, now))
+		})
+	}
+}
+
+func TestParseRequestsPerMinute(t *testing.T) {
+	assert.Equal(t, 10, parseRequestsPerMinute([]byte("No more than 10 requests per minute allowed")))
+	assert.Equal(t, 0, parseRequestsPerMinute([]byte("Too Many Requests")))
+	assert.Equal(t, 0, parseRequestsPerMinute(nil))
+}
@@ -1,6 +1,11 @@
 package client
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/internal/money"
+)
 
 const (
 	RegistredStatus  = "REGISTERED"
@@ -25,7 +30,35 @@ var ErrOrderIsNotRegistredInAccrual = errors.New("order isn't registred in accru
 var ErrRequestsLimitExceeded = errors.New("accrual system request limit exceeded")
 
 type AccrualResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual"`
+}
+
+// UnmarshalJSON rounds the accrual to the hundredth, the accrual system isn't limited in precision
+func (r *AccrualResponse) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Order   string      `json:"order"`
+		Status  string      `json:"status"`
+		Accrual json.Number `json:"accrual"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	r.Order = raw.Order
+	r.Status = raw.Status
+	r.Accrual = 0
+
+	if raw.Accrual != "" {
+		accrual, err := money.Round(raw.Accrual.String())
+		if err != nil {
+			return fmt.Errorf("accrual of order=%s err=%w", raw.Order, err)
+		}
+
+		r.Accrual = accrual
+	}
+
+	return nil
 }
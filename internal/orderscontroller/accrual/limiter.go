@@ -0,0 +1,111 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// the interval isn't made shorter than this after 429 without the known limit
+	minLimitedInterval = time.Millisecond * 10
+	// every successful request makes the interval shorter by this part
+	intervalRecoveryPart = 16
+)
+
+// limiter spaces the requests of all workers to the accrual system.
+// 429 pauses every request for Retry-After and doubles the interval between requests,
+// successful requests make the interval shorter again, but not shorter than the limit told by the accrual system
+type limiter struct {
+	mu sync.Mutex
+
+	interval    time.Duration
+	floor       time.Duration
+	next        time.Time
+	pausedUntil time.Time
+
+	now func() time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{now: time.Now}
+}
+
+// Wait takes the next free slot and sleeps until it comes
+func (l *limiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.now()
+
+	slot := now
+	if l.pausedUntil.After(slot) {
+		slot = l.pausedUntil
+	}
+
+	if l.next.After(slot) {
+		slot = l.next
+	}
+
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Paused is true until Retry-After of the last 429 is passed
+func (l *limiter) Paused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pausedUntil.After(l.now())
+}
+
+// Limited applies 429, requestsPerMinute is zero if the limit is unknown
+func (l *limiter) Limited(retryAfter time.Duration, requestsPerMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if pausedUntil := l.now().Add(retryAfter); pausedUntil.After(l.pausedUntil) {
+		l.pausedUntil = pausedUntil
+	}
+
+	if requestsPerMinute > 0 {
+		l.floor = time.Minute / time.Duration(requestsPerMinute)
+	}
+
+	l.interval *= 2
+	if l.interval < minLimitedInterval {
+		l.interval = minLimitedInterval
+	}
+
+	if l.interval < l.floor {
+		l.interval = l.floor
+	}
+}
+
+// Succeeded makes the interval shorter after the accrual system answered without 429
+func (l *limiter) Succeeded() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval -= l.interval / intervalRecoveryPart
+	if l.interval < minLimitedInterval {
+		l.interval = 0
+	}
+
+	if l.interval < l.floor {
+		l.interval = l.floor
+	}
+}
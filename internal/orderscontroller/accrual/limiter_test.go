@@ -0,0 +1,73 @@
+package accrual
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterIntervalAdapts(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	l := newLimiter()
+	l.now = func() time.Time { return now }
+
+	l.Limited(time.Second, 0)
+	assert.Equal(t, minLimitedInterval, l.interval)
+	assert.True(t, l.Paused())
+
+	l.Limited(time.Second, 0)
+	assert.Equal(t, minLimitedInterval*2, l.interval)
+
+	// the told limit is the floor of the interval
+	l.Limited(time.Second, 60)
+	assert.Equal(t, time.Second, l.interval)
+
+	for i := 0; i < 100; i++ {
+		l.Succeeded()
+	}
+	assert.Equal(t, time.Second, l.interval)
+
+	now = now.Add(time.Second)
+	assert.False(t, l.Paused())
+}
+
+func TestLimiterRecoversWithoutKnownLimit(t *testing.T) {
+	l := newLimiter()
+
+	l.Limited(0, 0)
+	l.Limited(0, 0)
+	require.NotZero(t, l.interval)
+
+	for i := 0; i < 100; i++ {
+		l.Succeeded()
+	}
+	assert.Zero(t, l.interval)
+}
+
+func TestLimiterWaitsForPause(t *testing.T) {
+	l := newLimiter()
+	l.Limited(time.Millisecond*50, 0)
+
+	start := time.Now()
+	require.NoError(t, l.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*40)
+
+	// the next request is spaced by the interval
+	start = time.Now()
+	require.NoError(t, l.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), minLimitedInterval/2)
+}
+
+func TestLimiterWaitIsCanceled(t *testing.T) {
+	l := newLimiter()
+	l.Limited(time.Minute, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	assert.ErrorIs(t, l.Wait(ctx), context.DeadlineExceeded)
+}
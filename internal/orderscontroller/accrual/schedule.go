@@ -0,0 +1,45 @@
+package accrual
+
+import (
+	"gophermart/internal/sql"
+	"time"
+)
+
+// backoff is the wait before the next check after the given number of attempts, it's doubled by every attempt
+func backoff(attempts int, settings Settings) time.Duration {
+	wait := settings.MinBackoff
+	for i := 1; i < attempts && wait < settings.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > settings.MaxBackoff {
+		wait = settings.MaxBackoff
+	}
+
+	return wait
+}
+
+// makeOrderCheck schedules the next check of the order which isn't finished by the current one
+func makeOrderCheck(order *sql.Order, checkErr error, settings Settings, now time.Time) *sql.OrderCheck {
+	attempts := order.Attempts + 1
+
+	check := &sql.OrderCheck{
+		OrderID:     order.ID,
+		NextCheckAt: now.Add(backoff(attempts, settings)),
+		LeaseOwner:  settings.InstanceID,
+	}
+
+	if checkErr != nil {
+		check.LastError = checkErr.Error()
+	}
+
+	tooManyAttempts := settings.MaxAttempts > 0 && attempts >= settings.MaxAttempts
+	tooOld := settings.MaxAge > 0 && now.Sub(order.UploadedAt) >= settings.MaxAge
+	check.Stuck = tooManyAttempts || tooOld
+
+	return check
+}
+
+func isUnfinished(order *sql.Order) bool {
+	return order.Status == sql.OrderStatusNew || order.Status == sql.OrderStatusProcessing
+}
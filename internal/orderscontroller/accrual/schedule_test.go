@@ -0,0 +1,54 @@
+package accrual
+
+import (
+	"errors"
+	"gophermart/internal/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	settings := Settings{MinBackoff: time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: time.Second * 2},
+		{attempts: 5, want: time.Second * 16},
+		{attempts: 7, want: time.Minute},
+		{attempts: 1000, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, backoff(tt.attempts, settings), "attempts=%d", tt.attempts)
+	}
+}
+
+func TestMakeOrderCheck(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	settings := Settings{MinBackoff: time.Second, MaxBackoff: time.Minute, MaxAttempts: 3, MaxAge: time.Hour}
+
+	order := &sql.Order{ID: "1", Attempts: 1, UploadedAt: now.Add(-time.Minute)}
+
+	check := makeOrderCheck(order, errors.New("unavailable"), settings, now)
+	assert.Equal(t, "1", check.OrderID)
+	assert.Equal(t, now.Add(time.Second*2), check.NextCheckAt)
+	assert.Equal(t, "unavailable", check.LastError)
+	assert.False(t, check.Stuck)
+
+	order.Attempts = 2
+	assert.True(t, makeOrderCheck(order, nil, settings, now).Stuck)
+
+	order.Attempts = 0
+	order.UploadedAt = now.Add(-time.Hour)
+	assert.True(t, makeOrderCheck(order, nil, settings, now).Stuck)
+
+	settings.MaxAttempts = 0
+	settings.MaxAge = 0
+	order.Attempts = 1000
+	assert.False(t, makeOrderCheck(order, nil, settings, now).Stuck)
+}
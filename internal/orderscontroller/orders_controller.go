@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"gophermart/internal/sql"
+	"gophermart/internal/storage"
 )
 
 type OrderStatus int
@@ -19,23 +20,23 @@ var ErrOrderRegistredByOtherUser = errors.New("order was registred by other user
 var ErrOrdersListEmpty = errors.New("orders list empty")
 
 type OrdersController struct {
-	sqlController *sql.Controller
+	orders storage.Orders
 }
 
-func NewOrdersController(sqlController *sql.Controller) *OrdersController {
+func NewOrdersController(orders storage.Orders) *OrdersController {
 	return &OrdersController{
-		sqlController: sqlController,
+		orders: orders,
 	}
 }
 
 func (c *OrdersController) AddOrder(ctx context.Context, login string, orderID string) (OrderStatus, error) {
-	order, err := c.sqlController.FindOrder(ctx, orderID)
+	order, err := c.orders.FindOrder(ctx, orderID)
 	if err != nil {
 		if !errors.Is(err, sql.ErrOrderIsNotFound) {
 			return OrderUnknownStatus, fmt.Errorf("find user=%s order=%s err=%w", login, orderID, err)
 		}
 
-		if err := c.sqlController.CreateOrder(ctx, login, orderID); err != nil {
+		if err := c.orders.CreateOrder(ctx, login, orderID); err != nil {
 			if errors.Is(err, sql.ErrOrderAlreadyExist) {
 				return OrderAlreadyExistsStatus, nil
 			}
@@ -53,8 +54,8 @@ func (c *OrdersController) AddOrder(ctx context.Context, login string, orderID s
 	return OrderAlreadyExistsStatus, nil
 }
 
-func (c *OrdersController) GerOrders(ctx context.Context, login string) ([]*sql.Order, error) {
-	orders, err := c.sqlController.GetUserOrders(ctx, login)
+func (c *OrdersController) GerOrders(ctx context.Context, login string, period sql.Period) ([]*sql.Order, error) {
+	orders, err := c.orders.GetUserOrders(ctx, login, period)
 	if err != nil {
 		return nil, err
 	}
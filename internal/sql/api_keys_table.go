@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	createAPIKeyQuery = `INSERT INTO api_keys (login, name, key_hash, prefix, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+
+	getAPIKeyQuery      = `SELECT ` + apiKeyColumns + ` FROM ` + apiKeysWithUsers + ` WHERE k.key_hash = $1;`
+	getUserAPIKeysQuery = `SELECT ` + apiKeyColumns + ` FROM ` + apiKeysWithUsers + ` WHERE k.login = $1 ORDER BY k.created_at;`
+	touchAPIKeyQuery    = `UPDATE api_keys SET last_used_at = $1 WHERE id = $2;`
+	deleteAPIKeyQuery   = `DELETE FROM api_keys WHERE id = $1 AND login = $2;`
+
+	apiKeyColumns    = `k.id, k.login, k.name, k.key_hash, k.prefix, k.scopes, k.created_at, k.expires_at, k.last_used_at, u.role, u.blocked`
+	apiKeysWithUsers = `api_keys k JOIN users u ON u.login = k.login`
+)
+
+// APIKey's hash is a digest of the key which is shown to the user only once, prefix helps to recognize the key in the list
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Login      string     `json:"-"`
+	Name       string     `json:"name"`
+	KeyHash    string     `json:"-"`
+	Prefix     string     `json:"prefix"`
+	Scopes     string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Role       string     `json:"-"`
+	Blocked    bool       `json:"-"`
+}
+
+func (k *APIKey) scan(rows pgx.Rows) error {
+	var lastUsedAt sql.NullTime
+
+	err := rows.Scan(&k.ID, &k.Login, &k.Name, &k.KeyHash, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &lastUsedAt, &k.Role, &k.Blocked)
+	if err != nil {
+		return fmt.Errorf("api key scan err=%w", err)
+	}
+
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return nil
+}
+
+func prepareCreateAPIKeyQuery(key *APIKey) *query {
+	return &query{
+		request: createAPIKeyQuery,
+		args: []interface{}{
+			key.Login,
+			key.Name,
+			key.KeyHash,
+			key.Prefix,
+			key.Scopes,
+			key.CreatedAt,
+			key.ExpiresAt,
+		},
+	}
+}
+
+func prepareGetAPIKeyQuery(keyHash string) *query {
+	return &query{
+		request: getAPIKeyQuery,
+		args:    []interface{}{keyHash},
+	}
+}
+
+func prepareGetUserAPIKeysQuery(login string) *query {
+	return &query{
+		request: getUserAPIKeysQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareTouchAPIKeyQuery(id int64, lastUsedAt time.Time) *query {
+	return &query{
+		request: touchAPIKeyQuery,
+		args:    []interface{}{lastUsedAt, id},
+	}
+}
+
+func prepareDeleteAPIKeyQuery(login string, id int64) *query {
+	return &query{
+		request: deleteAPIKeyQuery,
+		args:    []interface{}{id, login},
+	}
+}
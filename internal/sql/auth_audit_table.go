@@ -0,0 +1,61 @@
+package sql
+
+import "time"
+
+const (
+	// audit of deleted user is kept without personal data
+	anonymizeAuthAuditQuery = `UPDATE auth_audit SET login = $2, ip = '', user_agent = '' WHERE login = $1;`
+
+	addAuthAuditRecordQuery = `INSERT INTO auth_audit (login, event, details, ip, user_agent, created_at) VALUES ($1, $2, $3, $4, $5, $6);`
+)
+
+type AuthEvent string
+
+const (
+	AuthEventLoginSucceeded AuthEvent = "LOGIN_SUCCEEDED"
+	AuthEventLoginFailed    AuthEvent = "LOGIN_FAILED"
+	AuthEventLoginThrottled AuthEvent = "LOGIN_THROTTLED"
+
+	AuthEventSecondFactorRequired AuthEvent = "SECOND_FACTOR_REQUIRED"
+	AuthEventSecondFactorFailed   AuthEvent = "SECOND_FACTOR_FAILED"
+	AuthEventSecondFactorEnabled  AuthEvent = "SECOND_FACTOR_ENABLED"
+	AuthEventSecondFactorDisabled AuthEvent = "SECOND_FACTOR_DISABLED"
+	AuthEventRecoveryCodeUsed     AuthEvent = "RECOVERY_CODE_USED"
+
+	AuthEventUserBlocked     AuthEvent = "USER_BLOCKED"
+	AuthEventUserUnblocked   AuthEvent = "USER_UNBLOCKED"
+	AuthEventUserLoggedOut   AuthEvent = "USER_LOGGED_OUT"
+	AuthEventUserRoleChanged AuthEvent = "USER_ROLE_CHANGED"
+	AuthEventUserDeleted     AuthEvent = "USER_DELETED"
+)
+
+// AuthAuditRecord isn't linked with users table, attempts for unknown logins are recorded too
+type AuthAuditRecord struct {
+	Login     string
+	Event     AuthEvent
+	Details   string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+func prepareAddAuthAuditRecordQuery(record *AuthAuditRecord) *query {
+	return &query{
+		request: addAuthAuditRecordQuery,
+		args: []interface{}{
+			record.Login,
+			record.Event,
+			record.Details,
+			record.IP,
+			record.UserAgent,
+			record.CreatedAt,
+		},
+	}
+}
+
+func prepareAnonymizeAuthAuditQuery(login string, anonymizedLogin string) *query {
+	return &query{
+		request: anonymizeAuthAuditQuery,
+		args:    []interface{}{login, anonymizedLogin},
+	}
+}
@@ -0,0 +1,84 @@
+package sql
+
+import (
+	"fmt"
+	"gophermart/internal/money"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	addBalanceAdjustmentQuery = `INSERT INTO balance_adjustments (login, operator, reason, amount, balance_before, balance_after, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+
+	getUserBalanceAdjustmentsQuery = `SELECT id, login, operator, reason, amount, balance_before, balance_after, created_at
+		FROM balance_adjustments WHERE login = $1 ORDER BY created_at;`
+
+	// adjustments made by deleted operator stay in the history of other users
+	anonymizeBalanceAdjustmentsOperatorQuery = `UPDATE balance_adjustments SET operator = $2 WHERE operator = $1;`
+)
+
+// BalanceAdjustment is the manual change of user's balance made by an operator outside the accrual flow,
+// positive amount credits the balance and negative one debits it
+type BalanceAdjustment struct {
+	ID            int64        `json:"id"`
+	Login         string       `json:"login"`
+	Operator      string       `json:"operator"`
+	Reason        string       `json:"reason"`
+	Amount        money.Amount `json:"amount"`
+	BalanceBefore money.Amount `json:"balance_before"`
+	BalanceAfter  money.Amount `json:"balance_after"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+func (a *BalanceAdjustment) scan(rows pgx.Rows) error {
+	err := rows.Scan(&a.ID, &a.Login, &a.Operator, &a.Reason, &a.Amount, &a.BalanceBefore, &a.BalanceAfter, &a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("balance adjustment scan err=%w", err)
+	}
+
+	return nil
+}
+
+func scanIDFromRows(rows pgx.Rows) (int64, error) {
+	if err := nextRow(rows); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	if err := rows.Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func prepareAddBalanceAdjustmentQuery(adjustment *BalanceAdjustment) *query {
+	return &query{
+		request: addBalanceAdjustmentQuery,
+		args: []interface{}{
+			adjustment.Login,
+			adjustment.Operator,
+			adjustment.Reason,
+			adjustment.Amount,
+			adjustment.BalanceBefore,
+			adjustment.BalanceAfter,
+			adjustment.CreatedAt,
+		},
+	}
+}
+
+func prepareGetUserBalanceAdjustmentsQuery(login string) *query {
+	return &query{
+		request: getUserBalanceAdjustmentsQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareAnonymizeBalanceAdjustmentsOperatorQuery(operator string, anonymizedOperator string) *query {
+	return &query{
+		request: anonymizeBalanceAdjustmentsOperatorQuery,
+		args:    []interface{}{operator, anonymizedOperator},
+	}
+}
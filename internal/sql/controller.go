@@ -2,28 +2,40 @@ package sql
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
+	"gophermart/internal/money"
 	"gophermart/internal/zlog"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
-	createTablesTimeout = time.Second * 10
+	migrateTimeout = time.Minute
 
 	createUserTimeout = time.Second * 1
 	getUserTimeout    = time.Second * 1
+	updateUserTimeout = time.Second * 1
 	withdrawTimeout   = time.Second * 3
 
+	getSessionTimeout       = time.Second * 1
+	getLoginThrottleTimeout = time.Second * 1
+
 	getOrderTimeout           = time.Second * 1
 	getAllOrdersTimeout       = time.Second * 10
 	createOrderTimeout        = time.Second * 1
 	updateOrderAccrualTimeout = time.Second * 2
+	recordOrderCheckTimeout   = time.Second * 1
+
+	// bounds the transactions which don't have their own timeout
+	transactionTimeout = time.Second * 3
+	exportTimeout      = time.Second * 10
+	reconcileTimeout   = time.Minute * 5
 )
 
 type query struct {
@@ -32,241 +44,1173 @@ type query struct {
 }
 
 type Controller struct {
-	db     *sql.DB
-	dbPath string
+	pool *pgxpool.Pool
+}
+
+func StartNewController(dataSourceName string, settings PoolSettings) (*Controller, error) {
+	pool, err := openPool(dataSourceName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl := &Controller{pool: pool}
+	if err := ctrl.init(); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("init, err=%w", err)
+	}
+
+	return ctrl, nil
+}
+
+func (c *Controller) Stop() error {
+	c.pool.Close()
+
+	return nil
+}
+
+// PoolStats returns the current state of the connection pool
+func (c *Controller) PoolStats() *PoolStats {
+	return makePoolStats(c.pool.Stat())
+}
+
+// init brings the schema to the latest version, instances started together apply migrations one by one
+func (c *Controller) init() error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	migrator, err := NewMigrator(c.pool)
+	if err != nil {
+		return fmt.Errorf("new migrator, err=%w", err)
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		return fmt.Errorf("apply migrations, err=%w", err)
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------------- User Methods ----------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+var ErrUserIsNotFound = errors.New("user isn't found")
+var ErrUserAlreadyExist = errors.New("user already exist")
+
+func (c *Controller) CreateUser(ctx context.Context, login string, passwordHash string) error {
+	queryFunc := c.makeExecFunc(ctx, prepareCreateUserQuery(login, passwordHash), createUserTimeout)
+
+	_, err := doQuery(queryFunc)
+	if err != nil {
+		if isNotUniqueError(err) {
+			return ErrUserAlreadyExist
+		}
+
+		return fmt.Errorf("exec create user err=%w", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) FindUser(ctx context.Context, login string) (*User, error) {
+	return c.findUser(ctx, prepareGetUserQuery(login))
+}
+
+// FindUserIgnoreCase finds user whose login differs from the given one only by case
+func (c *Controller) FindUserIgnoreCase(ctx context.Context, login string) (*User, error) {
+	return c.findUser(ctx, prepareGetUserByLowerLoginQuery(login))
+}
+
+func (c *Controller) findUser(ctx context.Context, query *query) (*User, error) {
+	queryFunc := c.makeQueryFunc(ctx, query, getUserTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do find user query err=%w", err)
+	}
+	defer rows.Close()
+
+	user := &User{}
+	if rows.Next() {
+		if err := user.scan(rows); err != nil {
+			return nil, fmt.Errorf("rows scan to user, err=%w", err)
+		}
+
+		return user, nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, ErrUserIsNotFound
+}
+
+func (c *Controller) UpdateUserPasswordHash(ctx context.Context, login string, passwordHash string) error {
+	execFunc := c.makeExecFunc(ctx, prepareUpdateUserPasswordHashQuery(login, passwordHash), updateUserTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec update user=%s password hash err=%w", login, err)
+	}
+
+	return nil
+}
+
+func (c *Controller) UpdateUserRole(ctx context.Context, login string, role string) error {
+	return c.updateUser(ctx, prepareUpdateUserRoleQuery(login, role))
+}
+
+// BlockUser marks user as blocked and closes all user's sessions
+func (c *Controller) BlockUser(ctx context.Context, login string) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	blockQuery := prepareUpdateUserBlockedQuery(login, true)
+
+	res, err := tx.Exec(ctx, blockQuery.request, blockQuery.args...)
+	if err != nil {
+		return fmt.Errorf("block user=%s err=%w", login, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrUserIsNotFound
+	}
+
+	deleteSessionsQuery := prepareDeleteUserSessionsQuery(login)
+
+	if _, err := tx.Exec(ctx, deleteSessionsQuery.request, deleteSessionsQuery.args...); err != nil {
+		return fmt.Errorf("delete sessions of user=%s err=%w", login, err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (c *Controller) UnblockUser(ctx context.Context, login string) error {
+	return c.updateUser(ctx, prepareUpdateUserBlockedQuery(login, false))
+}
+
+func (c *Controller) updateUser(ctx context.Context, query *query) error {
+	return c.execAffecting(ctx, query, ErrUserIsNotFound)
+}
+
+func (c *Controller) SearchUsers(ctx context.Context, login string, limit int, offset int) ([]*UserSummary, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareSearchUsersQuery(login, limit, offset), getUserTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do search users query err=%w", err)
+	}
+	defer rows.Close()
+
+	users := make([]*UserSummary, 0)
+	for rows.Next() {
+		user := &UserSummary{}
+		if err := user.scan(rows); err != nil {
+			return nil, fmt.Errorf("scan user summary err=%w", err)
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	return users, nil
+}
+
+// DeleteUserSessions closes all user's sessions
+func (c *Controller) DeleteUserSessions(ctx context.Context, login string) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteUserSessionsQuery(login), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec delete sessions of user=%s err=%w", login, err)
+	}
+
+	return nil
+}
+
+var ErrNotEnoughFundsInTheAccount = errors.New("there are not enough funds in the account")
+var ErrWithdrawalAlreadyExist = errors.New("withdrawal for the order already exist")
+var ErrIdempotencyKeyIsReused = errors.New("idempotency key is already used for another withdrawal")
+
+// Withdraw decreases the balance under the lock of the user's row, so concurrent withdrawals can't overdraw it.
+// The withdrawal with the idempotency key is made once, the retry with the same key and the same order and amount
+// succeeds without changing anything, empty key isn't saved
+func (c *Controller) Withdraw(ctx context.Context, login string, orderID string, amount money.Amount, idempotencyKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, withdrawTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	user, err := doTransactionQuery(ctx, tx, prepareGetUserForUpdateQuery(login), scanUserFromRows)
+	if errors.Is(err, ErrEmptyScannerResult) {
+		return ErrUserIsNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if idempotencyKey != "" {
+		previous, err := doTransactionQuery(ctx, tx, prepareGetWithdrawalByIdempotencyKeyQuery(login, idempotencyKey), scanWithdrawRecordFromRows)
+		if err == nil {
+			if previous.OrderID != orderID || previous.Accrual != amount {
+				return ErrIdempotencyKeyIsReused
+			}
+
+			return nil
+		} else if !errors.Is(err, ErrEmptyScannerResult) {
+			return fmt.Errorf("get withdrawal of user=%s by idempotency key err=%w", login, err)
+		}
+	}
+
+	if user.Balance < amount {
+		return ErrNotEnoughFundsInTheAccount
+	}
+
+	addWitdhrawalsQuery := prepareAddWithdrawalsQuery(orderID, login, amount, idempotencyKey)
+
+	_, err = tx.Exec(ctx, addWitdhrawalsQuery.request, addWitdhrawalsQuery.args...)
+	if err != nil {
+		if isNotUniqueError(err) {
+			return ErrWithdrawalAlreadyExist
+		}
+
+		return fmt.Errorf("add withdrawals query orderID=%s login=%s amount=%s err=%w", orderID, login, amount, err)
+	}
+
+	decreaseUserBalanceQury := prepareDecreaseUserBalanceQuery(login, amount)
+
+	_, err = tx.Exec(ctx, decreaseUserBalanceQury.request, decreaseUserBalanceQury.args...)
+	if err != nil {
+		return fmt.Errorf("decrese user=%s balance=%s on amount=%s err=%w", user.Login, user.Balance, amount, err)
+	}
+
+	ledgerQuery := prepareAddLedgerTransactionQuery(LedgerEntryWithdrawal, orderID, login, withdrawalsLedgerAccount, -amount, time.Now())
+
+	if _, err := tx.Exec(ctx, ledgerQuery.request, ledgerQuery.args...); err != nil {
+		return fmt.Errorf("add ledger entries of withdrawal orderID=%s login=%s err=%w", orderID, login, err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+type UserStatistic struct {
+	Balance             money.Amount
+	WithdrawalsTotalSum money.Amount
+}
+
+func (c *Controller) GetUserStatistic(ctx context.Context, login string) (*UserStatistic, error) {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	getUserQuery := prepareGetUserQuery(login)
+	withdrawaslSumQuery := prepareWithdrawalsSumQuery(login)
+
+	user, err := doTransactionQuery(ctx, tx, getUserQuery, scanUserFromRows)
+	if errors.Is(err, ErrEmptyScannerResult) {
+		return nil, ErrUserIsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	withdrawalsSum, err := doTransactionQuery(ctx, tx, withdrawaslSumQuery, scanWithdrawalsSumFromRows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		zlog.Logger.Errorf("commit tx err=%s", err)
+	}
+
+	return &UserStatistic{Balance: user.Balance, WithdrawalsTotalSum: withdrawalsSum}, nil
+}
+
+func (c *Controller) GetUserWithdrawals(ctx context.Context, user string, period Period) ([]*UserWithdrawRecord, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetAllUserWithdrawals(user, period), time.Second*5)
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do get all withdrawals of user=%s query err=%w", user, err)
+	}
+	defer rows.Close()
+
+	list := make([]*UserWithdrawRecord, 0)
+	for rows.Next() {
+		wr := &UserWithdrawRecord{}
+		if err := wr.scan(rows); err != nil {
+			return nil, fmt.Errorf("scan withdraw err=%w", err)
+		}
+
+		list = append(list, wr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	return list, nil
+}
+
+// AdjustBalance changes user's balance on the adjustment's amount and records the adjustment in one transaction,
+// the balance before and after the change are filled in the adjustment
+func (c *Controller) AdjustBalance(ctx context.Context, adjustment *BalanceAdjustment) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	user, err := doTransactionQuery(ctx, tx, prepareGetUserForUpdateQuery(adjustment.Login), scanUserFromRows)
+	if errors.Is(err, ErrEmptyScannerResult) {
+		return ErrUserIsNotFound
+	} else if err != nil {
+		return fmt.Errorf("get user=%s err=%w", adjustment.Login, err)
+	}
+
+	adjustment.BalanceBefore = user.Balance
+	adjustment.BalanceAfter = user.Balance + adjustment.Amount
+
+	if adjustment.BalanceAfter < 0 {
+		return ErrNotEnoughFundsInTheAccount
+	}
+
+	increaseBalanceQuery := prepareIncreaseUserBalanceQuery(adjustment.Login, adjustment.Amount)
+
+	if _, err := tx.Exec(ctx, increaseBalanceQuery.request, increaseBalanceQuery.args...); err != nil {
+		return fmt.Errorf("adjust user=%s balance=%s on amount=%s err=%w", user.Login, user.Balance, adjustment.Amount, err)
+	}
+
+	adjustment.ID, err = doTransactionQuery(ctx, tx, prepareAddBalanceAdjustmentQuery(adjustment), scanIDFromRows)
+	if err != nil {
+		return fmt.Errorf("add balance adjustment of user=%s err=%w", adjustment.Login, err)
+	}
+
+	ledgerQuery := prepareAddLedgerTransactionQuery(LedgerEntryAdjustment, strconv.FormatInt(adjustment.ID, 10),
+		adjustment.Login, adjustmentsLedgerAccount, adjustment.Amount, adjustment.CreatedAt)
+
+	if _, err := tx.Exec(ctx, ledgerQuery.request, ledgerQuery.args...); err != nil {
+		return fmt.Errorf("add ledger entries of adjustment=%d err=%w", adjustment.ID, err)
+	}
+
+	return tx.Commit(ctx)
 }
 
-func StartNewController(dataSourceName string) (*Controller, error) {
-	db, err := sql.Open("pgx", dataSourceName)
+func (c *Controller) GetUserBalanceAdjustments(ctx context.Context, login string) ([]*BalanceAdjustment, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetUserBalanceAdjustmentsQuery(login), getUserTimeout)
+
+	rows, err := doQuery(queryFunc)
 	if err != nil {
-		return nil, fmt.Errorf("sql open db=%s err=%w", dataSourceName, err)
+		return nil, fmt.Errorf("do get balance adjustments of user=%s query err=%w", login, err)
+	}
+	defer rows.Close()
+
+	adjustments := make([]*BalanceAdjustment, 0)
+	for rows.Next() {
+		adjustment := &BalanceAdjustment{}
+		if err := adjustment.scan(rows); err != nil {
+			return nil, err
+		}
+
+		adjustments = append(adjustments, adjustment)
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("db connection err=%w", err)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	return adjustments, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// -------------------------------------- Session Methods ---------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+var ErrSessionIsNotFound = errors.New("session isn't found")
+
+// CreateSession saves the session and fills its id
+func (c *Controller) CreateSession(ctx context.Context, session *Session) error {
+	queryFunc := c.makeQueryFunc(ctx, prepareCreateSessionQuery(session), getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return fmt.Errorf("do create session for user=%s query err=%w", session.Login, err)
+	}
+	defer rows.Close()
+
+	if err := nextRow(rows); err != nil {
+		return err
+	}
+
+	if err := rows.Scan(&session.ID); err != nil {
+		return fmt.Errorf("rows scan session id, err=%w", err)
+	}
+
+	return rows.Err()
+}
+
+func (c *Controller) FindSession(ctx context.Context, token string) (*Session, error) {
+	return c.findSession(ctx, prepareGetSessionQuery(token))
+}
+
+func (c *Controller) FindSessionByID(ctx context.Context, id int64) (*Session, error) {
+	return c.findSession(ctx, prepareGetSessionByIDQuery(id))
+}
+
+func (c *Controller) findSession(ctx context.Context, query *query) (*Session, error) {
+	queryFunc := c.makeQueryFunc(ctx, query, getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do find session query err=%w", err)
+	}
+	defer rows.Close()
+
+	if err := nextRow(rows); errors.Is(err, ErrEmptyScannerResult) {
+		return nil, ErrSessionIsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	session := &Session{}
+	if err := session.scan(rows); err != nil {
+		return nil, fmt.Errorf("rows scan to session, err=%w", err)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (c *Controller) GetUserSessions(ctx context.Context, login string) ([]*Session, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetUserSessionsQuery(login), getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do get sessions of user=%s query err=%w", login, err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		session := &Session{}
+		if err := session.scan(rows); err != nil {
+			return nil, fmt.Errorf("scan session err=%w", err)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	return sessions, nil
+}
+
+func (c *Controller) TouchSession(ctx context.Context, token string, lastSeen time.Time) error {
+	execFunc := c.makeExecFunc(ctx, prepareTouchSessionQuery(token, lastSeen), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec touch session err=%w", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) DeleteSession(ctx context.Context, token string) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteSessionQuery(token), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec delete session err=%w", err)
+	}
+
+	return nil
+}
+
+// TakeSession deletes the session and returns it, so only one of concurrent callers gets the session
+func (c *Controller) TakeSession(ctx context.Context, token string) (*Session, error) {
+	return c.findSession(ctx, prepareTakeSessionQuery(token))
+}
+
+func (c *Controller) DeleteUserSession(ctx context.Context, login string, id int64) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteSessionByIDQuery(login, id), getSessionTimeout)
+
+	res, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec delete session=%d of user=%s err=%w", id, login, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrSessionIsNotFound
+	}
+
+	return nil
+}
+
+// DeleteOtherSessions removes all user's sessions except the current one
+func (c *Controller) DeleteOtherSessions(ctx context.Context, login string, currentID int64) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteOtherSessionsQuery(login, currentID), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec delete other sessions of user=%s err=%w", login, err)
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// -------------------------------------- API Keys Methods --------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+var ErrAPIKeyIsNotFound = errors.New("api key isn't found")
+
+// CreateAPIKey saves the key and fills its id
+func (c *Controller) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	queryFunc := c.makeQueryFunc(ctx, prepareCreateAPIKeyQuery(key), getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return fmt.Errorf("do create api key for user=%s query err=%w", key.Login, err)
+	}
+	defer rows.Close()
+
+	key.ID, err = scanIDFromRows(rows)
+	if err != nil {
+		return fmt.Errorf("rows scan api key id, err=%w", err)
+	}
+
+	return rows.Err()
+}
+
+func (c *Controller) FindAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetAPIKeyQuery(keyHash), getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do find api key query err=%w", err)
+	}
+	defer rows.Close()
+
+	if err := nextRow(rows); errors.Is(err, ErrEmptyScannerResult) {
+		return nil, ErrAPIKeyIsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{}
+	if err := key.scan(rows); err != nil {
+		return nil, err
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (c *Controller) GetUserAPIKeys(ctx context.Context, login string) ([]*APIKey, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetUserAPIKeysQuery(login), getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do get api keys of user=%s query err=%w", login, err)
+	}
+	defer rows.Close()
+
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		key := &APIKey{}
+		if err := key.scan(rows); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	return keys, nil
+}
+
+func (c *Controller) TouchAPIKey(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	execFunc := c.makeExecFunc(ctx, prepareTouchAPIKeyQuery(id, lastUsedAt), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec touch api key err=%w", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) DeleteAPIKey(ctx context.Context, login string, id int64) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteAPIKeyQuery(login, id), getSessionTimeout)
+
+	res, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec delete api key=%d of user=%s err=%w", id, login, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrAPIKeyIsNotFound
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------- Password Changing Methods ---------------------------------
+// ----------------------------------------------------------------------------------------------
+
+var ErrPasswordResetTokenIsNotFound = errors.New("password reset token isn't found, used or expired")
+
+// ChangePassword updates user's password hash and closes all user's sessions except the current one
+func (c *Controller) ChangePassword(ctx context.Context, login string, passwordHash string, currentSessionID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	queries := []*query{
+		prepareUpdateUserPasswordHashQuery(login, passwordHash),
+		prepareDeleteOtherSessionsQuery(login, currentSessionID),
+		prepareDeleteUnusedPasswordResetTokensQuery(login),
+	}
+
+	for _, q := range queries {
+		if _, err := tx.Exec(ctx, q.request, q.args...); err != nil {
+			return fmt.Errorf("exec change password of user=%s query=%s err=%w", login, q.request, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (c *Controller) CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error {
+	execFunc := c.makeExecFunc(ctx, prepareCreatePasswordResetTokenQuery(token), updateUserTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec create password reset token for user=%s err=%w", token.Login, err)
+	}
+
+	return nil
+}
+
+// ResetPassword consumes the reset token, updates password hash of its user and closes all user's sessions
+func (c *Controller) ResetPassword(ctx context.Context, token string, passwordHash string, now time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	login, err := doTransactionQuery(ctx, tx, prepareUsePasswordResetTokenQuery(token, now), scanLoginFromRows)
+	if err != nil {
+		if errors.Is(err, ErrEmptyScannerResult) {
+			return "", ErrPasswordResetTokenIsNotFound
+		}
+
+		return "", fmt.Errorf("use password reset token err=%w", err)
+	}
+
+	queries := []*query{
+		prepareUpdateUserPasswordHashQuery(login, passwordHash),
+		prepareDeleteUserSessionsQuery(login),
+		prepareDeleteUnusedPasswordResetTokensQuery(login),
+	}
+
+	for _, q := range queries {
+		if _, err := tx.Exec(ctx, q.request, q.args...); err != nil {
+			return "", fmt.Errorf("exec reset password of user=%s query=%s err=%w", login, q.request, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("commit tx err=%w", err)
+	}
+
+	return login, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------- Two-Factor Authentication ---------------------------------
+// ----------------------------------------------------------------------------------------------
+
+var (
+	ErrTOTPIsNotFound           = errors.New("totp isn't enrolled")
+	ErrTOTPIsAlreadyConfirmed   = errors.New("totp is already confirmed")
+	ErrTOTPCodeIsAlreadyUsed    = errors.New("totp code is already used")
+	ErrRecoveryCodeIsNotFound   = errors.New("recovery code isn't found or used")
+	ErrLoginChallengeIsNotFound = errors.New("login challenge isn't found or expired")
+)
+
+// SaveUserTOTP saves new unconfirmed secret or replaces the previous unconfirmed one
+func (c *Controller) SaveUserTOTP(ctx context.Context, login string, secret string, now time.Time) error {
+	return c.execAffecting(ctx, prepareSaveUserTOTPQuery(login, secret, now), ErrTOTPIsAlreadyConfirmed)
+}
+
+func (c *Controller) FindUserTOTP(ctx context.Context, login string) (*UserTOTP, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetUserTOTPQuery(login), getUserTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do find totp of user=%s query err=%w", login, err)
+	}
+	defer rows.Close()
+
+	if err := nextRow(rows); errors.Is(err, ErrEmptyScannerResult) {
+		return nil, ErrTOTPIsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	totp := &UserTOTP{}
+	if err := totp.scan(rows); err != nil {
+		return nil, fmt.Errorf("rows scan to totp, err=%w", err)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totp, nil
+}
+
+// ConfirmUserTOTP enables the second factor and replaces user's recovery codes
+func (c *Controller) ConfirmUserTOTP(ctx context.Context, login string, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	confirmQuery := prepareConfirmUserTOTPQuery(login, step)
+
+	res, err := tx.Exec(ctx, confirmQuery.request, confirmQuery.args...)
+	if err != nil {
+		return fmt.Errorf("confirm totp of user=%s err=%w", login, err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return ErrTOTPIsAlreadyConfirmed
+	}
+
+	queries := []*query{prepareDeleteRecoveryCodesQuery(login)}
+	for _, codeHash := range recoveryCodeHashes {
+		queries = append(queries, prepareAddRecoveryCodeQuery(login, codeHash))
+	}
+
+	for _, q := range queries {
+		if _, err := tx.Exec(ctx, q.request, q.args...); err != nil {
+			return fmt.Errorf("exec save recovery codes of user=%s query=%s err=%w", login, q.request, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteUserTOTP disables the second factor together with recovery codes
+func (c *Controller) DeleteUserTOTP(ctx context.Context, login string) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	for _, q := range []*query{prepareDeleteUserTOTPQuery(login), prepareDeleteRecoveryCodesQuery(login)} {
+		if _, err := tx.Exec(ctx, q.request, q.args...); err != nil {
+			return fmt.Errorf("exec delete totp of user=%s query=%s err=%w", login, q.request, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep marks the code's period as used, older periods can't be used after that
+func (c *Controller) UseTOTPStep(ctx context.Context, login string, step int64) error {
+	return c.execAffecting(ctx, prepareUseTOTPStepQuery(login, step), ErrTOTPCodeIsAlreadyUsed)
+}
+
+func (c *Controller) UseRecoveryCode(ctx context.Context, login string, codeHash string, now time.Time) error {
+	return c.execAffecting(ctx, prepareUseRecoveryCodeQuery(login, codeHash, now), ErrRecoveryCodeIsNotFound)
+}
+
+func (c *Controller) CreateLoginChallenge(ctx context.Context, challenge *LoginChallenge) error {
+	execFunc := c.makeExecFunc(ctx, prepareCreateLoginChallengeQuery(challenge), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec create login challenge for user=%s err=%w", challenge.Login, err)
+	}
+
+	return nil
+}
+
+// UseLoginChallenge counts the attempt and returns the challenge with the number of attempts
+func (c *Controller) UseLoginChallenge(ctx context.Context, token string, now time.Time) (*LoginChallenge, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareUseLoginChallengeQuery(token, now), getSessionTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do use login challenge query err=%w", err)
+	}
+	defer rows.Close()
+
+	challenge, err := scanLoginChallengeFromRows(rows)
+	if err != nil {
+		if errors.Is(err, ErrEmptyScannerResult) {
+			return nil, ErrLoginChallengeIsNotFound
+		}
+
+		return nil, fmt.Errorf("rows scan to login challenge, err=%w", err)
 	}
 
-	ctrl := &Controller{db: db, dbPath: dataSourceName}
-	if err := ctrl.init(); err != nil {
-		return nil, fmt.Errorf("init, err=%w", err)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return ctrl, nil
+	challenge.Token = token
+
+	return challenge, nil
 }
 
-func (c *Controller) Stop() error {
-	if err := c.db.Close(); err != nil {
-		return fmt.Errorf("db close err=%w", err)
+func (c *Controller) DeleteLoginChallenge(ctx context.Context, token string) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteLoginChallengeQuery(token), getSessionTimeout)
+
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec delete login challenge err=%w", err)
 	}
 
 	return nil
 }
 
-func (c *Controller) init() error {
-	createTableQueries := []string{
-		createUsersTableQuery,
-		createOrdersTableQuery,
-		createWithdrawalsTableQuery,
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------ Login Throttling API ------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (c *Controller) GetLoginThrottles(ctx context.Context, keys []string) ([]*LoginThrottle, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetLoginThrottlesQuery(keys), getLoginThrottleTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("do get login throttles query err=%w", err)
 	}
+	defer rows.Close()
 
-	for _, q := range createTableQueries {
-		if err := c.exec(q); err != nil {
-			return fmt.Errorf("exec query=%s, err=%w", q, err)
+	throttles := make([]*LoginThrottle, 0, len(keys))
+	for rows.Next() {
+		throttle := &LoginThrottle{}
+		if err := throttle.scan(rows); err != nil {
+			return nil, fmt.Errorf("scan login throttle err=%w", err)
 		}
+
+		throttles = append(throttles, throttle)
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	return throttles, nil
 }
 
-func (c *Controller) exec(query string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), createTablesTimeout)
-	defer cancel()
+// AddLoginFailure increments failures counter of the key and returns its new value,
+// the counter is reset if the last failure happened before resetBefore
+func (c *Controller) AddLoginFailure(ctx context.Context, key string, now time.Time, resetBefore time.Time) (int, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareAddLoginFailureQuery(key, now, resetBefore), getLoginThrottleTimeout)
 
-	_, err := c.db.ExecContext(ctx, query)
+	rows, err := doQuery(queryFunc)
 	if err != nil {
-		return fmt.Errorf("exec create table query, err=%w", err)
+		return 0, fmt.Errorf("do add login failure query err=%w", err)
 	}
+	defer rows.Close()
 
-	return nil
-}
+	if err := nextRow(rows); err != nil {
+		return 0, err
+	}
 
-// ----------------------------------------------------------------------------------------------
-// ---------------------------------------- User Methods ----------------------------------------
-// ----------------------------------------------------------------------------------------------
+	var failures int
+	if err := rows.Scan(&failures); err != nil {
+		return 0, fmt.Errorf("scan failures err=%w", err)
+	}
 
-var ErrUserIsNotFound = errors.New("user isn't found")
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
 
-func (c *Controller) CreateUser(ctx context.Context, login string, token string) error {
-	queryFunc := c.makeExecFunc(ctx, prepareCreateUserQuery(login, token))
+	return failures, nil
+}
 
-	_, err := doQuery(queryFunc)
+func (c *Controller) BlockLogin(ctx context.Context, key string, blockedUntil time.Time) error {
+	execFunc := c.makeExecFunc(ctx, prepareBlockLoginQuery(key, blockedUntil), getLoginThrottleTimeout)
+
+	_, err := doQuery(execFunc)
 	if err != nil {
-		return fmt.Errorf("exec create user err=%w", err)
+		return fmt.Errorf("exec block login key=%s err=%w", key, err)
 	}
 
 	return nil
 }
 
-func (c *Controller) FindUser(ctx context.Context, login string) (*User, error) {
-	queryFunc := c.makeQueryFunc(ctx, prepareGetUserQuery(login), getUserTimeout)
+func (c *Controller) DeleteLoginThrottle(ctx context.Context, key string) error {
+	execFunc := c.makeExecFunc(ctx, prepareDeleteLoginThrottleQuery(key), getLoginThrottleTimeout)
 
-	rows, err := doQuery(queryFunc)
+	_, err := doQuery(execFunc)
 	if err != nil {
-		return nil, fmt.Errorf("do find user query err=%w", err)
+		return fmt.Errorf("exec delete login throttle key=%s err=%w", key, err)
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
-	}()
 
-	user := &User{}
-	if rows.Next() {
-		if err := rows.Scan(&user.Login, &user.AuthToken, &user.Balance); err != nil {
-			return nil, fmt.Errorf("rows scan to user, err=%w", err)
-		}
+	return nil
+}
 
-		return user, nil
-	}
+func (c *Controller) AddAuthAuditRecord(ctx context.Context, record *AuthAuditRecord) error {
+	execFunc := c.makeExecFunc(ctx, prepareAddAuthAuditRecordQuery(record), updateUserTimeout)
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	_, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec add auth audit record for user=%s err=%w", record.Login, err)
 	}
 
-	return nil, ErrUserIsNotFound
+	return nil
 }
 
-func (c *Controller) FindUserByToken(ctx context.Context, token string) (*User, error) {
-	queryFunc := c.makeQueryFunc(ctx, prepareGetUserByTokenQuery(token), getUserTimeout)
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------------- Ledger Methods --------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+// GetBalanceHistory returns the page of changes of the user's balance ordered by time
+func (c *Controller) GetBalanceHistory(ctx context.Context, login string, filter BalanceHistoryFilter) ([]*BalanceHistoryEntry, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetBalanceHistoryQuery(login, filter), getAllOrdersTimeout)
 
 	rows, err := doQuery(queryFunc)
 	if err != nil {
-		return nil, fmt.Errorf("do find user by token query err=%w", err)
-	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
-	}()
-
-	if !rows.Next() {
-		return nil, ErrUserIsNotFound
+		return nil, fmt.Errorf("do get balance history of user=%s query err=%w", login, err)
 	}
+	defer rows.Close()
 
-	user := &User{}
-	if err := user.scan(rows); err != nil {
-		return nil, fmt.Errorf("rows scan to user, err=%w", err)
+	history, err := scanListFromRows[BalanceHistoryEntry](rows)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("rows err=%w", err)
 	}
 
-	return user, nil
+	return history, nil
+}
 
+// LedgerReconciliation lists everything that breaks the ledger, it's empty when the ledger is consistent
+type LedgerReconciliation struct {
+	UnbalancedTransactions []*UnbalancedLedgerTransaction `json:"unbalanced_transactions"`
+	BalanceMismatches      []*LedgerBalanceMismatch       `json:"balance_mismatches"`
 }
 
-var ErrNotEnoughFundsInTheAccount = errors.New("there are not enough funds in the account")
+func (r *LedgerReconciliation) IsConsistent() bool {
+	return len(r.UnbalancedTransactions) == 0 && len(r.BalanceMismatches) == 0
+}
+
+// ReconcileLedger checks that every transaction is balanced and cached balances of users equal their ledger accounts
+func (c *Controller) ReconcileLedger(ctx context.Context) (*LedgerReconciliation, error) {
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+	defer cancel()
 
-func (c *Controller) Withdraw(ctx context.Context, login string, orderID string, amount float64) error {
-	tx, err := c.db.BeginTx(ctx, nil)
+	tx, err := c.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
 	if err != nil {
-		return fmt.Errorf("begin tx err=%w", err)
+		return nil, fmt.Errorf("begin tx err=%w", err)
 	}
 	defer func() {
-		_ = tx.Rollback()
+		_ = tx.Rollback(ctx)
 	}()
 
-	user, err := doTransactionQuery(ctx, tx, prepareGetUserQuery(login), scanUserFromRows)
-	if err != nil {
-		return err
-	}
+	result := &LedgerReconciliation{}
 
-	if user.Balance < amount {
-		return ErrNotEnoughFundsInTheAccount
+	result.UnbalancedTransactions, err = doTransactionQuery(ctx, tx, prepareGetUnbalancedLedgerTransactionsQuery(), scanListFromRows[UnbalancedLedgerTransaction])
+	if err != nil {
+		return nil, fmt.Errorf("get unbalanced ledger transactions err=%w", err)
 	}
 
-	decreaseUserBalanceQury := prepareDecreaseUserBalanceQuery(login, amount)
-
-	_, err = tx.ExecContext(ctx, decreaseUserBalanceQury.request, decreaseUserBalanceQury.args...)
+	result.BalanceMismatches, err = doTransactionQuery(ctx, tx, prepareGetLedgerBalanceMismatchesQuery(), scanListFromRows[LedgerBalanceMismatch])
 	if err != nil {
-		return fmt.Errorf("decrese user=%s balance=%.4f on amount=%.4f err=%w", user.Login, user.Balance, amount, err)
+		return nil, fmt.Errorf("get ledger balance mismatches err=%w", err)
 	}
 
-	addWitdhrawalsQuery := prepareAddWithdrawalsQuery(orderID, login, amount)
-
-	_, err = tx.ExecContext(ctx, addWitdhrawalsQuery.request, addWitdhrawalsQuery.args...)
-	if err != nil {
-		return fmt.Errorf("add withdrawals query orderID=%s login=%s amount=%.4f err=%w", orderID, login, amount, err)
+	if err := tx.Commit(ctx); err != nil {
+		zlog.Logger.Errorf("commit tx err=%s", err)
 	}
 
-	return tx.Commit()
+	return result, nil
 }
 
-type UserStatistic struct {
-	Balance             float64
-	WithdrawalsTotalSum float64
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------- Account Data Methods -----------------------------------
+// ----------------------------------------------------------------------------------------------
+
+// UserDataExport is everything stored about the user, it's read from one snapshot of the database
+type UserDataExport struct {
+	User                *User
+	WithdrawalsTotalSum money.Amount
+	SecondFactor        bool
+	Orders              []*Order
+	Withdrawals         []*UserWithdrawRecord
+	BalanceAdjustments  []*BalanceAdjustment
+	// BalanceHistory is the whole ledger of the user with the running balance
+	BalanceHistory []*BalanceHistoryEntry
 }
 
-func (c *Controller) GetUserStatistic(ctx context.Context, login string) (*UserStatistic, error) {
-	tx, err := c.db.BeginTx(ctx, nil)
+func (c *Controller) ExportUserData(ctx context.Context, login string) (*UserDataExport, error) {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	tx, err := c.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
 	if err != nil {
 		return nil, fmt.Errorf("begin tx err=%w", err)
 	}
 	defer func() {
-		_ = tx.Rollback()
+		_ = tx.Rollback(ctx)
 	}()
 
-	getUserQuery := prepareGetUserQuery(login)
-	withdrawaslSumQuery := prepareWithdrawalsSumQuery(login)
+	export := &UserDataExport{}
 
-	user, err := doTransactionQuery(ctx, tx, getUserQuery, scanUserFromRows)
-	if err != nil {
-		return nil, err
+	export.User, err = doTransactionQuery(ctx, tx, prepareGetUserQuery(login), scanUserFromRows)
+	if errors.Is(err, ErrEmptyScannerResult) {
+		return nil, ErrUserIsNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("get user=%s err=%w", login, err)
 	}
 
-	withdrawalsSum, err := doTransactionQuery(ctx, tx, withdrawaslSumQuery, scanWithdrawalsSumFromRows)
-	if err != nil {
-		return nil, err
+	if export.WithdrawalsTotalSum, err = doTransactionQuery(ctx, tx, prepareWithdrawalsSumQuery(login), scanWithdrawalsSumFromRows); err != nil {
+		return nil, fmt.Errorf("get withdrawals sum of user=%s err=%w", login, err)
+	}
+
+	if export.SecondFactor, err = doTransactionQuery(ctx, tx, prepareHasConfirmedTOTPQuery(login), scanBoolFromRows); err != nil {
+		return nil, fmt.Errorf("get totp of user=%s err=%w", login, err)
+	}
+
+	if export.Orders, err = doTransactionQuery(ctx, tx, prepareGetAllOrdersQuery(login, Period{}), scanListFromRows[Order]); err != nil {
+		return nil, fmt.Errorf("get orders of user=%s err=%w", login, err)
+	}
+
+	if export.Withdrawals, err = doTransactionQuery(ctx, tx, prepareGetAllUserWithdrawals(login, Period{}), scanListFromRows[UserWithdrawRecord]); err != nil {
+		return nil, fmt.Errorf("get withdrawals of user=%s err=%w", login, err)
+	}
+
+	adjustmentsQuery := prepareGetUserBalanceAdjustmentsQuery(login)
+	if export.BalanceAdjustments, err = doTransactionQuery(ctx, tx, adjustmentsQuery, scanListFromRows[BalanceAdjustment]); err != nil {
+		return nil, fmt.Errorf("get balance adjustments of user=%s err=%w", login, err)
+	}
+
+	historyQuery := prepareGetBalanceHistoryQuery(login, BalanceHistoryFilter{})
+	if export.BalanceHistory, err = doTransactionQuery(ctx, tx, historyQuery, scanListFromRows[BalanceHistoryEntry]); err != nil {
+		return nil, fmt.Errorf("get balance history of user=%s err=%w", login, err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		zlog.Logger.Errorf("commit tx err=%s", err)
 	}
 
-	return &UserStatistic{Balance: user.Balance, WithdrawalsTotalSum: withdrawalsSum}, nil
+	return export, nil
 }
 
-func (c *Controller) GetUserWithdrawals(ctx context.Context, user string) ([]*UserWithdrawRecord, error) {
-	queryFunc := c.makeQueryFunc(ctx, prepareGetAllUserWithdrawals(user), time.Second*5)
-	rows, err := doQuery(queryFunc)
+// DeleteUser removes the user with all user's data, records which are needed for other users
+// or for security audit are kept with anonymized login instead
+func (c *Controller) DeleteUser(ctx context.Context, login string, anonymizedLogin string) error {
+	ctx, cancel := context.WithTimeout(ctx, transactionTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("do get all withdrawals of user=%s query err=%w", user, err)
+		return fmt.Errorf("begin tx err=%w", err)
 	}
 	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
+		_ = tx.Rollback(ctx)
 	}()
 
-	list := make([]*UserWithdrawRecord, 0)
-	for rows.Next() {
-		wr := &UserWithdrawRecord{}
-		if err := wr.scan(rows); err != nil {
-			return nil, fmt.Errorf("scan withdraw err=%w", err)
+	queries := []*query{
+		prepareAnonymizeAuthAuditQuery(login, anonymizedLogin),
+		prepareAnonymizeBalanceAdjustmentsOperatorQuery(login, anonymizedLogin),
+		prepareAnonymizeLedgerAccountQuery(login, anonymizedLogin),
+		prepareDeleteUserLoginThrottleQuery(login),
+		prepareDeleteUserWithdrawalsQuery(login),
+	}
+
+	for _, q := range queries {
+		if _, err := tx.Exec(ctx, q.request, q.args...); err != nil {
+			return fmt.Errorf("exec delete user=%s query=%s err=%w", login, q.request, err)
 		}
+	}
 
-		list = append(list, wr)
+	deleteQuery := prepareDeleteUserQuery(login)
+
+	res, err := tx.Exec(ctx, deleteQuery.request, deleteQuery.args...)
+	if err != nil {
+		return fmt.Errorf("delete user=%s err=%w", login, err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows err=%w", err)
+	if res.RowsAffected() == 0 {
+		return ErrUserIsNotFound
 	}
 
-	return list, nil
+	return tx.Commit(ctx)
 }
 
 // -----------------------------------------------------------------------------------------------
@@ -277,6 +1221,8 @@ const TimestampFormat = "2006-01-02T15:04:05"
 
 var ErrOrderIsNotFound = errors.New("order isn't found")
 var ErrOrderAlreadyExist = errors.New("order already exist")
+var ErrIllegalOrderTransition = errors.New("illegal transition of order status")
+var ErrOrderIsNotStuck = errors.New("order isn't stuck")
 
 func (c *Controller) FindOrder(ctx context.Context, orderID string) (*Order, error) {
 	queryFunc := c.makeQueryFunc(ctx, prepareGetOrderQuery(orderID), getOrderTimeout)
@@ -285,15 +1231,12 @@ func (c *Controller) FindOrder(ctx context.Context, orderID string) (*Order, err
 	if err != nil {
 		return nil, fmt.Errorf("do query, err=%w", err)
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
-	}()
+	defer rows.Close()
 
-	if !rows.Next() {
+	if err := nextRow(rows); errors.Is(err, ErrEmptyScannerResult) {
 		return nil, ErrOrderIsNotFound
+	} else if err != nil {
+		return nil, err
 	}
 
 	order := &Order{}
@@ -309,12 +1252,14 @@ func (c *Controller) FindOrder(ctx context.Context, orderID string) (*Order, err
 }
 
 func (c *Controller) CreateOrder(ctx context.Context, login string, orderID string) error {
-	execFunc := c.makeExecFunc(ctx, prepareCreateOrderQuery(orderID, login))
+	execFunc := c.makeExecFunc(ctx, prepareCreateOrderQuery(orderID, login), createOrderTimeout)
 
 	_, err := doQuery(execFunc)
 	if err != nil {
 		if isNotUniqueError(err) {
 			return ErrOrderAlreadyExist
+		} else if isForeignKeyError(err) {
+			return ErrUserIsNotFound
 		}
 
 		return fmt.Errorf("create user=%s, order=%s, err=%w", login, orderID, err)
@@ -323,19 +1268,14 @@ func (c *Controller) CreateOrder(ctx context.Context, login string, orderID stri
 	return nil
 }
 
-func (c *Controller) GetUserOrders(ctx context.Context, login string) ([]*Order, error) {
-	queryFunc := c.makeQueryFunc(ctx, prepareGetAllOrdersQuery(login), getAllOrdersTimeout)
+func (c *Controller) GetUserOrders(ctx context.Context, login string, period Period) ([]*Order, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetAllOrdersQuery(login, period), getAllOrdersTimeout)
 
 	rows, err := doQuery(queryFunc)
 	if err != nil {
 		return nil, fmt.Errorf("get all orders query, err=%w", err)
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
-	}()
+	defer rows.Close()
 
 	orders := make([]*Order, 0)
 	for rows.Next() {
@@ -354,19 +1294,16 @@ func (c *Controller) GetUserOrders(ctx context.Context, login string) ([]*Order,
 	return orders, nil
 }
 
-func (c *Controller) GetUnexecutedOrders(ctx context.Context) ([]*Order, error) {
-	queryFunc := c.makeQueryFunc(ctx, prepareGetUnexecutedOrdersQuery(), getAllOrdersTimeout)
+// GetUnexecutedOrders returns up to limit NEW and PROCESSING orders which are due to be checked and aren't stuck,
+// the longest waiting first
+func (c *Controller) GetUnexecutedOrders(ctx context.Context, limit int) ([]*Order, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetUnexecutedOrdersQuery(limit), getAllOrdersTimeout)
 
 	rows, err := doQuery(queryFunc)
 	if err != nil {
 		return nil, fmt.Errorf("get unexecuted orders query, err=%w", err)
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
-	}()
+	defer rows.Close()
 
 	orders := make([]*Order, 0)
 	for rows.Next() {
@@ -386,60 +1323,199 @@ func (c *Controller) GetUnexecutedOrders(ctx context.Context) ([]*Order, error)
 	return orders, nil
 }
 
+// UpdateAccrual moves the order to the new status and credits the accrual of PROCESSED order to the order's user.
+// The status is changed by the conditional update, so the repeated update of the same order is rejected
+// with ErrIllegalOrderTransition and the accrual is credited exactly once
 func (c *Controller) UpdateAccrual(ctx context.Context, order *Order) error {
-	tx, err := c.db.BeginTx(ctx, nil)
+	ctx, cancel := context.WithTimeout(ctx, updateOrderAccrualTimeout)
+	defer cancel()
+
+	tx, err := c.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin tx err=%w", err)
 	}
 	defer func() {
-		_ = tx.Rollback()
+		_ = tx.Rollback(ctx)
 	}()
 
-	if _, err := tx.ExecContext(ctx, updateOrderAccrualQuery, order.Status, order.Accrual, order.ID); err != nil {
-		return err
+	// only the processed order brings points
+	accrual := order.Accrual
+	if order.Status != OrderStatusProcessed {
+		accrual = 0
+	}
+
+	login, err := doTransactionQuery(ctx, tx, prepareUpdateOrderStatusQuery(order.ID, order.Status, accrual), scanLoginFromRows)
+	if errors.Is(err, ErrEmptyScannerResult) {
+		current, err := doTransactionQuery(ctx, tx, prepareGetOrderQuery(order.ID), scanOrderFromRows)
+		if errors.Is(err, ErrEmptyScannerResult) {
+			return ErrOrderIsNotFound
+		} else if err != nil {
+			return fmt.Errorf("get order=%s err=%w", order.ID, err)
+		}
+
+		return fmt.Errorf("order=%s status=%s new status=%s, err=%w", order.ID, current.Status, order.Status, ErrIllegalOrderTransition)
+	} else if err != nil {
+		return fmt.Errorf("update status of order=%s err=%w", order.ID, err)
+	}
+
+	if accrual != 0 {
+		increaseBalanceQuery := prepareIncreaseUserBalanceQuery(login, accrual)
+
+		if _, err := tx.Exec(ctx, increaseBalanceQuery.request, increaseBalanceQuery.args...); err != nil {
+			return fmt.Errorf("credit accrual of order=%s to user=%s err=%w", order.ID, login, err)
+		}
+
+		ledgerQuery := prepareAddLedgerTransactionQuery(LedgerEntryAccrual, order.ID, login, accrualLedgerAccount, accrual, time.Now())
+
+		if _, err := tx.Exec(ctx, ledgerQuery.request, ledgerQuery.args...); err != nil {
+			return fmt.Errorf("add ledger entries of order=%s err=%w", order.ID, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// LeaseOrders gives up to limit due orders to the owner for the ttl, the orders leased by other instances
+// aren't returned until their leases expire. The lease is released by UpdateAccrual, RecordOrderCheck and ReleaseOrderLeases
+func (c *Controller) LeaseOrders(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*Order, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareLeaseOrdersQuery(owner, limit, ttl), getAllOrdersTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("lease orders query, err=%w", err)
+	}
+	defer rows.Close()
+
+	orders, err := scanListFromRows[Order](rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan rows, err=%w", err)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// ReleaseOrderLeases returns the orders which weren't checked by the owner, leases of others are left as they are
+func (c *Controller) ReleaseOrderLeases(ctx context.Context, owner string, orderIDs []string) error {
+	execFunc := c.makeExecFunc(ctx, prepareReleaseOrderLeasesQuery(owner, orderIDs), recordOrderCheckTimeout)
+
+	if _, err := doQuery(execFunc); err != nil {
+		return fmt.Errorf("release leases of orders=%v err=%w", orderIDs, err)
 	}
 
-	if _, err := tx.ExecContext(ctx, increaseUserBalanceQuery, order.Accrual, order.User); err != nil {
+	return nil
+}
+
+// RecordOrderCheck counts the attempt, schedules the next check of the unfinished order and releases its lease,
+// the order which was finished, marked stuck or leased by another instance in the meantime is left as it is
+func (c *Controller) RecordOrderCheck(ctx context.Context, check *OrderCheck) error {
+	execFunc := c.makeExecFunc(ctx, prepareRecordOrderCheckQuery(check), recordOrderCheckTimeout)
+
+	if _, err := doQuery(execFunc); err != nil {
+		return fmt.Errorf("record check of order=%s err=%w", check.OrderID, err)
+	}
+
+	return nil
+}
+
+// GetStuckOrders returns the page of unfinished orders which aren't checked anymore, the earliest stuck first
+func (c *Controller) GetStuckOrders(ctx context.Context, limit int, offset int) ([]*Order, error) {
+	queryFunc := c.makeQueryFunc(ctx, prepareGetStuckOrdersQuery(limit, offset), getAllOrdersTimeout)
+
+	rows, err := doQuery(queryFunc)
+	if err != nil {
+		return nil, fmt.Errorf("get stuck orders query, err=%w", err)
+	}
+	defer rows.Close()
+
+	orders, err := scanListFromRows[Order](rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan rows, err=%w", err)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// RetryStuckOrder returns the stuck order to the queue with the reset schedule
+func (c *Controller) RetryStuckOrder(ctx context.Context, orderID string) error {
+	execFunc := c.makeExecFunc(ctx, prepareRetryStuckOrderQuery(orderID), recordOrderCheckTimeout)
+
+	res, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("retry order=%s err=%w", orderID, err)
+	}
+
+	if res.RowsAffected() != 0 {
+		return nil
+	}
+
+	if _, err := c.FindOrder(ctx, orderID); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	return ErrOrderIsNotStuck
 }
 
 // ----------------------------------------------------------------------------------------------
 // -------------------------------------- Internal Methods --------------------------------------
 // ----------------------------------------------------------------------------------------------
 
-func (c *Controller) makeExecFunc(ctx context.Context, query *query) func() (*sql.Result, error) {
-	return func() (r *sql.Result, err error) {
-		res, err := c.db.ExecContext(ctx, query.request, query.args...)
+// makeExecFunc makes the statement which is canceled after the timeout, every trying gets the whole timeout
+func (c *Controller) makeExecFunc(ctx context.Context, query *query, timeout time.Duration) func() (pgconn.CommandTag, error) {
+	return func() (pgconn.CommandTag, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		res, err := c.pool.Exec(ctx, query.request, query.args...)
 		if err != nil {
-			return nil, fmt.Errorf("exec query=%v err=%w", query, err)
+			return res, fmt.Errorf("exec query=%v err=%w", query, err)
 		}
 
-		return &res, nil
+		return res, nil
 	}
 }
 
-func (c *Controller) makeQueryFunc(ctx context.Context, query *query, _ time.Duration) func() (*sql.Rows, error) {
-	return func() (*sql.Rows, error) {
-		rows, err := c.db.QueryContext(ctx, query.request, query.args...)
+// makeQueryFunc makes the query which is canceled after the timeout, the timeout is released when rows are closed
+func (c *Controller) makeQueryFunc(ctx context.Context, query *query, timeout time.Duration) func() (pgx.Rows, error) {
+	return func() (pgx.Rows, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+
+		rows, err := c.pool.Query(ctx, query.request, query.args...)
 		if err != nil {
+			cancel()
 			return nil, fmt.Errorf("query metric, err=%w", err)
 		}
 
-		return rows, nil
+		return &timeoutRows{Rows: rows, cancel: cancel}, nil
 	}
 }
 
+type timeoutRows struct {
+	pgx.Rows
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
 var tryingIntervals = []time.Duration{
 	time.Millisecond * 100,
 	time.Millisecond * 300,
 	time.Millisecond * 500,
 }
 
-func doQuery[T any](queryFunc func() (*T, error)) (*T, error) {
+func doQuery[T any](queryFunc func() (T, error)) (T, error) {
 	var commonErr error
+	var empty T
 	max := len(tryingIntervals)
 
 	for trying := 0; trying <= max; trying++ {
@@ -452,19 +1528,56 @@ func doQuery[T any](queryFunc func() (*T, error)) (*T, error) {
 				continue
 			}
 
-			return nil, commonErr
+			return empty, commonErr
 		}
 
 		return rows, nil
 	}
 
-	return nil, commonErr
+	return empty, commonErr
+}
+
+type rowScanner[T any] interface {
+	*T
+	scan(rows pgx.Rows) error
+}
+
+// scanListFromRows reads all rows into the list, it's used as parse function of doTransactionQuery
+func scanListFromRows[T any, PT rowScanner[T]](rows pgx.Rows) ([]*T, error) {
+	list := make([]*T, 0)
+	for rows.Next() {
+		item := PT(new(T))
+		if err := item.scan(rows); err != nil {
+			return nil, err
+		}
+
+		list = append(list, (*T)(item))
+	}
+
+	return list, nil
+}
+
+// execAffecting returns errIfNone when the query doesn't affect any row
+func (c *Controller) execAffecting(ctx context.Context, query *query, errIfNone error) error {
+	execFunc := c.makeExecFunc(ctx, query, updateUserTimeout)
+
+	res, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("exec query err=%w", err)
+	}
+
+	if res.RowsAffected() == 0 {
+		return errIfNone
+	}
+
+	return nil
 }
 
+// isRetriableError is true for connection errors and for errors which happened before the query was sent
 func isRetriableError(err error) bool {
 	var pgErr *pgconn.PgError
 
-	return errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code)
+	return (errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code)) || pgconn.SafeToRetry(err)
 }
 
 func isNotUniqueError(err error) bool {
@@ -473,19 +1586,20 @@ func isNotUniqueError(err error) bool {
 	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
 }
 
-func doTransactionQuery[T any](ctx context.Context, tx *sql.Tx, query *query, parseFun func(rows *sql.Rows) (T, error)) (T, error) {
+func isForeignKeyError(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation
+}
+
+func doTransactionQuery[T any](ctx context.Context, tx pgx.Tx, query *query, parseFun func(rows pgx.Rows) (T, error)) (T, error) {
 	var result T
 
-	rows, err := tx.QueryContext(ctx, query.request, query.args...)
+	rows, err := tx.Query(ctx, query.request, query.args...)
 	if err != nil {
 		return result, err
 	}
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			zlog.Logger.Errorf("rows close err=%s", err)
-		}
-	}()
+	defer rows.Close()
 
 	result, err = parseFun(rows)
 	if err != nil {
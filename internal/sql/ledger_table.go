@@ -0,0 +1,171 @@
+package sql
+
+import (
+	"fmt"
+	"gophermart/internal/money"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// both entries of the transaction are inserted by one statement, the user's one gets the amount
+	// and the system account gets the opposite amount
+	addLedgerTransactionQuery = `INSERT INTO ledger_entries (transaction_id, account, kind, amount, reference, created_at)
+		SELECT t.id, e.account, $1, e.amount, $2, $3
+		FROM (SELECT nextval('ledger_transaction_seq') AS id) t,
+			(VALUES ($4, $5::numeric), ($6, -$5::numeric)) AS e (account, amount);`
+
+	// the account of the deleted user is renamed, so the transactions stay balanced
+	anonymizeLedgerAccountQuery = `UPDATE ledger_entries SET account = $2 WHERE account = $1;`
+
+	// the running balance is counted over the whole account before the filters are applied
+	getBalanceHistoryQuery = `SELECT kind, reference, amount, balance, created_at FROM (
+			SELECT id, kind, reference, amount, created_at, SUM(amount) OVER (ORDER BY created_at, id) AS balance
+			FROM ledger_entries WHERE account = $1
+		) h
+		WHERE ($2::text IS NULL OR kind = $2)
+			AND ($3::timestamptz IS NULL OR created_at >= $3) AND ($4::timestamptz IS NULL OR created_at < $4)
+		ORDER BY created_at, id LIMIT $5 OFFSET $6;`
+
+	getUnbalancedLedgerTransactionsQuery = `SELECT transaction_id, SUM(amount) FROM ledger_entries
+		GROUP BY transaction_id HAVING SUM(amount) <> 0 ORDER BY transaction_id;`
+
+	getLedgerBalanceMismatchesQuery = `SELECT u.login, COALESCE(u.balance, 0), COALESCE(l.amount, 0) FROM users u
+		LEFT JOIN (SELECT account, SUM(amount) AS amount FROM ledger_entries WHERE account LIKE 'user:%' GROUP BY account) l
+			ON l.account = 'user:' || u.login
+		WHERE COALESCE(u.balance, 0) <> COALESCE(l.amount, 0) ORDER BY u.login;`
+)
+
+type LedgerEntryKind string
+
+const (
+	LedgerEntryAccrual    LedgerEntryKind = "accrual"
+	LedgerEntryWithdrawal LedgerEntryKind = "withdrawal"
+	LedgerEntryAdjustment LedgerEntryKind = "adjustment"
+	// the part of the balance which existed before the ledger
+	LedgerEntryOpening LedgerEntryKind = "opening"
+)
+
+// system accounts are the other side of the user's entries
+const (
+	accrualLedgerAccount     = "system:accrual"
+	withdrawalsLedgerAccount = "system:withdrawals"
+	adjustmentsLedgerAccount = "system:adjustments"
+)
+
+func userLedgerAccount(login string) string {
+	return "user:" + login
+}
+
+// BalanceHistoryEntry is the change of the user's balance and the balance after it,
+// reference is the order for accruals and withdrawals and the adjustment id for adjustments
+type BalanceHistoryEntry struct {
+	Kind      LedgerEntryKind
+	Reference string
+	Amount    money.Amount
+	Balance   money.Amount
+	CreatedAt time.Time
+}
+
+func (e *BalanceHistoryEntry) scan(rows pgx.Rows) error {
+	if err := rows.Scan(&e.Kind, &e.Reference, &e.Amount, &e.Balance, &e.CreatedAt); err != nil {
+		return fmt.Errorf("balance history entry scan err=%w", err)
+	}
+
+	return nil
+}
+
+// BalanceHistoryFilter selects the page of the history, empty kind, zero bounds of the period and zero limit aren't applied
+type BalanceHistoryFilter struct {
+	Kind   LedgerEntryKind
+	Period Period
+	Limit  int
+	Offset int
+}
+
+// UnbalancedLedgerTransaction is the transaction whose entries don't sum to zero
+type UnbalancedLedgerTransaction struct {
+	TransactionID int64        `json:"transaction_id"`
+	Sum           money.Amount `json:"sum"`
+}
+
+func (t *UnbalancedLedgerTransaction) scan(rows pgx.Rows) error {
+	if err := rows.Scan(&t.TransactionID, &t.Sum); err != nil {
+		return fmt.Errorf("unbalanced ledger transaction scan err=%w", err)
+	}
+
+	return nil
+}
+
+// LedgerBalanceMismatch is the user whose cached balance differs from the sum of the ledger entries
+type LedgerBalanceMismatch struct {
+	Login         string       `json:"login"`
+	Balance       money.Amount `json:"balance"`
+	LedgerBalance money.Amount `json:"ledger_balance"`
+}
+
+func (m *LedgerBalanceMismatch) scan(rows pgx.Rows) error {
+	if err := rows.Scan(&m.Login, &m.Balance, &m.LedgerBalance); err != nil {
+		return fmt.Errorf("ledger balance mismatch scan err=%w", err)
+	}
+
+	return nil
+}
+
+func prepareAddLedgerTransactionQuery(
+	kind LedgerEntryKind,
+	reference string,
+	login string,
+	systemAccount string,
+	amount money.Amount,
+	createdAt time.Time,
+) *query {
+	return &query{
+		request: addLedgerTransactionQuery,
+		args:    []interface{}{kind, reference, createdAt, userLedgerAccount(login), amount, systemAccount},
+	}
+}
+
+func prepareGetBalanceHistoryQuery(login string, filter BalanceHistoryFilter) *query {
+	var kind interface{}
+	if filter.Kind != "" {
+		kind = string(filter.Kind)
+	}
+
+	// LIMIT NULL doesn't limit the rows
+	var limit interface{}
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+
+	args := []interface{}{userLedgerAccount(login), kind}
+	args = append(args, filter.Period.args()...)
+	args = append(args, limit, filter.Offset)
+
+	return &query{
+		request: getBalanceHistoryQuery,
+		args:    args,
+	}
+}
+
+func prepareAnonymizeLedgerAccountQuery(login string, anonymizedLogin string) *query {
+	return &query{
+		request: anonymizeLedgerAccountQuery,
+		args:    []interface{}{userLedgerAccount(login), userLedgerAccount(anonymizedLogin)},
+	}
+}
+
+func prepareGetUnbalancedLedgerTransactionsQuery() *query {
+	return &query{
+		request: getUnbalancedLedgerTransactionsQuery,
+		args:    []interface{}{},
+	}
+}
+
+func prepareGetLedgerBalanceMismatchesQuery() *query {
+	return &query{
+		request: getLedgerBalanceMismatchesQuery,
+		args:    []interface{}{},
+	}
+}
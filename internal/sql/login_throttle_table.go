@@ -0,0 +1,71 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	getLoginThrottlesQuery = `SELECT key, failures, last_failure_at, blocked_until FROM login_throttles WHERE key = ANY($1);`
+
+	// failures counter starts from scratch when the previous failure is older than $3
+	addLoginFailureQuery = `INSERT INTO login_throttles (key, failures, last_failure_at, blocked_until) VALUES ($1, 1, $2, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at < $3 THEN 1 ELSE login_throttles.failures + 1 END,
+			last_failure_at = $2
+		RETURNING failures;`
+
+	blockLoginQuery = `UPDATE login_throttles SET blocked_until = GREATEST(blocked_until, $1) WHERE key = $2;`
+
+	deleteLoginThrottleQuery = `DELETE FROM login_throttles WHERE key = $1;`
+
+	// key of the login is "login:<login>", the same as in the auth service
+	deleteUserLoginThrottleQuery = `DELETE FROM login_throttles WHERE key = 'login:' || $1;`
+)
+
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+func (t *LoginThrottle) scan(rows pgx.Rows) error {
+	return rows.Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.BlockedUntil)
+}
+
+func prepareGetLoginThrottlesQuery(keys []string) *query {
+	return &query{
+		request: getLoginThrottlesQuery,
+		args:    []interface{}{keys},
+	}
+}
+
+func prepareAddLoginFailureQuery(key string, now time.Time, resetBefore time.Time) *query {
+	return &query{
+		request: addLoginFailureQuery,
+		args:    []interface{}{key, now, resetBefore},
+	}
+}
+
+func prepareBlockLoginQuery(key string, blockedUntil time.Time) *query {
+	return &query{
+		request: blockLoginQuery,
+		args:    []interface{}{blockedUntil, key},
+	}
+}
+
+func prepareDeleteLoginThrottleQuery(key string) *query {
+	return &query{
+		request: deleteLoginThrottleQuery,
+		args:    []interface{}{key},
+	}
+}
+
+func prepareDeleteUserLoginThrottleQuery(login string) *query {
+	return &query{
+		request: deleteUserLoginThrottleQuery,
+		args:    []interface{}{login},
+	}
+}
@@ -0,0 +1,26 @@
+-- tables of the first release, IF NOT EXISTS adopts databases created before migrations
+CREATE TABLE IF NOT EXISTS users (
+	login 		text					NOT NULL,
+	token 		text					NOT NULL,
+	balance 	double precision		DEFAULT 0,
+	PRIMARY KEY ( login )
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	"id" 			text					NOT NULL,
+	"status"		text					NOT NULL,
+	"accrual"		double precision		NOT NULL,
+	"user"			text					NOT NULL,
+	"upload_time"	text 					NOT NULL,
+	PRIMARY KEY ("id"),
+	CHECK ( "status" IN ( 'NEW', 'PROCESSING', 'INVALID', 'PROCESSED') ),
+	FOREIGN KEY ( "user" ) REFERENCES users ( "login" ) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+	"order"			text				NOT NULL,
+	"user"			text				NOT NULL,
+	"sum"			double precision	NOT NULL,
+	"processed_at"	text				NOT NULL,
+	PRIMARY KEY ( "order" )
+);
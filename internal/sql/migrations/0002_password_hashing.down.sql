@@ -0,0 +1,3 @@
+-- token stays nullable, users registred after hashing haven't it
+DROP INDEX IF EXISTS users_lower_login_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS password_hash;
@@ -0,0 +1,6 @@
+-- users created before password hashing have only legacy token
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash text;
+ALTER TABLE users ALTER COLUMN token DROP NOT NULL;
+
+-- logins are unique regardless of case, the index can't be created if such duplicates were registred before
+CREATE UNIQUE INDEX IF NOT EXISTS users_lower_login_idx ON users ( lower(login) );
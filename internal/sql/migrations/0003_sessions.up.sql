@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id				bigserial		NOT NULL,
+	token			text			NOT NULL,
+	login			text			NOT NULL,
+	created_at		timestamptz		NOT NULL,
+	expires_at		timestamptz		NOT NULL,
+	last_seen		timestamptz		NOT NULL,
+	user_agent		text			NOT NULL DEFAULT '',
+	ip				text			NOT NULL DEFAULT '',
+	PRIMARY KEY ( id ),
+	UNIQUE ( token ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+	token			text			NOT NULL,
+	login			text			NOT NULL,
+	created_at		timestamptz		NOT NULL,
+	expires_at		timestamptz		NOT NULL,
+	used_at			timestamptz,
+	PRIMARY KEY ( token ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS auth_audit;
+DROP TABLE IF EXISTS login_throttles;
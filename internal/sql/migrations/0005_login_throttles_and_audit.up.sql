@@ -0,0 +1,22 @@
+-- key is "login:<login>" or "ip:<ip>", so the same table throttles attempts by login and by client address
+CREATE TABLE IF NOT EXISTS login_throttles (
+	key					text			NOT NULL,
+	failures			integer			NOT NULL,
+	last_failure_at		timestamptz		NOT NULL,
+	blocked_until		timestamptz		NOT NULL,
+	PRIMARY KEY ( key )
+);
+
+-- audit isn't linked with users table, attempts for unknown logins are recorded too
+CREATE TABLE IF NOT EXISTS auth_audit (
+	id				bigserial		NOT NULL,
+	login			text			NOT NULL,
+	event			text			NOT NULL,
+	details			text			NOT NULL DEFAULT '',
+	ip				text			NOT NULL DEFAULT '',
+	user_agent		text			NOT NULL DEFAULT '',
+	created_at		timestamptz		NOT NULL,
+	PRIMARY KEY ( id )
+);
+
+CREATE INDEX IF NOT EXISTS auth_audit_login_idx ON auth_audit ( login, created_at );
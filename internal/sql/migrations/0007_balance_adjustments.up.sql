@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+	id				bigserial			NOT NULL,
+	login			text				NOT NULL,
+	operator		text				NOT NULL,
+	reason			text				NOT NULL,
+	amount			double precision	NOT NULL,
+	balance_before	double precision	NOT NULL,
+	balance_after	double precision	NOT NULL,
+	created_at		timestamptz			NOT NULL,
+	PRIMARY KEY ( id ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS balance_adjustments_login_idx ON balance_adjustments ( login, created_at );
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id				bigserial		NOT NULL,
+	login			text			NOT NULL,
+	name			text			NOT NULL,
+	key_hash		text			NOT NULL,
+	prefix			text			NOT NULL,
+	scopes			text			NOT NULL,
+	created_at		timestamptz		NOT NULL,
+	expires_at		timestamptz		NOT NULL,
+	last_used_at	timestamptz,
+	PRIMARY KEY ( id ),
+	UNIQUE ( key_hash ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
@@ -0,0 +1,28 @@
+-- secret is kept until the user confirms it by the first code, unconfirmed secret doesn't enable second factor
+CREATE TABLE IF NOT EXISTS user_totp (
+	login			text			NOT NULL,
+	secret			text			NOT NULL,
+	confirmed		boolean			NOT NULL DEFAULT false,
+	last_step		bigint			NOT NULL DEFAULT 0,
+	created_at		timestamptz		NOT NULL,
+	PRIMARY KEY ( login ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	login			text			NOT NULL,
+	code_hash		text			NOT NULL,
+	used_at			timestamptz,
+	PRIMARY KEY ( login, code_hash ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
+
+-- challenge is issued after the right password and is exchanged for the session by the second factor
+CREATE TABLE IF NOT EXISTS login_challenges (
+	token			text			NOT NULL,
+	login			text			NOT NULL,
+	attempts		integer			NOT NULL DEFAULT 0,
+	expires_at		timestamptz		NOT NULL,
+	PRIMARY KEY ( token ),
+	FOREIGN KEY ( login ) REFERENCES users ( login ) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+-- withdrawals were created without the foreign key, NOT VALID skips the check of the existing rows
+DO $$ BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'withdrawals_user_fkey') THEN
+		ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_user_fkey
+			FOREIGN KEY ( "user" ) REFERENCES users ( login ) ON DELETE CASCADE NOT VALID;
+	END IF;
+END $$;
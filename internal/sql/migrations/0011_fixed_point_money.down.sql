@@ -0,0 +1,7 @@
+ALTER TABLE balance_adjustments
+	ALTER COLUMN amount TYPE double precision,
+	ALTER COLUMN balance_before TYPE double precision,
+	ALTER COLUMN balance_after TYPE double precision;
+ALTER TABLE withdrawals ALTER COLUMN "sum" TYPE double precision;
+ALTER TABLE orders ALTER COLUMN "accrual" TYPE double precision;
+ALTER TABLE users ALTER COLUMN balance TYPE double precision;
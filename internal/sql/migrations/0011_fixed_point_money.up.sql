@@ -0,0 +1,8 @@
+-- amounts are kept exactly to the hundredth, the legacy float values are rounded half away from zero
+ALTER TABLE users ALTER COLUMN balance TYPE numeric(20, 2) USING round(balance::numeric, 2);
+ALTER TABLE orders ALTER COLUMN "accrual" TYPE numeric(20, 2) USING round("accrual"::numeric, 2);
+ALTER TABLE withdrawals ALTER COLUMN "sum" TYPE numeric(20, 2) USING round("sum"::numeric, 2);
+ALTER TABLE balance_adjustments
+	ALTER COLUMN amount TYPE numeric(20, 2) USING round(amount::numeric, 2),
+	ALTER COLUMN balance_before TYPE numeric(20, 2) USING round(balance_before::numeric, 2),
+	ALTER COLUMN balance_after TYPE numeric(20, 2) USING round(balance_after::numeric, 2);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS withdrawals_user_processed_at_idx;
+DROP INDEX IF EXISTS orders_user_upload_time_idx;
+
+ALTER TABLE withdrawals ALTER COLUMN "processed_at" TYPE text
+	USING to_char("processed_at" AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
+ALTER TABLE orders ALTER COLUMN "upload_time" TYPE text
+	USING to_char("upload_time" AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
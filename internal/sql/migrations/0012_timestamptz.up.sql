@@ -0,0 +1,6 @@
+-- the times were written as RFC3339 text, postgres parses it with the offset
+ALTER TABLE orders ALTER COLUMN "upload_time" TYPE timestamptz USING "upload_time"::timestamptz;
+ALTER TABLE withdrawals ALTER COLUMN "processed_at" TYPE timestamptz USING "processed_at"::timestamptz;
+
+CREATE INDEX IF NOT EXISTS orders_user_upload_time_idx ON orders ( "user", "upload_time" );
+CREATE INDEX IF NOT EXISTS withdrawals_user_processed_at_idx ON withdrawals ( "user", "processed_at" );
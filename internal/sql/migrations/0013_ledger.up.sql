@@ -0,0 +1,79 @@
+-- every change of a balance is a transaction of entries summing to zero,
+-- the user's account is 'user:<login>' and the other side is one of the 'system:' accounts
+CREATE SEQUENCE IF NOT EXISTS ledger_transaction_seq;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id				bigserial		NOT NULL,
+	transaction_id	bigint			NOT NULL,
+	account			text			NOT NULL,
+	kind			text			NOT NULL,
+	amount			numeric(20, 2)	NOT NULL,
+	reference		text			NOT NULL,
+	created_at		timestamptz		NOT NULL,
+	PRIMARY KEY ( id ),
+	CHECK ( kind IN ( 'accrual', 'withdrawal', 'adjustment', 'opening' ) )
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_account_idx ON ledger_entries ( account, created_at );
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_idx ON ledger_entries ( transaction_id );
+
+-- entries are never changed or removed, only the account of a deleted user is anonymized
+CREATE OR REPLACE FUNCTION ledger_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' OR NEW.id <> OLD.id OR NEW.transaction_id <> OLD.transaction_id OR NEW.kind <> OLD.kind
+		OR NEW.amount <> OLD.amount OR NEW.reference <> OLD.reference OR NEW.created_at <> OLD.created_at THEN
+		RAISE EXCEPTION 'ledger entries are append-only';
+	END IF;
+
+	RETURN NEW;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE FUNCTION ledger_entries_append_only();
+
+-- the history is restored from processed orders, withdrawals and adjustments,
+-- the part of the balance which can't be explained by them is recorded as the opening entry,
+-- which is dated right before the first restored entry of the user, so the running balance starts from it
+WITH accruals AS (
+	SELECT nextval('ledger_transaction_seq') AS transaction_id, "user" AS login, "accrual" AS amount,
+		"id" AS reference, "upload_time" AS created_at
+	FROM orders WHERE "status" = 'PROCESSED' AND "accrual" <> 0
+)
+INSERT INTO ledger_entries (transaction_id, account, kind, amount, reference, created_at)
+	SELECT transaction_id, 'user:' || login, 'accrual', amount, reference, created_at FROM accruals
+	UNION ALL
+	SELECT transaction_id, 'system:accrual', 'accrual', -amount, reference, created_at FROM accruals;
+
+WITH spent AS (
+	SELECT nextval('ledger_transaction_seq') AS transaction_id, "user" AS login, "sum" AS amount,
+		"order" AS reference, "processed_at" AS created_at
+	FROM withdrawals
+)
+INSERT INTO ledger_entries (transaction_id, account, kind, amount, reference, created_at)
+	SELECT transaction_id, 'user:' || login, 'withdrawal', -amount, reference, created_at FROM spent
+	UNION ALL
+	SELECT transaction_id, 'system:withdrawals', 'withdrawal', amount, reference, created_at FROM spent;
+
+WITH adjustments AS (
+	SELECT nextval('ledger_transaction_seq') AS transaction_id, login, amount, id::text AS reference, created_at
+	FROM balance_adjustments
+)
+INSERT INTO ledger_entries (transaction_id, account, kind, amount, reference, created_at)
+	SELECT transaction_id, 'user:' || login, 'adjustment', amount, reference, created_at FROM adjustments
+	UNION ALL
+	SELECT transaction_id, 'system:adjustments', 'adjustment', -amount, reference, created_at FROM adjustments;
+
+WITH openings AS (
+	SELECT nextval('ledger_transaction_seq') AS transaction_id, u.login,
+		COALESCE(u.balance, 0) - COALESCE(l.amount, 0) AS amount,
+		COALESCE(l.first_at, now()) - interval '1 microsecond' AS created_at
+	FROM users u
+	LEFT JOIN (SELECT account, SUM(amount) AS amount, MIN(created_at) AS first_at FROM ledger_entries GROUP BY account) l
+		ON l.account = 'user:' || u.login
+	WHERE COALESCE(u.balance, 0) <> COALESCE(l.amount, 0)
+)
+INSERT INTO ledger_entries (transaction_id, account, kind, amount, reference, created_at)
+	SELECT transaction_id, 'user:' || login, 'opening', amount, '', created_at FROM openings
+	UNION ALL
+	SELECT transaction_id, 'system:opening', 'opening', -amount, '', created_at FROM openings;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS withdrawals_user_idempotency_key_idx;
+
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS "idempotency_key";
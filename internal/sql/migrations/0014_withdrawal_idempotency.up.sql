@@ -0,0 +1,5 @@
+-- the key of the client's withdraw request, the retry with the same key returns the saved withdrawal
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS "idempotency_key" text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawals_user_idempotency_key_idx ON withdrawals ( "user", "idempotency_key" )
+	WHERE "idempotency_key" IS NOT NULL;
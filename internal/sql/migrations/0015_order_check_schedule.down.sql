@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS orders_stuck_at_idx;
+DROP INDEX IF EXISTS orders_next_check_at_idx;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS "stuck_at";
+ALTER TABLE orders DROP COLUMN IF EXISTS "last_error";
+ALTER TABLE orders DROP COLUMN IF EXISTS "attempts";
+ALTER TABLE orders DROP COLUMN IF EXISTS "next_check_at";
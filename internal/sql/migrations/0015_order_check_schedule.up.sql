@@ -0,0 +1,11 @@
+-- the accrual system is asked about the order not earlier than next_check_at, the interval grows with attempts
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "next_check_at" timestamptz NOT NULL DEFAULT now();
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "attempts" integer NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "last_error" text NOT NULL DEFAULT '';
+-- the stuck order isn't checked until the operator retries it
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "stuck_at" timestamptz;
+
+CREATE INDEX IF NOT EXISTS orders_next_check_at_idx ON orders ( "next_check_at" )
+	WHERE "status" IN ( 'NEW', 'PROCESSING' ) AND "stuck_at" IS NULL;
+
+CREATE INDEX IF NOT EXISTS orders_stuck_at_idx ON orders ( "stuck_at" ) WHERE "stuck_at" IS NOT NULL;
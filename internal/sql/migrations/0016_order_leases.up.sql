@@ -0,0 +1,3 @@
+-- the instance which checks the order holds the lease, the expired lease of the stopped instance is taken by others
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "lease_owner" text;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS "lease_expires_at" timestamptz;
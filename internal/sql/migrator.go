@@ -0,0 +1,384 @@
+package sql
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"gophermart/internal/zlog"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+const (
+	migrationsDir = "migrations"
+
+	// key of the session level advisory lock, instances wait for each other instead of applying the same migration
+	migrationsLockKey = 7_342_001
+
+	createSchemaMigrationsTableQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version			bigint			NOT NULL,
+		name			text			NOT NULL,
+		applied_at		timestamptz		NOT NULL,
+		PRIMARY KEY ( version )
+	);`
+
+	// the table is resolved by search_path as in createSchemaMigrationsTableQuery
+	hasSchemaMigrationsTableQuery = `SELECT to_regclass('schema_migrations') IS NOT NULL;`
+
+	getAppliedMigrationsQuery = `SELECT version, name, applied_at FROM schema_migrations ORDER BY version;`
+	addAppliedMigrationQuery  = `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3);`
+	deleteAppliedMigration    = `DELETE FROM schema_migrations WHERE version = $1;`
+
+	lockMigrationsQuery   = `SELECT pg_advisory_lock($1);`
+	unlockMigrationsQuery = `SELECT pg_advisory_unlock($1);`
+
+	// migrations may run longer than statement_timeout of the pool, the connection gets it back by reset
+	disableStatementTimeoutQuery = `SET statement_timeout = 0;`
+	resetStatementTimeoutQuery   = `RESET statement_timeout;`
+)
+
+var (
+	ErrBadMigrationName   = errors.New("bad migration file name")
+	ErrBadMigrationsSet   = errors.New("bad migrations set")
+	ErrUnknownMigration   = errors.New("database has migration which is unknown to this build")
+	ErrBadMigrationsSteps = errors.New("bad number of migrations to roll back")
+)
+
+// file name is <version>_<name>.<up|down>.sql
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus's AppliedAt is nil for pending migration
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+func (m *appliedMigration) scan(rows pgx.Rows) error {
+	return rows.Scan(&m.Version, &m.Name, &m.AppliedAt)
+}
+
+// Migrator applies embedded migrations in order of versions, every migration runs in its own transaction
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []*Migration
+}
+
+func NewMigrator(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS, migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// OpenMigrator connects to the database for the migrate command, the connection is closed by Close
+func OpenMigrator(dataSourceName string) (*Migrator, error) {
+	pool, err := openPool(dataSourceName, PoolSettings{MaxConns: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := NewMigrator(pool)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return migrator, nil
+}
+
+func (m *Migrator) Close() error {
+	m.pool.Close()
+
+	return nil
+}
+
+// Up applies all pending migrations and returns them
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	var applied []*Migration
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, done map[int64]*appliedMigration) error {
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			if err := m.apply(ctx, conn, migration); err != nil {
+				return err
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("steps=%d, err=%w", steps, ErrBadMigrationsSteps)
+	}
+
+	var rolledBack []*Migration
+
+	err := m.withLock(ctx, func(conn *pgxpool.Conn, done map[int64]*appliedMigration) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.rollback(ctx, conn, migration); err != nil {
+				return err
+			}
+
+			rolledBack = append(rolledBack, migration)
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status only reads schema_migrations, so it neither waits for running migrations nor changes the schema,
+// the database without the table has nothing applied
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get db connection err=%w", err)
+	}
+	defer conn.Release()
+
+	var exists bool
+	if err := conn.QueryRow(ctx, hasSchemaMigrationsTableQuery).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("check schema_migrations err=%w", err)
+	}
+
+	done := make(map[int64]*appliedMigration)
+	if exists {
+		if done, err = m.appliedMigrations(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if applied, ok := done[migration.Version]; ok {
+			status.AppliedAt = &applied.AppliedAt
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// withLock holds the advisory lock on the dedicated connection while fn runs,
+// fn gets the migrations which are already applied
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgxpool.Conn, done map[int64]*appliedMigration) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("get db connection err=%w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, disableStatementTimeoutQuery); err != nil {
+		return fmt.Errorf("disable statement timeout err=%w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), resetStatementTimeoutQuery); err != nil {
+			zlog.Logger.Errorf("reset statement timeout err=%s", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, lockMigrationsQuery, migrationsLockKey); err != nil {
+		return fmt.Errorf("lock migrations err=%w", err)
+	}
+	defer func() {
+		// the lock is released with the connection anyway, so the error is only logged
+		if _, err := conn.Exec(context.Background(), unlockMigrationsQuery, migrationsLockKey); err != nil {
+			zlog.Logger.Errorf("unlock migrations err=%s", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, createSchemaMigrationsTableQuery); err != nil {
+		return fmt.Errorf("create schema_migrations err=%w", err)
+	}
+
+	done, err := m.appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, done)
+}
+
+func (m *Migrator) appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int64]*appliedMigration, error) {
+	rows, err := conn.Query(ctx, getAppliedMigrationsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("get applied migrations err=%w", err)
+	}
+	defer rows.Close()
+
+	applied, err := scanListFromRows[appliedMigration](rows)
+	if err != nil {
+		return nil, fmt.Errorf("scan applied migrations err=%w", err)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows err=%w", err)
+	}
+
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = struct{}{}
+	}
+
+	done := make(map[int64]*appliedMigration, len(applied))
+	for _, migration := range applied {
+		if _, ok := known[migration.Version]; !ok {
+			return nil, fmt.Errorf("version=%d name=%s, err=%w", migration.Version, migration.Name, ErrUnknownMigration)
+		}
+
+		done[migration.Version] = migration
+	}
+
+	return done, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, migration *Migration) error {
+	err := runInTx(ctx, conn, migration.Up, &query{
+		request: addAppliedMigrationQuery,
+		args:    []interface{}{migration.Version, migration.Name, time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("apply migration version=%d name=%s, err=%w", migration.Version, migration.Name, err)
+	}
+
+	zlog.Logger.Infof("Migration version=%d name=%s is applied", migration.Version, migration.Name)
+
+	return nil
+}
+
+func (m *Migrator) rollback(ctx context.Context, conn *pgxpool.Conn, migration *Migration) error {
+	err := runInTx(ctx, conn, migration.Down, &query{
+		request: deleteAppliedMigration,
+		args:    []interface{}{migration.Version},
+	})
+	if err != nil {
+		return fmt.Errorf("roll back migration version=%d name=%s, err=%w", migration.Version, migration.Name, err)
+	}
+
+	zlog.Logger.Infof("Migration version=%d name=%s is rolled back", migration.Version, migration.Name)
+
+	return nil
+}
+
+// runInTx executes the migration script and updates schema_migrations atomically,
+// the script is executed without arguments so it may contain several statements
+func runInTx(ctx context.Context, conn *pgxpool.Conn, script string, record *query) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return fmt.Errorf("exec script err=%w", err)
+	}
+
+	if _, err := tx.Exec(ctx, record.request, record.args...); err != nil {
+		return fmt.Errorf("exec record query err=%w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// loadMigrations reads pairs of up and down scripts from the directory ordered by version
+func loadMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations dir err=%w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil, fmt.Errorf("file=%s, err=%w", entry.Name(), ErrBadMigrationName)
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("file=%s, err=%w", entry.Name(), ErrBadMigrationName)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration file=%s err=%w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("version=%d has names %s and %s, err=%w", version, migration.Name, match[2], ErrBadMigrationsSet)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("version=%d hasn't up or down script, err=%w", migration.Version, ErrBadMigrationsSet)
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
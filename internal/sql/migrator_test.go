@@ -0,0 +1,84 @@
+package sql
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationsFS, migrationsDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		require.Equal(t, int64(i+1), migration.Version, "versions must go without gaps")
+		require.NotEmpty(t, migration.Up)
+		require.NotEmpty(t, migration.Down)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_second.up.sql":   {Data: []byte("B")},
+		"m/0002_second.down.sql": {Data: []byte("b")},
+		"m/0001_first.up.sql":    {Data: []byte("A")},
+		"m/0001_first.down.sql":  {Data: []byte("a")},
+	}
+
+	migrations, err := loadMigrations(fsys, "m")
+	require.NoError(t, err)
+	require.Equal(t, []*Migration{
+		{Version: 1, Name: "first", Up: "A", Down: "a"},
+		{Version: 2, Name: "second", Up: "B", Down: "b"},
+	}, migrations)
+
+	_, err = loadMigrations(fstest.MapFS{"m/0001_first.up.sql": {Data: []byte("A")}}, "m")
+	require.ErrorIs(t, err, ErrBadMigrationsSet)
+
+	_, err = loadMigrations(fstest.MapFS{"m/first.up.sql": {Data: []byte("A")}}, "m")
+	require.ErrorIs(t, err, ErrBadMigrationName)
+}
+
+// TestStatusDoesNotWaitForMigrations runs against the database from TEST_DATABASE_URI
+func TestStatusDoesNotWaitForMigrations(t *testing.T) {
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI isn't set")
+	}
+
+	ctx := context.Background()
+
+	// the other instance is applying migrations
+	locker, err := openPool(uri, PoolSettings{MaxConns: 1})
+	require.NoError(t, err)
+	defer locker.Close()
+
+	conn, err := locker.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, lockMigrationsQuery, migrationsLockKey)
+	require.NoError(t, err)
+	defer func() {
+		_, err := conn.Exec(ctx, unlockMigrationsQuery, migrationsLockKey)
+		require.NoError(t, err)
+	}()
+
+	migrator, err := OpenMigrator(uri)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, migrator.Close())
+	}()
+
+	statusCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	statuses, err := migrator.Status(statusCtx)
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrator.migrations))
+}
@@ -1,30 +1,63 @@
 package sql
 
 import (
-	"database/sql"
+	"gophermart/internal/money"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 const (
-	createOrdersTableQuery = `CREATE TABLE IF NOT EXISTS orders (
-		"id" 			text					NOT NULL,
-		"status"		text					NOT NULL,
-		"accrual"		double precision		NOT NULL,
-		"user"			text					NOT NULL,
-		"upload_time"	text 					NOT NULL,
-		PRIMARY KEY ("id"),
-		CHECK ( "status" IN ( 'NEW', 'PROCESSING', 'INVALID', 'PROCESSED') ),
-		FOREIGN KEY ( "user" ) REFERENCES users ( "login" ) ON DELETE CASCADE
-	);`
+	createOrderQuery = `INSERT INTO orders ("id", "user", "status", "accrual", "upload_time", "next_check_at")
+		VALUES ($1, $2, 'NEW', 0, $3, $3);`
+
+	orderColumns = `"id", "status", "accrual", "user", "upload_time", "next_check_at", "attempts", "last_error", "stuck_at",
+		"lease_owner", "lease_expires_at"`
+
+	// the order is changed only if its current status may be followed by the new one,
+	// so the repeated update affects nothing and returns no user to credit
+	updateOrderStatusQuery = `UPDATE orders SET status = $1, accrual = $2, "lease_owner" = NULL, "lease_expires_at" = NULL
+		WHERE id = $3 AND status = ANY($4::text[])
+		RETURNING "user";`
+
+	getOrderQuery = `SELECT ` + orderColumns + ` FROM orders WHERE "id" = $1;`
 
-	createOrderQuery = `INSERT INTO orders ("id", "user", "status", "accrual", "upload_time") VALUES ($1, $2, 'NEW', 0, $3);`
+	// bounds of the period are optional, NULL bound isn't applied
+	getAllOrdersQuery = `SELECT ` + orderColumns + ` FROM orders WHERE "user" = $1
+		AND ($2::timestamptz IS NULL OR upload_time >= $2) AND ($3::timestamptz IS NULL OR upload_time < $3)
+		ORDER BY upload_time;`
+	// the orders which are waiting longest are checked first
+	getUnexecutedOrdersQuery = `SELECT ` + orderColumns + ` FROM orders WHERE "status" IN ('NEW', 'PROCESSING')
+		AND "stuck_at" IS NULL AND "next_check_at" <= now()
+		ORDER BY "next_check_at", "id" LIMIT $1;`
 
-	updateOrderAccrualQuery = `UPDATE orders SET status = $1, accrual = $2 WHERE id = $3;`
+	// rows locked by other instances are skipped, so concurrent instances lease different orders
+	leaseOrdersQuery = `UPDATE orders SET "lease_owner" = $1, "lease_expires_at" = now() + $3 * interval '1 second'
+		WHERE "id" IN (
+			SELECT "id" FROM orders WHERE "status" IN ('NEW', 'PROCESSING')
+				AND "stuck_at" IS NULL AND "next_check_at" <= now()
+				AND ("lease_expires_at" IS NULL OR "lease_expires_at" <= now())
+			ORDER BY "next_check_at", "id" LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + orderColumns + `;`
 
-	getOrderQuery = `SELECT * FROM orders WHERE "id" = $1;`
+	releaseOrderLeasesQuery = `UPDATE orders SET "lease_owner" = NULL, "lease_expires_at" = NULL
+		WHERE "id" = ANY($2::text[]) AND "lease_owner" = $1;`
 
-	getAllOrdersQuery        = `SELECT * FROM orders WHERE "user" = $1 ORDER BY upload_time;`
-	getUnexecutedOrdersQuery = `SELECT * FROM orders WHERE "status" IN ('NEW', 'PROCESSING');`
+	// the finished or stuck order isn't rescheduled, the check could be made before it was changed,
+	// the order leased by another instance after the lease expired is left to it
+	recordOrderCheckQuery = `UPDATE orders SET "attempts" = "attempts" + 1, "next_check_at" = $2, "last_error" = $3, "stuck_at" = $4,
+		"lease_owner" = NULL, "lease_expires_at" = NULL
+		WHERE "id" = $1 AND "status" IN ('NEW', 'PROCESSING') AND "stuck_at" IS NULL
+		AND ("lease_owner" IS NULL OR "lease_owner" = $5);`
+
+	getStuckOrdersQuery = `SELECT ` + orderColumns + ` FROM orders WHERE "stuck_at" IS NOT NULL
+		AND "status" IN ('NEW', 'PROCESSING') ORDER BY "stuck_at", "id" LIMIT $1 OFFSET $2;`
+
+	retryStuckOrderQuery = `UPDATE orders SET "stuck_at" = NULL, "attempts" = 0, "last_error" = '', "next_check_at" = now(),
+		"lease_owner" = NULL, "lease_expires_at" = NULL
+		WHERE "id" = $1 AND "stuck_at" IS NOT NULL AND "status" IN ('NEW', 'PROCESSING');`
 )
 
 type OrderStatus string
@@ -36,16 +69,71 @@ const (
 	OrderStatusProcessed  OrderStatus = "PROCESSED"
 )
 
+// orderTransitions lists the statuses every status may follow, PROCESSED and INVALID are final.
+// The accrual system may answer PROCESSED or INVALID for the NEW order, so PROCESSING may be skipped
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusProcessing: {OrderStatusNew},
+	OrderStatusProcessed:  {OrderStatusNew, OrderStatusProcessing},
+	OrderStatusInvalid:    {OrderStatusNew, OrderStatusProcessing},
+}
+
+// CanBecome reports whether the order in this status may be moved to the next one
+func (s OrderStatus) CanBecome(next OrderStatus) bool {
+	for _, previous := range orderTransitions[next] {
+		if previous == s {
+			return true
+		}
+	}
+
+	return false
+}
+
 type Order struct {
-	ID           string      `json:"id"`
-	User         string      `json:"user"`
-	Status       OrderStatus `json:"status"`
-	Accrual      float64     `json:"accrual,omitempty"`
-	UpdaloadTime string      `json:"uploaded_at"`
+	ID         string       `json:"id"`
+	User       string       `json:"user"`
+	Status     OrderStatus  `json:"status"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UploadedAt time.Time    `json:"uploaded_at"`
+
+	// the schedule of checks in the accrual system isn't shown to the user
+	NextCheckAt time.Time `json:"-"`
+	Attempts    int       `json:"-"`
+	LastError   string    `json:"-"`
+	// StuckAt is set when the order is given up, the operator decides whether to check it again
+	StuckAt *time.Time `json:"-"`
+	// the instance which is checking the order, the lease isn't valid after it expires
+	LeaseOwner     *string    `json:"-"`
+	LeaseExpiresAt *time.Time `json:"-"`
 }
 
-func (o *Order) scan(rows *sql.Rows) error {
-	return rows.Scan(&o.ID, &o.Status, &o.Accrual, &o.User, &o.UpdaloadTime)
+func (o *Order) scan(rows pgx.Rows) error {
+	return rows.Scan(&o.ID, &o.Status, &o.Accrual, &o.User, &o.UploadedAt, &o.NextCheckAt, &o.Attempts, &o.LastError, &o.StuckAt,
+		&o.LeaseOwner, &o.LeaseExpiresAt)
+}
+
+// OrderCheck is the result of the check which didn't finish the order
+type OrderCheck struct {
+	OrderID     string
+	NextCheckAt time.Time
+	// LastError is empty if the accrual system answered that the order is still processed
+	LastError string
+	// Stuck stops checks of the order until the operator retries it
+	Stuck bool
+	// LeaseOwner is the instance which made the check, its lease is released
+	LeaseOwner string
+}
+
+func scanOrderFromRows(rows pgx.Rows) (*Order, error) {
+	if err := nextRow(rows); err != nil {
+		return nil, err
+	}
+
+	order := &Order{}
+	if err := order.scan(rows); err != nil {
+		return nil, err
+	}
+
+	return order, nil
 }
 
 func prepareCreateOrderQuery(orderID string, user string) *query {
@@ -54,7 +142,7 @@ func prepareCreateOrderQuery(orderID string, user string) *query {
 		args: []interface{}{
 			orderID,
 			user,
-			time.Now().Format(time.RFC3339),
+			time.Now(),
 		},
 	}
 }
@@ -68,18 +156,69 @@ func prepareGetOrderQuery(orderID string) *query {
 	}
 }
 
-func prepareGetAllOrdersQuery(user string) *query {
+func prepareGetAllOrdersQuery(user string, period Period) *query {
 	return &query{
 		request: getAllOrdersQuery,
-		args: []interface{}{
-			user,
-		},
+		args:    append([]interface{}{user}, period.args()...),
 	}
 }
 
-func prepareGetUnexecutedOrdersQuery() *query {
+func prepareGetUnexecutedOrdersQuery(limit int) *query {
 	return &query{
 		request: getUnexecutedOrdersQuery,
-		args:    []interface{}{},
+		args:    []interface{}{limit},
+	}
+}
+
+func prepareRecordOrderCheckQuery(check *OrderCheck) *query {
+	var stuckAt *time.Time
+	if check.Stuck {
+		now := time.Now()
+		stuckAt = &now
+	}
+
+	return &query{
+		request: recordOrderCheckQuery,
+		args:    []interface{}{check.OrderID, check.NextCheckAt, check.LastError, stuckAt, check.LeaseOwner},
+	}
+}
+
+func prepareLeaseOrdersQuery(owner string, limit int, ttl time.Duration) *query {
+	return &query{
+		request: leaseOrdersQuery,
+		args:    []interface{}{owner, limit, ttl.Seconds()},
+	}
+}
+
+func prepareReleaseOrderLeasesQuery(owner string, orderIDs []string) *query {
+	return &query{
+		request: releaseOrderLeasesQuery,
+		args:    []interface{}{owner, orderIDs},
+	}
+}
+
+func prepareGetStuckOrdersQuery(limit int, offset int) *query {
+	return &query{
+		request: getStuckOrdersQuery,
+		args:    []interface{}{limit, offset},
+	}
+}
+
+func prepareRetryStuckOrderQuery(orderID string) *query {
+	return &query{
+		request: retryStuckOrderQuery,
+		args:    []interface{}{orderID},
+	}
+}
+
+func prepareUpdateOrderStatusQuery(orderID string, status OrderStatus, accrual money.Amount) *query {
+	previous := make([]string, 0, len(orderTransitions[status]))
+	for _, s := range orderTransitions[status] {
+		previous = append(previous, string(s))
+	}
+
+	return &query{
+		request: updateOrderStatusQuery,
+		args:    []interface{}{status, accrual, orderID, previous},
 	}
 }
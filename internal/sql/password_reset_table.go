@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	createPasswordResetTokenQuery = `INSERT INTO password_reset_tokens (token, login, created_at, expires_at) VALUES ($1, $2, $3, $4);`
+
+	// token is consumed only once, the concurrent request gets empty result
+	usePasswordResetTokenQuery = `UPDATE password_reset_tokens SET used_at = $1
+		WHERE token = $2 AND used_at IS NULL AND expires_at > $1 RETURNING login;`
+
+	deleteUnusedPasswordResetTokensQuery = `DELETE FROM password_reset_tokens WHERE login = $1 AND used_at IS NULL;`
+
+	deleteUserSessionsQuery = `DELETE FROM sessions WHERE login = $1;`
+)
+
+// PasswordResetToken's token is a digest of the value sent to user
+type PasswordResetToken struct {
+	Token     string
+	Login     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func scanLoginFromRows(rows pgx.Rows) (string, error) {
+	if err := nextRow(rows); err != nil {
+		return "", err
+	}
+
+	var login string
+	if err := rows.Scan(&login); err != nil {
+		return "", err
+	}
+
+	return login, nil
+}
+
+func prepareCreatePasswordResetTokenQuery(token *PasswordResetToken) *query {
+	return &query{
+		request: createPasswordResetTokenQuery,
+		args:    []interface{}{token.Token, token.Login, token.CreatedAt, token.ExpiresAt},
+	}
+}
+
+func prepareUsePasswordResetTokenQuery(token string, now time.Time) *query {
+	return &query{
+		request: usePasswordResetTokenQuery,
+		args:    []interface{}{now, token},
+	}
+}
+
+func prepareDeleteUnusedPasswordResetTokensQuery(login string) *query {
+	return &query{
+		request: deleteUnusedPasswordResetTokensQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareDeleteUserSessionsQuery(login string) *query {
+	return &query{
+		request: deleteUserSessionsQuery,
+		args:    []interface{}{login},
+	}
+}
@@ -0,0 +1,21 @@
+package sql
+
+import "time"
+
+// Period limits the listing by time, From is inclusive and To is exclusive, zero bound isn't applied
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+func (p Period) args() []interface{} {
+	return []interface{}{nullTime(p.From), nullTime(p.To)}
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
@@ -0,0 +1,120 @@
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const pingTimeout = time.Second * 5
+
+var ErrBadPoolSettings = errors.New("bad connection pool settings")
+
+// PoolSettings tunes the connection pool, zero value keeps the default of pgxpool
+type PoolSettings struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// StatementTimeout is set as statement_timeout of every connection, zero doesn't limit statements
+	StatementTimeout time.Duration
+}
+
+func openPool(dataSourceName string, settings PoolSettings) (*pgxpool.Pool, error) {
+	config, err := makePoolConfig(dataSourceName, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("new pool err=%w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("db connection err=%w", err)
+	}
+
+	return pool, nil
+}
+
+func makePoolConfig(dataSourceName string, settings PoolSettings) (*pgxpool.Config, error) {
+	if settings.MaxConns < 0 || settings.MinConns < 0 || settings.MaxConnIdleTime < 0 ||
+		settings.HealthCheckPeriod < 0 || settings.StatementTimeout < 0 {
+		return nil, fmt.Errorf("settings=%+v, err=%w", settings, ErrBadPoolSettings)
+	}
+
+	config, err := pgxpool.ParseConfig(dataSourceName)
+	if err != nil {
+		// the uri isn't logged because it may contain the password
+		return nil, fmt.Errorf("parse db uri err=%w", err)
+	}
+
+	if settings.MaxConns > 0 {
+		config.MaxConns = settings.MaxConns
+	}
+
+	if settings.MinConns > 0 {
+		config.MinConns = settings.MinConns
+	}
+
+	if config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("min conns=%d max conns=%d, err=%w", config.MinConns, config.MaxConns, ErrBadPoolSettings)
+	}
+
+	if settings.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = settings.MaxConnIdleTime
+	}
+
+	if settings.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = settings.HealthCheckPeriod
+	}
+
+	if settings.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(settings.StatementTimeout.Milliseconds(), 10)
+	}
+
+	return config, nil
+}
+
+// PoolStats is the snapshot of the connection pool, counters are cumulative since the start
+type PoolStats struct {
+	MaxConns          int32 `json:"max_conns"`
+	TotalConns        int32 `json:"total_conns"`
+	IdleConns         int32 `json:"idle_conns"`
+	AcquiredConns     int32 `json:"acquired_conns"`
+	ConstructingConns int32 `json:"constructing_conns"`
+
+	AcquireCount         int64 `json:"acquire_count"`
+	AcquireDurationMs    int64 `json:"acquire_duration_ms"`
+	EmptyAcquireCount    int64 `json:"empty_acquire_count"`
+	CanceledAcquireCount int64 `json:"canceled_acquire_count"`
+	NewConnsCount        int64 `json:"new_conns_count"`
+	MaxLifetimeDestroyed int64 `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyed     int64 `json:"max_idle_destroy_count"`
+}
+
+func makePoolStats(stat *pgxpool.Stat) *PoolStats {
+	return &PoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		AcquireDurationMs:    stat.AcquireDuration().Milliseconds(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		NewConnsCount:        stat.NewConnsCount(),
+		MaxLifetimeDestroyed: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyed:     stat.MaxIdleDestroyCount(),
+	}
+}
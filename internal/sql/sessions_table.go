@@ -0,0 +1,117 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	createSessionQuery = `INSERT INTO sessions (token, login, created_at, expires_at, last_seen, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $3, $5, $6) RETURNING id;`
+
+	getSessionByIDQuery    = `SELECT ` + sessionColumns + ` FROM ` + sessionsWithUsers + ` WHERE s.id = $1;`
+	getSessionQuery        = `SELECT ` + sessionColumns + ` FROM ` + sessionsWithUsers + ` WHERE s.token = $1;`
+	getUserSessionsQuery   = `SELECT ` + sessionColumns + ` FROM ` + sessionsWithUsers + ` WHERE s.login = $1 ORDER BY s.created_at, s.id;`
+	touchSessionQuery      = `UPDATE sessions SET last_seen = $1 WHERE token = $2;`
+	deleteSessionQuery     = `DELETE FROM sessions WHERE token = $1;`
+	takeSessionQuery       = `DELETE FROM sessions s USING users u WHERE u.login = s.login AND s.token = $1 RETURNING ` + sessionColumns + `;`
+	deleteSessionByIDQuery = `DELETE FROM sessions WHERE id = $1 AND login = $2;`
+
+	deleteOtherSessionsQuery = `DELETE FROM sessions WHERE login = $1 AND id <> $2;`
+
+	// session is selected together with the role and the state of its user, so they are known without extra query
+	sessionColumns    = `s.id, s.token, s.login, s.created_at, s.expires_at, s.last_seen, s.user_agent, s.ip, u.role, u.blocked`
+	sessionsWithUsers = `sessions s JOIN users u ON u.login = s.login`
+)
+
+// Session's token is a digest of the value stored in the client's cookie
+type Session struct {
+	ID        int64     `json:"id"`
+	Token     string    `json:"-"`
+	Login     string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	Role      string    `json:"-"`
+	Blocked   bool      `json:"-"`
+
+	// is filled by the auth service for the session of the request
+	Current bool `json:"current"`
+}
+
+func (s *Session) scan(rows pgx.Rows) error {
+	return rows.Scan(&s.ID, &s.Token, &s.Login, &s.CreatedAt, &s.ExpiresAt, &s.LastSeen, &s.UserAgent, &s.IP, &s.Role, &s.Blocked)
+}
+
+func prepareCreateSessionQuery(session *Session) *query {
+	return &query{
+		request: createSessionQuery,
+		args: []interface{}{
+			session.Token,
+			session.Login,
+			session.CreatedAt,
+			session.ExpiresAt,
+			session.UserAgent,
+			session.IP,
+		},
+	}
+}
+
+func prepareGetSessionQuery(token string) *query {
+	return &query{
+		request: getSessionQuery,
+		args:    []interface{}{token},
+	}
+}
+
+func prepareGetSessionByIDQuery(id int64) *query {
+	return &query{
+		request: getSessionByIDQuery,
+		args:    []interface{}{id},
+	}
+}
+
+func prepareGetUserSessionsQuery(login string) *query {
+	return &query{
+		request: getUserSessionsQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareTouchSessionQuery(token string, lastSeen time.Time) *query {
+	return &query{
+		request: touchSessionQuery,
+		args:    []interface{}{lastSeen, token},
+	}
+}
+
+func prepareDeleteSessionQuery(token string) *query {
+	return &query{
+		request: deleteSessionQuery,
+		args:    []interface{}{token},
+	}
+}
+
+func prepareTakeSessionQuery(token string) *query {
+	return &query{
+		request: takeSessionQuery,
+		args:    []interface{}{token},
+	}
+}
+
+func prepareDeleteSessionByIDQuery(login string, id int64) *query {
+	return &query{
+		request: deleteSessionByIDQuery,
+		args:    []interface{}{id, login},
+	}
+}
+
+func prepareDeleteOtherSessionsQuery(login string, currentID int64) *query {
+	return &query{
+		request: deleteOtherSessionsQuery,
+		args:    []interface{}{login, currentID},
+	}
+}
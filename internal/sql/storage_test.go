@@ -0,0 +1,35 @@
+package sql_test
+
+import (
+	"context"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"gophermart/internal/storage/storagetest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestConformance runs against the database from TEST_DATABASE_URI, the rows made by the suite are left there
+func TestConformance(t *testing.T) {
+	uri := os.Getenv("TEST_DATABASE_URI")
+	if uri == "" {
+		t.Skip("TEST_DATABASE_URI isn't set")
+	}
+
+	ctrl, err := sql.StartNewController(uri, sql.PoolSettings{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, ctrl.Stop())
+	})
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return ctrl
+	})
+
+	// the suite moves balances only through the controller, so they must match the ledger
+	result, err := ctrl.ReconcileLedger(context.Background())
+	require.NoError(t, err)
+	require.True(t, result.IsConsistent(), "%+v", result)
+}
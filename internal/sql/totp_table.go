@@ -0,0 +1,162 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// confirmed secret can't be replaced, the user has to disable the second factor first
+	saveUserTOTPQuery = `INSERT INTO user_totp (login, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (login) DO UPDATE SET secret = $2, last_step = 0, created_at = $3 WHERE user_totp.confirmed = false;`
+
+	hasConfirmedTOTPQuery = `SELECT EXISTS (SELECT 1 FROM user_totp WHERE login = $1 AND confirmed);`
+
+	getUserTOTPQuery     = `SELECT login, secret, confirmed, last_step FROM user_totp WHERE login = $1;`
+	confirmUserTOTPQuery = `UPDATE user_totp SET confirmed = true, last_step = $1 WHERE login = $2 AND confirmed = false;`
+	deleteUserTOTPQuery  = `DELETE FROM user_totp WHERE login = $1;`
+
+	// the step of the code grows only, so the same code can't be used twice even by concurrent requests
+	useTOTPStepQuery = `UPDATE user_totp SET last_step = $1 WHERE login = $2 AND confirmed = true AND last_step < $1;`
+
+	addRecoveryCodeQuery     = `INSERT INTO totp_recovery_codes (login, code_hash) VALUES ($1, $2);`
+	useRecoveryCodeQuery     = `UPDATE totp_recovery_codes SET used_at = $1 WHERE login = $2 AND code_hash = $3 AND used_at IS NULL;`
+	deleteRecoveryCodesQuery = `DELETE FROM totp_recovery_codes WHERE login = $1;`
+
+	createLoginChallengeQuery = `INSERT INTO login_challenges (token, login, expires_at) VALUES ($1, $2, $3);`
+	deleteLoginChallengeQuery = `DELETE FROM login_challenges WHERE token = $1;`
+
+	// every check of the challenge is counted, expired challenge gets empty result
+	useLoginChallengeQuery = `UPDATE login_challenges SET attempts = attempts + 1
+		WHERE token = $1 AND expires_at > $2 RETURNING login, attempts;`
+)
+
+type UserTOTP struct {
+	Login     string
+	Secret    string
+	Confirmed bool
+	LastStep  int64
+}
+
+func (t *UserTOTP) scan(rows pgx.Rows) error {
+	return rows.Scan(&t.Login, &t.Secret, &t.Confirmed, &t.LastStep)
+}
+
+// LoginChallenge's token is a digest of the value returned to the client
+type LoginChallenge struct {
+	Token     string
+	Login     string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+func scanLoginChallengeFromRows(rows pgx.Rows) (*LoginChallenge, error) {
+	if err := nextRow(rows); err != nil {
+		return nil, err
+	}
+
+	challenge := &LoginChallenge{}
+	if err := rows.Scan(&challenge.Login, &challenge.Attempts); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+func prepareSaveUserTOTPQuery(login string, secret string, createdAt time.Time) *query {
+	return &query{
+		request: saveUserTOTPQuery,
+		args:    []interface{}{login, secret, createdAt},
+	}
+}
+
+func prepareGetUserTOTPQuery(login string) *query {
+	return &query{
+		request: getUserTOTPQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareConfirmUserTOTPQuery(login string, step int64) *query {
+	return &query{
+		request: confirmUserTOTPQuery,
+		args:    []interface{}{step, login},
+	}
+}
+
+func prepareDeleteUserTOTPQuery(login string) *query {
+	return &query{
+		request: deleteUserTOTPQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareUseTOTPStepQuery(login string, step int64) *query {
+	return &query{
+		request: useTOTPStepQuery,
+		args:    []interface{}{step, login},
+	}
+}
+
+func prepareAddRecoveryCodeQuery(login string, codeHash string) *query {
+	return &query{
+		request: addRecoveryCodeQuery,
+		args:    []interface{}{login, codeHash},
+	}
+}
+
+func prepareUseRecoveryCodeQuery(login string, codeHash string, now time.Time) *query {
+	return &query{
+		request: useRecoveryCodeQuery,
+		args:    []interface{}{now, login, codeHash},
+	}
+}
+
+func prepareDeleteRecoveryCodesQuery(login string) *query {
+	return &query{
+		request: deleteRecoveryCodesQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareCreateLoginChallengeQuery(challenge *LoginChallenge) *query {
+	return &query{
+		request: createLoginChallengeQuery,
+		args:    []interface{}{challenge.Token, challenge.Login, challenge.ExpiresAt},
+	}
+}
+
+func prepareUseLoginChallengeQuery(token string, now time.Time) *query {
+	return &query{
+		request: useLoginChallengeQuery,
+		args:    []interface{}{token, now},
+	}
+}
+
+func prepareDeleteLoginChallengeQuery(token string) *query {
+	return &query{
+		request: deleteLoginChallengeQuery,
+		args:    []interface{}{token},
+	}
+}
+
+func prepareHasConfirmedTOTPQuery(login string) *query {
+	return &query{
+		request: hasConfirmedTOTPQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func scanBoolFromRows(rows pgx.Rows) (bool, error) {
+	if err := nextRow(rows); err != nil {
+		return false, err
+	}
+
+	var value bool
+	if err := rows.Scan(&value); err != nil {
+		return false, err
+	}
+
+	return value, nil
+}
@@ -1,40 +1,69 @@
 package sql
 
 import (
-	"database/sql"
 	"errors"
+	"gophermart/internal/money"
+
+	"github.com/jackc/pgx/v5"
 )
 
 const (
-	createUsersTableQuery = `CREATE TABLE IF NOT EXISTS users (
-		login 		text					NOT NULL,
-		token 		text					NOT NULL,
-		balance 	double precision		DEFAULT 0,
-		PRIMARY KEY ( login )
-	);`
+	createUserQuery = `INSERT INTO users (login, password_hash) VALUES ($1, $2);`
+
+	getUser             = `SELECT ` + userColumns + ` FROM users WHERE login = $1;`
+	getUserByLowerLogin = `SELECT ` + userColumns + ` FROM users WHERE lower(login) = lower($1);`
+
+	// the row of the user is locked until the end of the transaction, so concurrent changes of the balance
+	// wait for each other and the checked balance can't become stale
+	getUserForUpdateQuery = `SELECT ` + userColumns + ` FROM users WHERE login = $1 FOR UPDATE;`
+
+	userColumns = `login, COALESCE(password_hash, ''), COALESCE(token, ''), balance, role, blocked`
+
+	// substring search ignoring case, position() is used instead of LIKE so the input needn't escaping
+	searchUsersQuery = `SELECT login, role, blocked, balance FROM users
+		WHERE position(lower($1) in lower(login)) > 0 ORDER BY login LIMIT $2 OFFSET $3;`
+
+	updateUserRoleQuery    = `UPDATE users SET role = $1 WHERE login = $2;`
+	updateUserBlockedQuery = `UPDATE users SET blocked = $1 WHERE login = $2;`
 
-	createUserQuery = `INSERT INTO users (login, token) VALUES ($1, $2);`
+	updateUserPasswordHashQuery = `UPDATE users SET password_hash = $1, token = NULL WHERE login = $2;`
 
-	getUser        = `SELECT * FROM users WHERE login = $1;`
-	getUserByToken = `SELECT * FROM users WHERE token = $1;`
+	// rows of the other tables are removed by the foreign keys
+	deleteUserQuery = `DELETE FROM users WHERE login = $1;`
 
 	increaseUserBalanceQuery = `UPDATE users SET balance = balance + $1 WHERE login = $2;`
 	decreaseUserBalanceQuery = `UPDATE users SET balance = balance - $1 WHERE login = $2;`
 )
 
 type User struct {
-	Login     string
-	AuthToken string
-	Balance   float64
+	Login        string
+	PasswordHash string
+	// credential of users registred before password hashing, empty after migration
+	LegacyToken string
+	Balance     money.Amount
+	Role        string
+	Blocked     bool
 }
 
-func (u *User) scan(rows *sql.Rows) error {
-	return rows.Scan(&u.Login, &u.AuthToken, &u.Balance)
+func (u *User) scan(rows pgx.Rows) error {
+	return rows.Scan(&u.Login, &u.PasswordHash, &u.LegacyToken, &u.Balance, &u.Role, &u.Blocked)
 }
 
-func scanUserFromRows(rows *sql.Rows) (*User, error) {
-	if !rows.Next() {
-		return nil, ErrEmptyScannerResult
+// UserSummary is the user's info shown to operators
+type UserSummary struct {
+	Login   string       `json:"login"`
+	Role    string       `json:"role"`
+	Blocked bool         `json:"blocked"`
+	Balance money.Amount `json:"balance"`
+}
+
+func (u *UserSummary) scan(rows pgx.Rows) error {
+	return rows.Scan(&u.Login, &u.Role, &u.Blocked, &u.Balance)
+}
+
+func scanUserFromRows(rows pgx.Rows) (*User, error) {
+	if err := nextRow(rows); err != nil {
+		return nil, err
 	}
 
 	user := &User{}
@@ -47,10 +76,24 @@ func scanUserFromRows(rows *sql.Rows) (*User, error) {
 
 var ErrEmptyScannerResult = errors.New("sql obj scanner has empty result")
 
-func prepareCreateUserQuery(login, token string) *query {
+// nextRow moves to the first row, pgx reports the error of the query only when rows are read,
+// so it's returned instead of ErrEmptyScannerResult
+func nextRow(rows pgx.Rows) error {
+	if rows.Next() {
+		return nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return ErrEmptyScannerResult
+}
+
+func prepareCreateUserQuery(login, passwordHash string) *query {
 	return &query{
 		request: createUserQuery,
-		args:    []interface{}{login, token},
+		args:    []interface{}{login, passwordHash},
 	}
 }
 
@@ -61,16 +104,65 @@ func prepareGetUserQuery(login string) *query {
 	}
 }
 
-func prepareGetUserByTokenQuery(token string) *query {
+func prepareGetUserByLowerLoginQuery(login string) *query {
+	return &query{
+		request: getUserByLowerLogin,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareUpdateUserPasswordHashQuery(login, passwordHash string) *query {
+	return &query{
+		request: updateUserPasswordHashQuery,
+		args:    []interface{}{passwordHash, login},
+	}
+}
+
+func prepareUpdateUserRoleQuery(login, role string) *query {
+	return &query{
+		request: updateUserRoleQuery,
+		args:    []interface{}{role, login},
+	}
+}
+
+func prepareUpdateUserBlockedQuery(login string, blocked bool) *query {
+	return &query{
+		request: updateUserBlockedQuery,
+		args:    []interface{}{blocked, login},
+	}
+}
+
+func prepareSearchUsersQuery(login string, limit int, offset int) *query {
+	return &query{
+		request: searchUsersQuery,
+		args:    []interface{}{login, limit, offset},
+	}
+}
+
+func prepareGetUserForUpdateQuery(login string) *query {
+	return &query{
+		request: getUserForUpdateQuery,
+		args:    []interface{}{login},
+	}
+}
+
+func prepareIncreaseUserBalanceQuery(login string, amount money.Amount) *query {
 	return &query{
-		request: getUserByToken,
-		args:    []interface{}{token},
+		request: increaseUserBalanceQuery,
+		args:    []interface{}{amount, login},
 	}
 }
 
-func prepareDecreaseUserBalanceQuery(login string, balance float64) *query {
+func prepareDecreaseUserBalanceQuery(login string, balance money.Amount) *query {
 	return &query{
 		request: decreaseUserBalanceQuery,
 		args:    []interface{}{balance, login},
 	}
 }
+
+func prepareDeleteUserQuery(login string) *query {
+	return &query{
+		request: deleteUserQuery,
+		args:    []interface{}{login},
+	}
+}
@@ -1,32 +1,33 @@
 package sql
 
 import (
-	"database/sql"
 	"fmt"
+	"gophermart/internal/money"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 const (
-	createWithdrawalsTableQuery = `CREATE TABLE IF NOT EXISTS withdrawals (
-		"order"			text				NOT NULL,
-		"user"			text				NOT NULL,
-		"sum"			double precision	NOT NULL,
-		"processed_at"	text				NOT NULL,
-		PRIMARY KEY ( "order" )
-	);`
-
-	addWithdrawals             = `INSERT INTO withdrawals ("order", "user", "sum", "processed_at") VALUES ($1, $2, $3, $4);`
+	addWithdrawals = `INSERT INTO withdrawals ("order", "user", "sum", "processed_at", "idempotency_key")
+		VALUES ($1, $2, $3, $4, $5);`
+	getWithdrawalByIdempotencyKey = `SELECT "order", "sum", "processed_at" FROM withdrawals
+		WHERE "user" = $1 AND "idempotency_key" = $2;`
 	getUserWithdrawalsTotalSum = `SELECT SUM ("sum") FROM withdrawals WHERE "user" = $1;`
-	getUserWithdrawals         = `SELECT "order", "sum", "processed_at" FROM withdrawals WHERE "user" = $1 ORDER BY "processed_at";`
+	getUserWithdrawals         = `SELECT "order", "sum", "processed_at" FROM withdrawals WHERE "user" = $1
+		AND ($2::timestamptz IS NULL OR "processed_at" >= $2) AND ($3::timestamptz IS NULL OR "processed_at" < $3)
+		ORDER BY "processed_at";`
+
+	deleteUserWithdrawalsQuery = `DELETE FROM withdrawals WHERE "user" = $1;`
 )
 
 type UserWithdrawRecord struct {
-	OrderID     string  `json:"order"`
-	Accrual     float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	OrderID     string       `json:"order"`
+	Accrual     money.Amount `json:"sum"`
+	ProcessedAt time.Time    `json:"processed_at"`
 }
 
-func (r *UserWithdrawRecord) scan(rows *sql.Rows) error {
+func (r *UserWithdrawRecord) scan(rows pgx.Rows) error {
 	err := rows.Scan(&r.OrderID, &r.Accrual, &r.ProcessedAt)
 	if err != nil {
 		return fmt.Errorf("withdraw record scan err=%w", err)
@@ -35,40 +36,59 @@ func (r *UserWithdrawRecord) scan(rows *sql.Rows) error {
 	return nil
 }
 
-func scanWithdrawalsSumFromRows(rows *sql.Rows) (float64, error) {
-	if !rows.Next() {
-		return 0, ErrEmptyScannerResult
+func scanWithdrawRecordFromRows(rows pgx.Rows) (*UserWithdrawRecord, error) {
+	if err := nextRow(rows); err != nil {
+		return nil, err
 	}
 
-	var result interface{}
-	if err := rows.Scan(&result); err != nil {
-		return 0, fmt.Errorf("scan withdraws sum err=%w", err)
+	record := &UserWithdrawRecord{}
+	if err := record.scan(rows); err != nil {
+		return nil, err
 	}
 
-	var withdrawsSum float64
-	switch v := result.(type) {
-	case float32:
-		withdrawsSum = float64(v)
-	case float64:
-		withdrawsSum = v
-	default:
+	return record, nil
+}
+
+func scanWithdrawalsSumFromRows(rows pgx.Rows) (money.Amount, error) {
+	if err := nextRow(rows); err != nil {
+		return 0, err
+	}
+
+	// SUM of no rows is NULL, it's scanned as zero
+	var withdrawsSum money.Amount
+	if err := rows.Scan(&withdrawsSum); err != nil {
+		return 0, fmt.Errorf("scan withdraws sum err=%w", err)
 	}
 
 	return withdrawsSum, nil
 }
 
-func prepareAddWithdrawalsQuery(order string, user string, sum float64) *query {
+// prepareAddWithdrawalsQuery saves empty idempotency key as NULL, such withdrawals are never replayed
+func prepareAddWithdrawalsQuery(order string, user string, sum money.Amount, idempotencyKey string) *query {
+	var key interface{}
+	if idempotencyKey != "" {
+		key = idempotencyKey
+	}
+
 	return &query{
 		request: addWithdrawals,
 		args: []interface{}{
 			order,
 			user,
 			sum,
-			time.Now().Format(time.RFC3339),
+			time.Now(),
+			key,
 		},
 	}
 }
 
+func prepareGetWithdrawalByIdempotencyKeyQuery(user string, idempotencyKey string) *query {
+	return &query{
+		request: getWithdrawalByIdempotencyKey,
+		args:    []interface{}{user, idempotencyKey},
+	}
+}
+
 func prepareWithdrawalsSumQuery(user string) *query {
 	return &query{
 		request: getUserWithdrawalsTotalSum,
@@ -78,11 +98,16 @@ func prepareWithdrawalsSumQuery(user string) *query {
 	}
 }
 
-func prepareGetAllUserWithdrawals(user string) *query {
+func prepareGetAllUserWithdrawals(user string, period Period) *query {
 	return &query{
 		request: getUserWithdrawals,
-		args: []interface{}{
-			user,
-		},
+		args:    append([]interface{}{user}, period.args()...),
+	}
+}
+
+func prepareDeleteUserWithdrawalsQuery(user string) *query {
+	return &query{
+		request: deleteUserWithdrawalsQuery,
+		args:    []interface{}{user},
 	}
 }
@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"context"
+	"gophermart/internal/sql"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------ Balance Adjustments -------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) AdjustBalance(_ context.Context, adjustment *sql.BalanceAdjustment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[adjustment.Login]
+	if !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	adjustment.BalanceBefore = user.Balance
+	adjustment.BalanceAfter = user.Balance + adjustment.Amount
+
+	if adjustment.BalanceAfter < 0 {
+		return sql.ErrNotEnoughFundsInTheAccount
+	}
+
+	adjustment.ID = s.nextID()
+	user.Balance = adjustment.BalanceAfter
+
+	stored := *adjustment
+	stored.CreatedAt = stored.CreatedAt.Round(time.Microsecond)
+	s.adjustments = append(s.adjustments, &stored)
+	s.record(user, sql.LedgerEntryAdjustment, strconv.FormatInt(stored.ID, 10), stored.Amount, stored.CreatedAt)
+
+	return nil
+}
+
+func (s *Storage) GetUserBalanceAdjustments(_ context.Context, login string) ([]*sql.BalanceAdjustment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.userAdjustments(login), nil
+}
+
+// userAdjustments returns copies of the user's adjustments ordered by time, the lock must be held
+func (s *Storage) userAdjustments(login string) []*sql.BalanceAdjustment {
+	adjustments := make([]*sql.BalanceAdjustment, 0)
+	for _, adjustment := range s.adjustments {
+		if adjustment.Login == login {
+			copied := *adjustment
+			adjustments = append(adjustments, &copied)
+		}
+	}
+
+	sort.SliceStable(adjustments, func(i, j int) bool {
+		return adjustments[i].CreatedAt.Before(adjustments[j].CreatedAt)
+	})
+
+	return adjustments
+}
+
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------- Account Data Methods -----------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) ExportUserData(_ context.Context, login string) (*sql.UserDataExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return nil, sql.ErrUserIsNotFound
+	}
+
+	copiedUser := *user
+	export := &sql.UserDataExport{
+		User: &copiedUser,
+		Orders: s.selectOrders(func(order *sql.Order) bool {
+			return order.User == login
+		}),
+		Withdrawals:        make([]*sql.UserWithdrawRecord, 0),
+		BalanceAdjustments: s.userAdjustments(login),
+		BalanceHistory:     s.balanceHistory(login, sql.BalanceHistoryFilter{}),
+	}
+
+	if totp, ok := s.totps[login]; ok {
+		export.SecondFactor = totp.Confirmed
+	}
+
+	for _, w := range s.withdrawals {
+		if w.user == login {
+			record := w.record
+			export.Withdrawals = append(export.Withdrawals, &record)
+			export.WithdrawalsTotalSum += record.Accrual
+		}
+	}
+
+	return export, nil
+}
+
+func (s *Storage) DeleteUser(_ context.Context, login string, anonymizedLogin string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	for _, record := range s.audit {
+		if record.Login == login {
+			record.Login = anonymizedLogin
+			record.IP = ""
+			record.UserAgent = ""
+		}
+	}
+
+	adjustments := make([]*sql.BalanceAdjustment, 0, len(s.adjustments))
+	for _, adjustment := range s.adjustments {
+		if adjustment.Login == login {
+			continue
+		}
+
+		if adjustment.Operator == login {
+			adjustment.Operator = anonymizedLogin
+		}
+
+		adjustments = append(adjustments, adjustment)
+	}
+	s.adjustments = adjustments
+
+	if entries, ok := s.ledger[login]; ok {
+		s.ledger[anonymizedLogin] = append(s.ledger[anonymizedLogin], entries...)
+		delete(s.ledger, login)
+	}
+
+	withdrawals := make([]*withdrawal, 0, len(s.withdrawals))
+	for _, w := range s.withdrawals {
+		if w.user != login {
+			withdrawals = append(withdrawals, w)
+		}
+	}
+	s.withdrawals = withdrawals
+
+	for orderID, order := range s.orders {
+		if order.User == login {
+			delete(s.orders, orderID)
+		}
+	}
+
+	s.deleteSessions(func(session *sql.Session) bool {
+		return session.Login == login
+	})
+
+	for id, key := range s.apiKeys {
+		if key.Login == login {
+			delete(s.apiKeys, id)
+		}
+	}
+
+	for token, stored := range s.resetTokens {
+		if stored.token.Login == login {
+			delete(s.resetTokens, token)
+		}
+	}
+
+	for token, challenge := range s.challenges {
+		if challenge.Login == login {
+			delete(s.challenges, token)
+		}
+	}
+
+	delete(s.totps, login)
+	delete(s.recoveryCodes, login)
+	// key of the login is "login:<login>", the same as in the auth service
+	delete(s.throttles, "login:"+login)
+	delete(s.users, login)
+
+	return nil
+}
@@ -0,0 +1,393 @@
+package memory
+
+import (
+	"context"
+	"gophermart/internal/sql"
+	"sort"
+	"time"
+)
+
+type resetToken struct {
+	token  sql.PasswordResetToken
+	usedAt *time.Time
+}
+
+// ----------------------------------------------------------------------------------------------
+// -------------------------------------- API Keys Methods --------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) CreateAPIKey(_ context.Context, key *sql.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[key.Login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	key.ID = s.nextID()
+
+	stored := *key
+	stored.CreatedAt = stored.CreatedAt.Round(time.Microsecond)
+	stored.ExpiresAt = stored.ExpiresAt.Round(time.Microsecond)
+	stored.LastUsedAt = nil
+	s.apiKeys[key.ID] = &stored
+
+	return nil
+}
+
+func (s *Storage) FindAPIKey(_ context.Context, keyHash string) (*sql.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.apiKeys {
+		if key.KeyHash == keyHash {
+			return s.apiKeyWithUser(key)
+		}
+	}
+
+	return nil, sql.ErrAPIKeyIsNotFound
+}
+
+func (s *Storage) GetUserAPIKeys(_ context.Context, login string) ([]*sql.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*sql.APIKey, 0)
+	for _, key := range s.apiKeys {
+		if key.Login != login {
+			continue
+		}
+
+		found, err := s.apiKeyWithUser(key)
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, found)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (s *Storage) TouchAPIKey(_ context.Context, id int64, lastUsedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.apiKeys[id]; ok {
+		lastUsedAt = lastUsedAt.Round(time.Microsecond)
+		key.LastUsedAt = &lastUsedAt
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteAPIKey(_ context.Context, login string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[id]
+	if !ok || key.Login != login {
+		return sql.ErrAPIKeyIsNotFound
+	}
+
+	delete(s.apiKeys, id)
+
+	return nil
+}
+
+// apiKeyWithUser returns the copy of the key with the role and the state of its user, the lock must be held
+func (s *Storage) apiKeyWithUser(key *sql.APIKey) (*sql.APIKey, error) {
+	user, ok := s.users[key.Login]
+	if !ok {
+		return nil, sql.ErrAPIKeyIsNotFound
+	}
+
+	copied := *key
+	copied.Role = user.Role
+	copied.Blocked = user.Blocked
+
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		copied.LastUsedAt = &lastUsedAt
+	}
+
+	return &copied, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------- Password Changing Methods ---------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) ChangePassword(_ context.Context, login string, passwordHash string, currentSessionID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setPasswordHash(login, passwordHash)
+	s.deleteSessions(func(session *sql.Session) bool {
+		return session.Login == login && session.ID != currentSessionID
+	})
+
+	return nil
+}
+
+func (s *Storage) CreatePasswordResetToken(_ context.Context, token *sql.PasswordResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.Login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	s.resetTokens[token.Token] = &resetToken{token: *token}
+
+	return nil
+}
+
+func (s *Storage) ResetPassword(_ context.Context, token string, passwordHash string, now time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.resetTokens[token]
+	if !ok || stored.usedAt != nil || !stored.token.ExpiresAt.After(now) {
+		return "", sql.ErrPasswordResetTokenIsNotFound
+	}
+
+	stored.usedAt = &now
+	login := stored.token.Login
+
+	s.setPasswordHash(login, passwordHash)
+	s.deleteSessions(func(session *sql.Session) bool {
+		return session.Login == login
+	})
+
+	return login, nil
+}
+
+// setPasswordHash replaces the password of the user and drops user's unused reset tokens, the lock must be held
+func (s *Storage) setPasswordHash(login string, passwordHash string) {
+	if user, ok := s.users[login]; ok {
+		user.PasswordHash = passwordHash
+		user.LegacyToken = ""
+	}
+
+	for token, stored := range s.resetTokens {
+		if stored.token.Login == login && stored.usedAt == nil {
+			delete(s.resetTokens, token)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------- Two-Factor Authentication ---------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) SaveUserTOTP(_ context.Context, login string, secret string, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	if totp, ok := s.totps[login]; ok && totp.Confirmed {
+		return sql.ErrTOTPIsAlreadyConfirmed
+	}
+
+	s.totps[login] = &sql.UserTOTP{Login: login, Secret: secret}
+
+	return nil
+}
+
+func (s *Storage) FindUserTOTP(_ context.Context, login string) (*sql.UserTOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[login]
+	if !ok {
+		return nil, sql.ErrTOTPIsNotFound
+	}
+
+	copied := *totp
+
+	return &copied, nil
+}
+
+func (s *Storage) ConfirmUserTOTP(_ context.Context, login string, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[login]
+	if !ok || totp.Confirmed {
+		return sql.ErrTOTPIsAlreadyConfirmed
+	}
+
+	totp.Confirmed = true
+	totp.LastStep = step
+
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		codes[codeHash] = false
+	}
+	s.recoveryCodes[login] = codes
+
+	return nil
+}
+
+func (s *Storage) DeleteUserTOTP(_ context.Context, login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totps, login)
+	delete(s.recoveryCodes, login)
+
+	return nil
+}
+
+func (s *Storage) UseTOTPStep(_ context.Context, login string, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[login]
+	if !ok || !totp.Confirmed || totp.LastStep >= step {
+		return sql.ErrTOTPCodeIsAlreadyUsed
+	}
+
+	totp.LastStep = step
+
+	return nil
+}
+
+func (s *Storage) UseRecoveryCode(_ context.Context, login string, codeHash string, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recoveryCodes[login][codeHash]
+	if !ok || used {
+		return sql.ErrRecoveryCodeIsNotFound
+	}
+
+	s.recoveryCodes[login][codeHash] = true
+
+	return nil
+}
+
+func (s *Storage) CreateLoginChallenge(_ context.Context, challenge *sql.LoginChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[challenge.Login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	s.challenges[challenge.Token] = &sql.LoginChallenge{
+		Token:     challenge.Token,
+		Login:     challenge.Login,
+		ExpiresAt: challenge.ExpiresAt,
+	}
+
+	return nil
+}
+
+func (s *Storage) UseLoginChallenge(_ context.Context, token string, now time.Time) (*sql.LoginChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[token]
+	if !ok || !challenge.ExpiresAt.After(now) {
+		return nil, sql.ErrLoginChallengeIsNotFound
+	}
+
+	challenge.Attempts++
+
+	return &sql.LoginChallenge{
+		Token:    token,
+		Login:    challenge.Login,
+		Attempts: challenge.Attempts,
+	}, nil
+}
+
+func (s *Storage) DeleteLoginChallenge(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.challenges, token)
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------ Login Throttling API ------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) GetLoginThrottles(_ context.Context, keys []string) ([]*sql.LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	throttles := make([]*sql.LoginThrottle, 0, len(keys))
+	for _, key := range keys {
+		if throttle, ok := s.throttles[key]; ok {
+			copied := *throttle
+			throttles = append(throttles, &copied)
+		}
+	}
+
+	return throttles, nil
+}
+
+func (s *Storage) AddLoginFailure(_ context.Context, key string, now time.Time, resetBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	throttle, ok := s.throttles[key]
+	if !ok {
+		s.throttles[key] = &sql.LoginThrottle{Key: key, Failures: 1, LastFailureAt: now, BlockedUntil: now}
+		return 1, nil
+	}
+
+	if throttle.LastFailureAt.Before(resetBefore) {
+		throttle.Failures = 1
+	} else {
+		throttle.Failures++
+	}
+	throttle.LastFailureAt = now
+
+	return throttle.Failures, nil
+}
+
+func (s *Storage) BlockLogin(_ context.Context, key string, blockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if throttle, ok := s.throttles[key]; ok && blockedUntil.After(throttle.BlockedUntil) {
+		throttle.BlockedUntil = blockedUntil
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteLoginThrottle(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.throttles, key)
+
+	return nil
+}
+
+func (s *Storage) AddAuthAuditRecord(_ context.Context, record *sql.AuthAuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *record
+	s.audit = append(s.audit, &copied)
+
+	return nil
+}
@@ -0,0 +1,615 @@
+// Package memory is the storage kept in the process memory, it's used by tests instead of postgres
+package memory
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/authservice/rbac"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type withdrawal struct {
+	user           string
+	idempotencyKey string
+	record         sql.UserWithdrawRecord
+}
+
+// Storage is safe for concurrent use, every method works under one lock like a serializable transaction
+type Storage struct {
+	mu sync.Mutex
+
+	users       map[string]*sql.User
+	orders      map[string]*sql.Order
+	withdrawals []*withdrawal
+	adjustments []*sql.BalanceAdjustment
+	// entries of users' accounts in the order of recording, the system side isn't kept
+	ledger map[string][]*sql.BalanceHistoryEntry
+
+	// sessions by token
+	sessions map[string]*sql.Session
+	apiKeys  map[int64]*sql.APIKey
+	// password reset tokens by token
+	resetTokens map[string]*resetToken
+	totps       map[string]*sql.UserTOTP
+	// recovery codes of users, the code is true when it's used
+	recoveryCodes map[string]map[string]bool
+	// login challenges by token
+	challenges map[string]*sql.LoginChallenge
+	throttles  map[string]*sql.LoginThrottle
+	audit      []*sql.AuthAuditRecord
+
+	lastID int64
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func New() *Storage {
+	return &Storage{
+		users:         make(map[string]*sql.User),
+		orders:        make(map[string]*sql.Order),
+		ledger:        make(map[string][]*sql.BalanceHistoryEntry),
+		sessions:      make(map[string]*sql.Session),
+		apiKeys:       make(map[int64]*sql.APIKey),
+		resetTokens:   make(map[string]*resetToken),
+		totps:         make(map[string]*sql.UserTOTP),
+		recoveryCodes: make(map[string]map[string]bool),
+		challenges:    make(map[string]*sql.LoginChallenge),
+		throttles:     make(map[string]*sql.LoginThrottle),
+	}
+}
+
+// ----------------------------------------------------------------------------------------------
+// ---------------------------------------- User Methods ----------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) CreateUser(_ context.Context, login string, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; ok {
+		return sql.ErrUserAlreadyExist
+	}
+
+	s.users[login] = &sql.User{
+		Login:        login,
+		PasswordHash: passwordHash,
+		Role:         string(rbac.RoleUser),
+	}
+
+	return nil
+}
+
+func (s *Storage) FindUser(_ context.Context, login string) (*sql.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return nil, sql.ErrUserIsNotFound
+	}
+
+	copied := *user
+
+	return &copied, nil
+}
+
+func (s *Storage) FindUserIgnoreCase(_ context.Context, login string) (*sql.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(user.Login, login) {
+			copied := *user
+
+			return &copied, nil
+		}
+	}
+
+	return nil, sql.ErrUserIsNotFound
+}
+
+func (s *Storage) UpdateUserPasswordHash(_ context.Context, login string, passwordHash string) error {
+	return s.updateUser(login, func(user *sql.User) {
+		user.PasswordHash = passwordHash
+		user.LegacyToken = ""
+	})
+}
+
+func (s *Storage) UpdateUserRole(_ context.Context, login string, role string) error {
+	return s.updateUser(login, func(user *sql.User) {
+		user.Role = role
+	})
+}
+
+func (s *Storage) BlockUser(_ context.Context, login string) error {
+	return s.updateUser(login, func(user *sql.User) {
+		user.Blocked = true
+		s.deleteSessions(func(session *sql.Session) bool {
+			return session.Login == login
+		})
+	})
+}
+
+func (s *Storage) UnblockUser(_ context.Context, login string) error {
+	return s.updateUser(login, func(user *sql.User) {
+		user.Blocked = false
+	})
+}
+
+func (s *Storage) updateUser(login string, update func(user *sql.User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	update(user)
+
+	return nil
+}
+
+func (s *Storage) SearchUsers(_ context.Context, login string, limit int, offset int) ([]*sql.UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	part := strings.ToLower(login)
+
+	found := make([]*sql.UserSummary, 0)
+	for _, user := range s.users {
+		if strings.Contains(strings.ToLower(user.Login), part) {
+			found = append(found, &sql.UserSummary{
+				Login:   user.Login,
+				Role:    user.Role,
+				Blocked: user.Blocked,
+				Balance: user.Balance,
+			})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Login < found[j].Login
+	})
+
+	if offset >= len(found) {
+		return make([]*sql.UserSummary, 0), nil
+	}
+
+	found = found[offset:]
+	if limit < len(found) {
+		found = found[:limit]
+	}
+
+	return found, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// --------------------------------------- Order Methods ----------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) FindOrder(_ context.Context, orderID string) (*sql.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, sql.ErrOrderIsNotFound
+	}
+
+	copied := *order
+
+	return &copied, nil
+}
+
+func (s *Storage) CreateOrder(_ context.Context, login string, orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[orderID]; ok {
+		return sql.ErrOrderAlreadyExist
+	}
+
+	if _, ok := s.users[login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	uploadedAt := now()
+
+	s.orders[orderID] = &sql.Order{
+		ID:          orderID,
+		User:        login,
+		Status:      sql.OrderStatusNew,
+		UploadedAt:  uploadedAt,
+		NextCheckAt: uploadedAt,
+	}
+
+	return nil
+}
+
+func (s *Storage) GetUserOrders(_ context.Context, login string, period sql.Period) ([]*sql.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.selectOrders(func(order *sql.Order) bool {
+		return order.User == login && inPeriod(order.UploadedAt, period)
+	}), nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------- Withdrawal Methods -------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) Withdraw(_ context.Context, login string, orderID string, amount money.Amount, idempotencyKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	if idempotencyKey != "" {
+		for _, w := range s.withdrawals {
+			if w.user != login || w.idempotencyKey != idempotencyKey {
+				continue
+			}
+
+			if w.record.OrderID != orderID || w.record.Accrual != amount {
+				return sql.ErrIdempotencyKeyIsReused
+			}
+
+			return nil
+		}
+	}
+
+	if user.Balance < amount {
+		return sql.ErrNotEnoughFundsInTheAccount
+	}
+
+	for _, w := range s.withdrawals {
+		if w.record.OrderID == orderID {
+			return sql.ErrWithdrawalAlreadyExist
+		}
+	}
+
+	processedAt := now()
+
+	user.Balance -= amount
+	s.withdrawals = append(s.withdrawals, &withdrawal{
+		user:           login,
+		idempotencyKey: idempotencyKey,
+		record: sql.UserWithdrawRecord{
+			OrderID:     orderID,
+			Accrual:     amount,
+			ProcessedAt: processedAt,
+		},
+	})
+	s.record(user, sql.LedgerEntryWithdrawal, orderID, -amount, processedAt)
+
+	return nil
+}
+
+func (s *Storage) GetUserStatistic(_ context.Context, login string) (*sql.UserStatistic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return nil, sql.ErrUserIsNotFound
+	}
+
+	statistic := &sql.UserStatistic{Balance: user.Balance}
+	for _, w := range s.withdrawals {
+		if w.user == login {
+			statistic.WithdrawalsTotalSum += w.record.Accrual
+		}
+	}
+
+	return statistic, nil
+}
+
+func (s *Storage) GetUserWithdrawals(_ context.Context, login string, period sql.Period) ([]*sql.UserWithdrawRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// withdrawals are appended in the order of processing
+	list := make([]*sql.UserWithdrawRecord, 0)
+	for _, w := range s.withdrawals {
+		if w.user == login && inPeriod(w.record.ProcessedAt, period) {
+			record := w.record
+			list = append(list, &record)
+		}
+	}
+
+	return list, nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------ Accrual Queue Methods -----------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) GetUnexecutedOrders(_ context.Context, limit int) ([]*sql.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dueOrders(now(), limit, false), nil
+}
+
+func (s *Storage) LeaseOrders(_ context.Context, owner string, limit int, ttl time.Duration) ([]*sql.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leaseTime := now()
+	expiresAt := leaseTime.Add(ttl)
+
+	orders := s.dueOrders(leaseTime, limit, true)
+	for _, order := range orders {
+		stored := s.orders[order.ID]
+		stored.LeaseOwner = &owner
+		stored.LeaseExpiresAt = &expiresAt
+
+		order.LeaseOwner = stored.LeaseOwner
+		order.LeaseExpiresAt = stored.LeaseExpiresAt
+	}
+
+	return orders, nil
+}
+
+func (s *Storage) ReleaseOrderLeases(_ context.Context, owner string, orderIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, orderID := range orderIDs {
+		if order, ok := s.orders[orderID]; ok && order.LeaseOwner != nil && *order.LeaseOwner == owner {
+			releaseLease(order)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateAccrual(_ context.Context, order *sql.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orders[order.ID]
+	if !ok {
+		return sql.ErrOrderIsNotFound
+	}
+
+	user, ok := s.users[stored.User]
+	if !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	if !stored.Status.CanBecome(order.Status) {
+		return fmt.Errorf("order=%s status=%s new status=%s, err=%w", order.ID, stored.Status, order.Status, sql.ErrIllegalOrderTransition)
+	}
+
+	accrual := order.Accrual
+	if order.Status != sql.OrderStatusProcessed {
+		accrual = 0
+	}
+
+	stored.Status = order.Status
+	stored.Accrual = accrual
+	releaseLease(stored)
+	user.Balance += accrual
+
+	if accrual != 0 {
+		s.record(user, sql.LedgerEntryAccrual, order.ID, accrual, now())
+	}
+
+	return nil
+}
+
+func (s *Storage) RecordOrderCheck(_ context.Context, check *sql.OrderCheck) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[check.OrderID]
+	if !ok || !isUnfinished(order) || order.StuckAt != nil {
+		return nil
+	}
+
+	if order.LeaseOwner != nil && *order.LeaseOwner != check.LeaseOwner {
+		return nil
+	}
+
+	releaseLease(order)
+
+	order.Attempts++
+	order.NextCheckAt = check.NextCheckAt.Round(time.Microsecond)
+	order.LastError = check.LastError
+
+	if check.Stuck {
+		stuckAt := now()
+		order.StuckAt = &stuckAt
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ------------------------------------- Stuck Orders Methods -----------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) GetStuckOrders(_ context.Context, limit int, offset int) ([]*sql.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := s.selectOrders(func(order *sql.Order) bool {
+		return isUnfinished(order) && order.StuckAt != nil
+	})
+
+	// selectOrders has already ordered the orders with the same time by id
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].StuckAt.Before(*orders[j].StuckAt)
+	})
+
+	if offset >= len(orders) {
+		return make([]*sql.Order, 0), nil
+	}
+
+	orders = orders[offset:]
+	if limit < len(orders) {
+		orders = orders[:limit]
+	}
+
+	return orders, nil
+}
+
+func (s *Storage) RetryStuckOrder(_ context.Context, orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return sql.ErrOrderIsNotFound
+	}
+
+	if !isUnfinished(order) || order.StuckAt == nil {
+		return sql.ErrOrderIsNotStuck
+	}
+
+	order.StuckAt = nil
+	order.Attempts = 0
+	order.LastError = ""
+	order.NextCheckAt = now()
+	releaseLease(order)
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------------------
+// ----------------------------------- Balance History Methods ----------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) GetBalanceHistory(_ context.Context, login string, filter sql.BalanceHistoryFilter) ([]*sql.BalanceHistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.balanceHistory(login, filter), nil
+}
+
+// balanceHistory returns copies of the user's ledger entries selected by the filter, the lock must be held
+func (s *Storage) balanceHistory(login string, filter sql.BalanceHistoryFilter) []*sql.BalanceHistoryEntry {
+	history := make([]*sql.BalanceHistoryEntry, 0)
+	skipped := 0
+	for _, entry := range s.ledger[login] {
+		if filter.Limit > 0 && len(history) == filter.Limit {
+			break
+		}
+
+		if (filter.Kind != "" && entry.Kind != filter.Kind) || !inPeriod(entry.CreatedAt, filter.Period) {
+			continue
+		}
+
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+
+		copied := *entry
+		history = append(history, &copied)
+	}
+
+	return history
+}
+
+// record adds the entry of the user's account after the balance is changed, the lock must be held
+func (s *Storage) record(user *sql.User, kind sql.LedgerEntryKind, reference string, amount money.Amount, at time.Time) {
+	s.ledger[user.Login] = append(s.ledger[user.Login], &sql.BalanceHistoryEntry{
+		Kind:      kind,
+		Reference: reference,
+		Amount:    amount,
+		Balance:   user.Balance,
+		CreatedAt: at,
+	})
+}
+
+// ----------------------------------------------------------------------------------------------
+// -------------------------------------- Internal Methods --------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+// selectOrders returns copies of the matching orders ordered by the upload time, the lock must be held
+func (s *Storage) selectOrders(match func(order *sql.Order) bool) []*sql.Order {
+	orders := make([]*sql.Order, 0)
+	for _, order := range s.orders {
+		if match(order) {
+			copied := *order
+			orders = append(orders, &copied)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].UploadedAt.Equal(orders[j].UploadedAt) {
+			return orders[i].ID < orders[j].ID
+		}
+
+		return orders[i].UploadedAt.Before(orders[j].UploadedAt)
+	})
+
+	return orders
+}
+
+// dueOrders returns copies of orders whose check is due ordered by the time of the check, the lock must be held
+func (s *Storage) dueOrders(checkTime time.Time, limit int, skipLeased bool) []*sql.Order {
+	orders := s.selectOrders(func(order *sql.Order) bool {
+		if skipLeased && order.LeaseExpiresAt != nil && order.LeaseExpiresAt.After(checkTime) {
+			return false
+		}
+
+		return isUnfinished(order) && order.StuckAt == nil && !order.NextCheckAt.After(checkTime)
+	})
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].NextCheckAt.Before(orders[j].NextCheckAt)
+	})
+
+	if limit < len(orders) {
+		orders = orders[:limit]
+	}
+
+	return orders
+}
+
+// nextID gives ids of sessions, api keys and adjustments, the lock must be held
+func (s *Storage) nextID() int64 {
+	s.lastID++
+
+	return s.lastID
+}
+
+func releaseLease(order *sql.Order) {
+	order.LeaseOwner = nil
+	order.LeaseExpiresAt = nil
+}
+
+func isUnfinished(order *sql.Order) bool {
+	return order.Status == sql.OrderStatusNew || order.Status == sql.OrderStatusProcessing
+}
+
+func inPeriod(t time.Time, period sql.Period) bool {
+	if !period.From.IsZero() && t.Before(period.From) {
+		return false
+	}
+
+	if !period.To.IsZero() && !t.Before(period.To) {
+		return false
+	}
+
+	return true
+}
+
+// now is rounded to microseconds as timestamptz of postgres
+func now() time.Time {
+	return time.Now().Round(time.Microsecond)
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"gophermart/internal/storage"
+	"gophermart/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return New()
+	})
+}
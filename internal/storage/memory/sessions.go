@@ -0,0 +1,188 @@
+package memory
+
+import (
+	"context"
+	"gophermart/internal/sql"
+	"sort"
+	"time"
+)
+
+// ----------------------------------------------------------------------------------------------
+// -------------------------------------- Session Methods ---------------------------------------
+// ----------------------------------------------------------------------------------------------
+
+func (s *Storage) CreateSession(_ context.Context, session *sql.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.Login]; !ok {
+		return sql.ErrUserIsNotFound
+	}
+
+	session.ID = s.nextID()
+
+	stored := *session
+	stored.CreatedAt = stored.CreatedAt.Round(time.Microsecond)
+	stored.ExpiresAt = stored.ExpiresAt.Round(time.Microsecond)
+	stored.LastSeen = stored.CreatedAt
+	s.sessions[session.Token] = &stored
+
+	return nil
+}
+
+func (s *Storage) FindSession(_ context.Context, token string) (*sql.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, sql.ErrSessionIsNotFound
+	}
+
+	return s.sessionWithUser(session)
+}
+
+func (s *Storage) FindSessionByID(_ context.Context, id int64) (*sql.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.ID == id {
+			return s.sessionWithUser(session)
+		}
+	}
+
+	return nil, sql.ErrSessionIsNotFound
+}
+
+func (s *Storage) GetUserSessions(_ context.Context, login string) ([]*sql.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]*sql.Session, 0)
+	for _, session := range s.sessions {
+		if session.Login != login {
+			continue
+		}
+
+		found, err := s.sessionWithUser(session)
+		if err != nil {
+			continue
+		}
+
+		sessions = append(sessions, found)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].ID < sessions[j].ID
+		}
+
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Storage) TouchSession(_ context.Context, token string, lastSeen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[token]; ok {
+		session.LastSeen = lastSeen.Round(time.Microsecond)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteSession(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+
+	return nil
+}
+
+func (s *Storage) TakeSession(_ context.Context, token string) (*sql.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, sql.ErrSessionIsNotFound
+	}
+
+	taken, err := s.sessionWithUser(session)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(s.sessions, token)
+
+	return taken, nil
+}
+
+func (s *Storage) DeleteUserSession(_ context.Context, login string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := s.deleteSessions(func(session *sql.Session) bool {
+		return session.Login == login && session.ID == id
+	})
+
+	if deleted == 0 {
+		return sql.ErrSessionIsNotFound
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteOtherSessions(_ context.Context, login string, currentID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteSessions(func(session *sql.Session) bool {
+		return session.Login == login && session.ID != currentID
+	})
+
+	return nil
+}
+
+func (s *Storage) DeleteUserSessions(_ context.Context, login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteSessions(func(session *sql.Session) bool {
+		return session.Login == login
+	})
+
+	return nil
+}
+
+// sessionWithUser returns the copy of the session with the role and the state of its user, the lock must be held
+func (s *Storage) sessionWithUser(session *sql.Session) (*sql.Session, error) {
+	user, ok := s.users[session.Login]
+	if !ok {
+		return nil, sql.ErrSessionIsNotFound
+	}
+
+	copied := *session
+	copied.Role = user.Role
+	copied.Blocked = user.Blocked
+
+	return &copied, nil
+}
+
+// deleteSessions removes the matching sessions and returns their number, the lock must be held
+func (s *Storage) deleteSessions(match func(session *sql.Session) bool) int {
+	deleted := 0
+	for token, session := range s.sessions {
+		if match(session) {
+			delete(s.sessions, token)
+			deleted++
+		}
+	}
+
+	return deleted
+}
@@ -0,0 +1,207 @@
+// Package storage describes the data the market keeps about users, their sessions, orders and withdrawals.
+//
+// sql.Controller is the postgres implementation and memory.Storage keeps everything in the process memory,
+// both pass the suite of storagetest package. Models and errors are the ones of the sql package.
+package storage
+
+import (
+	"context"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"time"
+)
+
+// Users keeps accounts, every method returns sql.ErrUserIsNotFound for the unknown login
+type Users interface {
+	// CreateUser returns sql.ErrUserAlreadyExist if the login is taken
+	CreateUser(ctx context.Context, login string, passwordHash string) error
+	FindUser(ctx context.Context, login string) (*sql.User, error)
+	FindUserIgnoreCase(ctx context.Context, login string) (*sql.User, error)
+	UpdateUserPasswordHash(ctx context.Context, login string, passwordHash string) error
+	UpdateUserRole(ctx context.Context, login string, role string) error
+	// BlockUser marks user as blocked and closes all user's sessions
+	BlockUser(ctx context.Context, login string) error
+	UnblockUser(ctx context.Context, login string) error
+	// SearchUsers finds users whose login contains the given part ignoring case, ordered by login
+	SearchUsers(ctx context.Context, login string, limit int, offset int) ([]*sql.UserSummary, error)
+}
+
+// Sessions keeps sessions of users, every found session has the role and the blocked state of its user,
+// lookups return sql.ErrSessionIsNotFound for the unknown session
+type Sessions interface {
+	// CreateSession saves the session and fills its id
+	CreateSession(ctx context.Context, session *sql.Session) error
+	FindSession(ctx context.Context, token string) (*sql.Session, error)
+	FindSessionByID(ctx context.Context, id int64) (*sql.Session, error)
+	// GetUserSessions returns sessions of the user ordered by the creation time
+	GetUserSessions(ctx context.Context, login string) ([]*sql.Session, error)
+	TouchSession(ctx context.Context, token string, lastSeen time.Time) error
+	DeleteSession(ctx context.Context, token string) error
+	// TakeSession deletes the session and returns it, so only one of concurrent callers gets the session
+	TakeSession(ctx context.Context, token string) (*sql.Session, error)
+	// DeleteUserSession returns sql.ErrSessionIsNotFound if the user has no session with the id
+	DeleteUserSession(ctx context.Context, login string, id int64) error
+	// DeleteOtherSessions removes all user's sessions except the current one
+	DeleteOtherSessions(ctx context.Context, login string, currentID int64) error
+	DeleteUserSessions(ctx context.Context, login string) error
+}
+
+// APIKeys keeps keys users give to their scripts, every found key has the role and the blocked state of its user,
+// FindAPIKey and DeleteAPIKey return sql.ErrAPIKeyIsNotFound for the unknown key
+type APIKeys interface {
+	// CreateAPIKey saves the key and fills its id
+	CreateAPIKey(ctx context.Context, key *sql.APIKey) error
+	FindAPIKey(ctx context.Context, keyHash string) (*sql.APIKey, error)
+	// GetUserAPIKeys returns keys of the user ordered by the creation time
+	GetUserAPIKeys(ctx context.Context, login string) ([]*sql.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int64, lastUsedAt time.Time) error
+	DeleteAPIKey(ctx context.Context, login string, id int64) error
+}
+
+// Passwords changes passwords of users, the unused reset tokens of the user are dropped on every change
+type Passwords interface {
+	// ChangePassword updates user's password hash and closes all user's sessions except the current one
+	ChangePassword(ctx context.Context, login string, passwordHash string, currentSessionID int64) error
+	CreatePasswordResetToken(ctx context.Context, token *sql.PasswordResetToken) error
+	// ResetPassword consumes the reset token, updates password hash of its user, closes all user's sessions
+	// and returns the user's login. sql.ErrPasswordResetTokenIsNotFound is returned for the unknown, used or expired token
+	ResetPassword(ctx context.Context, token string, passwordHash string, now time.Time) (string, error)
+}
+
+// TwoFactor keeps TOTP secrets, recovery codes and challenges of logins waiting for the second factor
+type TwoFactor interface {
+	// SaveUserTOTP saves new unconfirmed secret or replaces the previous unconfirmed one,
+	// sql.ErrTOTPIsAlreadyConfirmed is returned if the user has the confirmed one
+	SaveUserTOTP(ctx context.Context, login string, secret string, now time.Time) error
+	// FindUserTOTP returns sql.ErrTOTPIsNotFound if the user hasn't enrolled
+	FindUserTOTP(ctx context.Context, login string) (*sql.UserTOTP, error)
+	// ConfirmUserTOTP enables the second factor and replaces user's recovery codes,
+	// sql.ErrTOTPIsAlreadyConfirmed is returned if there is no unconfirmed secret
+	ConfirmUserTOTP(ctx context.Context, login string, step int64, recoveryCodeHashes []string) error
+	// DeleteUserTOTP disables the second factor together with recovery codes
+	DeleteUserTOTP(ctx context.Context, login string) error
+	// UseTOTPStep marks the code's period as used, sql.ErrTOTPCodeIsAlreadyUsed is returned for the used or older period
+	UseTOTPStep(ctx context.Context, login string, step int64) error
+	// UseRecoveryCode returns sql.ErrRecoveryCodeIsNotFound for the unknown or used code
+	UseRecoveryCode(ctx context.Context, login string, codeHash string, now time.Time) error
+	CreateLoginChallenge(ctx context.Context, challenge *sql.LoginChallenge) error
+	// UseLoginChallenge counts the attempt and returns the challenge with the number of attempts,
+	// sql.ErrLoginChallengeIsNotFound is returned for the unknown or expired challenge
+	UseLoginChallenge(ctx context.Context, token string, now time.Time) (*sql.LoginChallenge, error)
+	DeleteLoginChallenge(ctx context.Context, token string) error
+}
+
+// LoginThrottles counts failed logins by keys of logins and client addresses
+type LoginThrottles interface {
+	// GetLoginThrottles returns throttles of the known keys only
+	GetLoginThrottles(ctx context.Context, keys []string) ([]*sql.LoginThrottle, error)
+	// AddLoginFailure increments failures counter of the key and returns its new value,
+	// the counter is reset if the last failure happened before resetBefore
+	AddLoginFailure(ctx context.Context, key string, now time.Time, resetBefore time.Time) (int, error)
+	// BlockLogin blocks the known key until the time, the later block isn't shortened
+	BlockLogin(ctx context.Context, key string, blockedUntil time.Time) error
+	DeleteLoginThrottle(ctx context.Context, key string) error
+}
+
+// AuthAudit records security events, records aren't linked with users, so unknown logins are recorded too
+type AuthAudit interface {
+	AddAuthAuditRecord(ctx context.Context, record *sql.AuthAuditRecord) error
+}
+
+// Accounts gives users everything stored about them and removes it on their request
+type Accounts interface {
+	// ExportUserData returns sql.ErrUserIsNotFound for the unknown login
+	ExportUserData(ctx context.Context, login string) (*sql.UserDataExport, error)
+	// DeleteUser removes the user with all user's data, records which are needed for other users
+	// or for security audit are kept with anonymized login instead, sql.ErrUserIsNotFound is returned for the unknown login
+	DeleteUser(ctx context.Context, login string, anonymizedLogin string) error
+}
+
+// BalanceAdjustments keeps manual changes of balances made by operators
+type BalanceAdjustments interface {
+	// AdjustBalance changes user's balance on the adjustment's amount and fills the balance before and after the change,
+	// sql.ErrNotEnoughFundsInTheAccount is returned if the balance would become negative
+	AdjustBalance(ctx context.Context, adjustment *sql.BalanceAdjustment) error
+	// GetUserBalanceAdjustments returns adjustments of the user ordered by time
+	GetUserBalanceAdjustments(ctx context.Context, login string) ([]*sql.BalanceAdjustment, error)
+}
+
+// Orders keeps orders uploaded by users
+type Orders interface {
+	// FindOrder returns sql.ErrOrderIsNotFound for the unknown order
+	FindOrder(ctx context.Context, orderID string) (*sql.Order, error)
+	// CreateOrder registers NEW order, returns sql.ErrOrderAlreadyExist if it's uploaded by anyone
+	CreateOrder(ctx context.Context, login string, orderID string) error
+	// GetUserOrders returns orders uploaded in the period ordered by the upload time
+	GetUserOrders(ctx context.Context, login string, period sql.Period) ([]*sql.Order, error)
+}
+
+// Withdrawals keeps the balance of users and the points spent by them
+type Withdrawals interface {
+	// Withdraw returns sql.ErrNotEnoughFundsInTheAccount if the balance is less than the amount
+	// and sql.ErrWithdrawalAlreadyExist if the order was already paid. Concurrent withdrawals never overdraw
+	// the balance. The retry with the same non-empty idempotency key succeeds without withdrawing again,
+	// sql.ErrIdempotencyKeyIsReused is returned if the key was used for another order or amount
+	Withdraw(ctx context.Context, login string, orderID string, amount money.Amount, idempotencyKey string) error
+	GetUserStatistic(ctx context.Context, login string) (*sql.UserStatistic, error)
+	// GetUserWithdrawals returns withdrawals made in the period ordered by the processing time
+	GetUserWithdrawals(ctx context.Context, login string, period sql.Period) ([]*sql.UserWithdrawRecord, error)
+}
+
+// AccrualQueue gives orders waiting for the accrual system and saves its answers
+type AccrualQueue interface {
+	// GetUnexecutedOrders returns up to limit NEW and PROCESSING orders whose next check is due and which aren't stuck,
+	// ordered by the time of the next check. Leases aren't taken into account
+	GetUnexecutedOrders(ctx context.Context, limit int) ([]*sql.Order, error)
+	// LeaseOrders is GetUnexecutedOrders which gives orders to the owner for the ttl, orders leased by others
+	// are skipped until their leases expire, so every order is checked by one instance at a time
+	LeaseOrders(ctx context.Context, owner string, limit int, ttl time.Duration) ([]*sql.Order, error)
+	// ReleaseOrderLeases returns the owner's orders which weren't checked, leases of others are left as they are
+	ReleaseOrderLeases(ctx context.Context, owner string, orderIDs []string) error
+	// UpdateAccrual moves the order to the new status, releases its lease and credits the accrual of PROCESSED order to its user,
+	// sql.ErrIllegalOrderTransition is returned if the current status can't be followed by the new one,
+	// so the accrual is never credited twice
+	UpdateAccrual(ctx context.Context, order *sql.Order) error
+	// RecordOrderCheck counts the attempt, schedules the next check of NEW or PROCESSING order and releases its lease,
+	// finished and stuck orders and orders leased by others than check.LeaseOwner are left as they are
+	RecordOrderCheck(ctx context.Context, check *sql.OrderCheck) error
+}
+
+// StuckOrders lets the operator see the orders which aren't checked anymore and return them to the queue
+type StuckOrders interface {
+	// GetStuckOrders returns the page of unfinished stuck orders ordered by the time they got stuck
+	GetStuckOrders(ctx context.Context, limit int, offset int) ([]*sql.Order, error)
+	// RetryStuckOrder resets the attempts and schedules the check now, sql.ErrOrderIsNotFound is returned
+	// for the unknown order and sql.ErrOrderIsNotStuck if the order isn't stuck
+	RetryStuckOrder(ctx context.Context, orderID string) error
+}
+
+// BalanceHistory gives the changes of the balance recorded by accruals, withdrawals and adjustments
+type BalanceHistory interface {
+	// GetBalanceHistory returns the page of changes ordered by time, every change has the balance after it
+	GetBalanceHistory(ctx context.Context, login string, filter sql.BalanceHistoryFilter) ([]*sql.BalanceHistoryEntry, error)
+}
+
+// AuthStorage is everything the auth service keeps about users
+type AuthStorage interface {
+	Users
+	Sessions
+	APIKeys
+	Passwords
+	TwoFactor
+	LoginThrottles
+	AuthAudit
+	Accounts
+}
+
+type Storage interface {
+	AuthStorage
+	BalanceAdjustments
+	Orders
+	Withdrawals
+	AccrualQueue
+	StuckOrders
+	BalanceHistory
+}
+
+var _ Storage = (*sql.Controller)(nil)
@@ -0,0 +1,1176 @@
+// Package storagetest is the conformance suite every storage implementation must pass.
+//
+// Tests don't expect the storage to be empty, they make unique logins and order numbers,
+// so the suite may run against the shared database.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"gophermart/internal/money"
+	"gophermart/internal/sql"
+	"gophermart/internal/storage"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Run checks the storage made by newStorage, it's called for every test
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"Users", testUsers},
+		{"SearchUsers", testSearchUsers},
+		{"Sessions", testSessions},
+		{"TakeSession", testTakeSession},
+		{"APIKeys", testAPIKeys},
+		{"Passwords", testPasswords},
+		{"TwoFactor", testTwoFactor},
+		{"LoginChallenges", testLoginChallenges},
+		{"LoginThrottles", testLoginThrottles},
+		{"BalanceAdjustments", testBalanceAdjustments},
+		{"Accounts", testAccounts},
+		{"Orders", testOrders},
+		{"OrdersPeriod", testOrdersPeriod},
+		{"AccrualQueue", testAccrualQueue},
+		{"OrderTransitions", testOrderTransitions},
+		{"OrderCheckSchedule", testOrderCheckSchedule},
+		{"StuckOrders", testStuckOrders},
+		{"OrderLeases", testOrderLeases},
+		{"ConcurrentOrderLeases", testConcurrentOrderLeases},
+		{"Withdrawals", testWithdrawals},
+		{"WithdrawalsIdempotency", testWithdrawalsIdempotency},
+		{"ConcurrentWithdrawals", testConcurrentWithdrawals},
+		{"BalanceHistory", testBalanceHistory},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func testUsers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := uniqueLogin("User")
+
+	_, err := s.FindUser(ctx, login)
+	require.ErrorIs(t, err, sql.ErrUserIsNotFound)
+
+	require.NoError(t, s.CreateUser(ctx, login, "hash"))
+	require.ErrorIs(t, s.CreateUser(ctx, login, "other"), sql.ErrUserAlreadyExist)
+
+	user, err := s.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, login, user.Login)
+	require.Equal(t, "hash", user.PasswordHash)
+	require.Equal(t, "user", user.Role)
+	require.False(t, user.Blocked)
+	require.Equal(t, money.Amount(0), user.Balance)
+
+	user, err = s.FindUserIgnoreCase(ctx, strings.ToLower(login))
+	require.NoError(t, err)
+	require.Equal(t, login, user.Login)
+
+	require.NoError(t, s.UpdateUserPasswordHash(ctx, login, "new hash"))
+	require.NoError(t, s.UpdateUserRole(ctx, login, "admin"))
+	require.NoError(t, s.BlockUser(ctx, login))
+
+	user, err = s.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, "new hash", user.PasswordHash)
+	require.Equal(t, "admin", user.Role)
+	require.True(t, user.Blocked)
+
+	require.NoError(t, s.UnblockUser(ctx, login))
+
+	user, err = s.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.False(t, user.Blocked)
+
+	unknown := uniqueLogin("unknown")
+	require.ErrorIs(t, s.UpdateUserRole(ctx, unknown, "admin"), sql.ErrUserIsNotFound)
+	require.ErrorIs(t, s.BlockUser(ctx, unknown), sql.ErrUserIsNotFound)
+	require.ErrorIs(t, s.UnblockUser(ctx, unknown), sql.ErrUserIsNotFound)
+}
+
+func testSearchUsers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	part := uniqueLogin("Search")
+
+	logins := []string{part + "-c", part + "-a", part + "-b"}
+	for _, login := range logins {
+		require.NoError(t, s.CreateUser(ctx, login, "hash"))
+	}
+
+	found, err := s.SearchUsers(ctx, strings.ToLower(part), 10, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{part + "-a", part + "-b", part + "-c"}, summaryLogins(found))
+
+	found, err = s.SearchUsers(ctx, part, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{part + "-b"}, summaryLogins(found))
+
+	found, err = s.SearchUsers(ctx, part, 10, 3)
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
+
+func testSessions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	require.NoError(t, s.UpdateUserRole(ctx, login, "admin"))
+
+	_, err := s.FindSession(ctx, uniqueLogin("token"))
+	require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+
+	createdAt := time.Now().Add(-time.Hour).Round(time.Microsecond)
+	first := createSession(t, s, login, createdAt)
+	second := createSession(t, s, login, createdAt.Add(time.Minute))
+	other := createSession(t, s, createUser(t, s), createdAt)
+
+	session, err := s.FindSession(ctx, first.Token)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, session.ID)
+	require.Equal(t, login, session.Login)
+	require.Equal(t, "admin", session.Role)
+	require.False(t, session.Blocked)
+	require.True(t, createdAt.Equal(session.LastSeen))
+	require.Equal(t, "agent", session.UserAgent)
+
+	session, err = s.FindSessionByID(ctx, second.ID)
+	require.NoError(t, err)
+	require.Equal(t, second.Token, session.Token)
+	require.Equal(t, "admin", session.Role)
+
+	lastSeen := time.Now().Round(time.Microsecond)
+	require.NoError(t, s.TouchSession(ctx, first.Token, lastSeen))
+
+	sessions, err := s.GetUserSessions(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.Equal(t, first.ID, sessions[0].ID)
+	require.True(t, lastSeen.Equal(sessions[0].LastSeen))
+	require.Equal(t, second.ID, sessions[1].ID)
+
+	require.ErrorIs(t, s.DeleteUserSession(ctx, login, other.ID), sql.ErrSessionIsNotFound)
+	require.NoError(t, s.DeleteOtherSessions(ctx, login, first.ID))
+
+	sessions, err = s.GetUserSessions(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, first.ID, sessions[0].ID)
+
+	// blocking closes all user's sessions
+	require.NoError(t, s.BlockUser(ctx, login))
+
+	_, err = s.FindSession(ctx, first.Token)
+	require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+
+	_, err = s.FindSession(ctx, other.Token)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteUserSession(ctx, other.Login, other.ID))
+	require.ErrorIs(t, s.DeleteUserSession(ctx, other.Login, other.ID), sql.ErrSessionIsNotFound)
+}
+
+func testTakeSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	created := createSession(t, s, login, time.Now())
+
+	const takers = 10
+
+	taken := make(chan error, takers)
+	for i := 0; i < takers; i++ {
+		go func() {
+			session, err := s.TakeSession(ctx, created.Token)
+			if err == nil && session.ID != created.ID {
+				err = fmt.Errorf("session=%d is taken instead of=%d", session.ID, created.ID)
+			}
+
+			taken <- err
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < takers; i++ {
+		err := <-taken
+		if err == nil {
+			succeeded++
+			continue
+		}
+
+		require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+	}
+
+	require.Equal(t, 1, succeeded)
+
+	_, err := s.FindSession(ctx, created.Token)
+	require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+}
+
+func testAPIKeys(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	_, err := s.FindAPIKey(ctx, uniqueLogin("hash"))
+	require.ErrorIs(t, err, sql.ErrAPIKeyIsNotFound)
+
+	createdAt := time.Now().Add(-time.Hour).Round(time.Microsecond)
+	first := &sql.APIKey{
+		Login:     login,
+		Name:      "first",
+		KeyHash:   uniqueLogin("hash"),
+		Prefix:    "gm_1",
+		Scopes:    "orders:read",
+		CreatedAt: createdAt,
+		ExpiresAt: createdAt.Add(time.Hour * 24),
+	}
+	require.NoError(t, s.CreateAPIKey(ctx, first))
+
+	second := *first
+	second.Name = "second"
+	second.KeyHash = uniqueLogin("hash")
+	second.CreatedAt = createdAt.Add(time.Minute)
+	require.NoError(t, s.CreateAPIKey(ctx, &second))
+	require.NotEqual(t, first.ID, second.ID)
+
+	key, err := s.FindAPIKey(ctx, first.KeyHash)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, key.ID)
+	require.Equal(t, login, key.Login)
+	require.Equal(t, "orders:read", key.Scopes)
+	require.Equal(t, "user", key.Role)
+	require.False(t, key.Blocked)
+	require.Nil(t, key.LastUsedAt)
+
+	lastUsedAt := time.Now().Round(time.Microsecond)
+	require.NoError(t, s.TouchAPIKey(ctx, first.ID, lastUsedAt))
+
+	keys, err := s.GetUserAPIKeys(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, first.ID, keys[0].ID)
+	require.NotNil(t, keys[0].LastUsedAt)
+	require.True(t, lastUsedAt.Equal(*keys[0].LastUsedAt))
+	require.Equal(t, second.ID, keys[1].ID)
+
+	require.ErrorIs(t, s.DeleteAPIKey(ctx, createUser(t, s), first.ID), sql.ErrAPIKeyIsNotFound)
+	require.NoError(t, s.DeleteAPIKey(ctx, login, first.ID))
+	require.ErrorIs(t, s.DeleteAPIKey(ctx, login, first.ID), sql.ErrAPIKeyIsNotFound)
+
+	require.NoError(t, s.BlockUser(ctx, login))
+
+	key, err = s.FindAPIKey(ctx, second.KeyHash)
+	require.NoError(t, err)
+	require.True(t, key.Blocked)
+}
+
+func testPasswords(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	now := time.Now().Round(time.Microsecond)
+
+	current := createSession(t, s, login, now)
+	other := createSession(t, s, login, now)
+
+	unused := createResetToken(t, s, login, now)
+	require.NoError(t, s.ChangePassword(ctx, login, "changed hash", current.ID))
+
+	user, err := s.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, "changed hash", user.PasswordHash)
+
+	_, err = s.FindSession(ctx, current.Token)
+	require.NoError(t, err)
+	_, err = s.FindSession(ctx, other.Token)
+	require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+
+	// the change drops tokens which were sent before it
+	_, err = s.ResetPassword(ctx, unused, "reset hash", now)
+	require.ErrorIs(t, err, sql.ErrPasswordResetTokenIsNotFound)
+
+	token := createResetToken(t, s, login, now)
+
+	_, err = s.ResetPassword(ctx, token, "reset hash", now.Add(time.Hour*2))
+	require.ErrorIs(t, err, sql.ErrPasswordResetTokenIsNotFound, "expired token")
+
+	reset, err := s.ResetPassword(ctx, token, "reset hash", now)
+	require.NoError(t, err)
+	require.Equal(t, login, reset)
+
+	_, err = s.ResetPassword(ctx, token, "other hash", now)
+	require.ErrorIs(t, err, sql.ErrPasswordResetTokenIsNotFound, "used token")
+
+	user, err = s.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, "reset hash", user.PasswordHash)
+
+	_, err = s.FindSession(ctx, current.Token)
+	require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+}
+
+func testTwoFactor(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	now := time.Now()
+
+	_, err := s.FindUserTOTP(ctx, login)
+	require.ErrorIs(t, err, sql.ErrTOTPIsNotFound)
+
+	require.NoError(t, s.SaveUserTOTP(ctx, login, "first", now))
+	require.NoError(t, s.SaveUserTOTP(ctx, login, "second", now))
+
+	totp, err := s.FindUserTOTP(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, "second", totp.Secret)
+	require.False(t, totp.Confirmed)
+
+	require.ErrorIs(t, s.UseTOTPStep(ctx, login, 100), sql.ErrTOTPCodeIsAlreadyUsed, "unconfirmed secret")
+
+	require.NoError(t, s.ConfirmUserTOTP(ctx, login, 100, []string{"code-1", "code-2"}))
+	require.ErrorIs(t, s.ConfirmUserTOTP(ctx, login, 101, nil), sql.ErrTOTPIsAlreadyConfirmed)
+	require.ErrorIs(t, s.SaveUserTOTP(ctx, login, "third", now), sql.ErrTOTPIsAlreadyConfirmed)
+
+	totp, err = s.FindUserTOTP(ctx, login)
+	require.NoError(t, err)
+	require.True(t, totp.Confirmed)
+	require.Equal(t, int64(100), totp.LastStep)
+
+	require.ErrorIs(t, s.UseTOTPStep(ctx, login, 100), sql.ErrTOTPCodeIsAlreadyUsed)
+	require.NoError(t, s.UseTOTPStep(ctx, login, 102))
+	require.ErrorIs(t, s.UseTOTPStep(ctx, login, 101), sql.ErrTOTPCodeIsAlreadyUsed)
+
+	require.NoError(t, s.UseRecoveryCode(ctx, login, "code-1", now))
+	require.ErrorIs(t, s.UseRecoveryCode(ctx, login, "code-1", now), sql.ErrRecoveryCodeIsNotFound)
+	require.ErrorIs(t, s.UseRecoveryCode(ctx, login, "unknown", now), sql.ErrRecoveryCodeIsNotFound)
+
+	require.NoError(t, s.DeleteUserTOTP(ctx, login))
+
+	_, err = s.FindUserTOTP(ctx, login)
+	require.ErrorIs(t, err, sql.ErrTOTPIsNotFound)
+	require.ErrorIs(t, s.UseRecoveryCode(ctx, login, "code-2", now), sql.ErrRecoveryCodeIsNotFound)
+}
+
+func testLoginChallenges(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	now := time.Now()
+
+	challenge := &sql.LoginChallenge{
+		Token:     uniqueLogin("challenge"),
+		Login:     login,
+		ExpiresAt: now.Add(time.Minute),
+	}
+	require.NoError(t, s.CreateLoginChallenge(ctx, challenge))
+
+	used, err := s.UseLoginChallenge(ctx, challenge.Token, now)
+	require.NoError(t, err)
+	require.Equal(t, login, used.Login)
+	require.Equal(t, 1, used.Attempts)
+
+	used, err = s.UseLoginChallenge(ctx, challenge.Token, now)
+	require.NoError(t, err)
+	require.Equal(t, 2, used.Attempts)
+
+	_, err = s.UseLoginChallenge(ctx, challenge.Token, now.Add(time.Minute))
+	require.ErrorIs(t, err, sql.ErrLoginChallengeIsNotFound, "expired challenge")
+
+	require.NoError(t, s.DeleteLoginChallenge(ctx, challenge.Token))
+
+	_, err = s.UseLoginChallenge(ctx, challenge.Token, now)
+	require.ErrorIs(t, err, sql.ErrLoginChallengeIsNotFound)
+}
+
+func testLoginThrottles(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	key := uniqueLogin("login:throttled")
+	unknown := uniqueLogin("ip:unknown")
+	now := time.Now().Round(time.Microsecond)
+
+	throttles, err := s.GetLoginThrottles(ctx, []string{key, unknown})
+	require.NoError(t, err)
+	require.Empty(t, throttles)
+
+	for i := 1; i <= 3; i++ {
+		failures, err := s.AddLoginFailure(ctx, key, now, now.Add(-time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, i, failures)
+	}
+
+	blockedUntil := now.Add(time.Hour)
+	require.NoError(t, s.BlockLogin(ctx, key, blockedUntil))
+	// the later block isn't shortened
+	require.NoError(t, s.BlockLogin(ctx, key, now.Add(time.Minute)))
+	require.NoError(t, s.BlockLogin(ctx, unknown, blockedUntil))
+
+	throttles, err = s.GetLoginThrottles(ctx, []string{key, unknown})
+	require.NoError(t, err)
+	require.Len(t, throttles, 1)
+	require.Equal(t, key, throttles[0].Key)
+	require.Equal(t, 3, throttles[0].Failures)
+	require.True(t, blockedUntil.Equal(throttles[0].BlockedUntil))
+
+	// failures older than resetBefore are forgotten
+	later := now.Add(time.Hour)
+	failures, err := s.AddLoginFailure(ctx, key, later, later.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, failures)
+
+	require.NoError(t, s.DeleteLoginThrottle(ctx, key))
+
+	throttles, err = s.GetLoginThrottles(ctx, []string{key})
+	require.NoError(t, err)
+	require.Empty(t, throttles)
+
+	require.NoError(t, s.AddAuthAuditRecord(ctx, &sql.AuthAuditRecord{
+		Login:     uniqueLogin("unknown"),
+		Event:     sql.AuthEventLoginFailed,
+		CreatedAt: now,
+	}))
+}
+
+func testBalanceAdjustments(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	operator := createUser(t, s)
+	now := time.Now().Round(time.Microsecond)
+
+	bonus := &sql.BalanceAdjustment{Login: login, Operator: operator, Reason: "bonus", Amount: money.New(10, 0), CreatedAt: now}
+	require.NoError(t, s.AdjustBalance(ctx, bonus))
+	require.Equal(t, money.Amount(0), bonus.BalanceBefore)
+	require.Equal(t, money.New(10, 0), bonus.BalanceAfter)
+
+	overdraft := &sql.BalanceAdjustment{Login: login, Operator: operator, Reason: "fine", Amount: -money.New(11, 0), CreatedAt: now}
+	require.ErrorIs(t, s.AdjustBalance(ctx, overdraft), sql.ErrNotEnoughFundsInTheAccount)
+
+	debit := &sql.BalanceAdjustment{Login: login, Operator: operator, Reason: "fine", Amount: -money.New(4, 0), CreatedAt: now.Add(time.Second)}
+	require.NoError(t, s.AdjustBalance(ctx, debit))
+	require.Equal(t, money.New(6, 0), debit.BalanceAfter)
+
+	unknown := &sql.BalanceAdjustment{Login: uniqueLogin("unknown"), Operator: operator, Amount: money.New(1, 0), CreatedAt: now}
+	require.ErrorIs(t, s.AdjustBalance(ctx, unknown), sql.ErrUserIsNotFound)
+
+	adjustments, err := s.GetUserBalanceAdjustments(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, adjustments, 2)
+	require.Equal(t, bonus.ID, adjustments[0].ID)
+	require.Equal(t, "bonus", adjustments[0].Reason)
+	require.Equal(t, operator, adjustments[0].Operator)
+	require.Equal(t, debit.ID, adjustments[1].ID)
+
+	user, err := s.FindUser(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(6, 0), user.Balance)
+
+	history, err := s.GetBalanceHistory(ctx, login, sql.BalanceHistoryFilter{Kind: sql.LedgerEntryAdjustment, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, money.New(6, 0), history[1].Balance)
+}
+
+func testAccounts(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	operator := createUser(t, s)
+	now := time.Now().Round(time.Microsecond)
+
+	_, err := s.ExportUserData(ctx, uniqueLogin("unknown"))
+	require.ErrorIs(t, err, sql.ErrUserIsNotFound)
+
+	credit(t, s, login, money.New(20, 0))
+	withdrawal := uniqueOrderID()
+	require.NoError(t, s.Withdraw(ctx, login, withdrawal, money.New(5, 0), ""))
+	require.NoError(t, s.AdjustBalance(ctx, &sql.BalanceAdjustment{Login: login, Operator: operator, Amount: money.New(1, 0), CreatedAt: now}))
+	require.NoError(t, s.SaveUserTOTP(ctx, login, "secret", now))
+	require.NoError(t, s.ConfirmUserTOTP(ctx, login, 1, nil))
+
+	export, err := s.ExportUserData(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, login, export.User.Login)
+	require.Equal(t, money.New(16, 0), export.User.Balance)
+	require.Equal(t, money.New(5, 0), export.WithdrawalsTotalSum)
+	require.True(t, export.SecondFactor)
+	require.Len(t, export.Orders, 1)
+	require.Len(t, export.Withdrawals, 1)
+	require.Equal(t, withdrawal, export.Withdrawals[0].OrderID)
+	require.Len(t, export.BalanceAdjustments, 1)
+	// the adjustment is dated before the accrual, so entries are found by their kinds
+	require.Len(t, export.BalanceHistory, 3)
+	entries := make(map[sql.LedgerEntryKind]*sql.BalanceHistoryEntry)
+	for _, entry := range export.BalanceHistory {
+		entries[entry.Kind] = entry
+	}
+	require.Equal(t, money.New(20, 0), entries[sql.LedgerEntryAccrual].Amount)
+	require.Equal(t, withdrawal, entries[sql.LedgerEntryWithdrawal].Reference)
+	require.Equal(t, -money.New(5, 0), entries[sql.LedgerEntryWithdrawal].Amount)
+	require.Equal(t, money.New(1, 0), entries[sql.LedgerEntryAdjustment].Amount)
+	require.Equal(t, money.New(16, 0), export.BalanceHistory[2].Balance)
+
+	session := createSession(t, s, login, now)
+	anonymized := uniqueLogin("deleted")
+
+	require.NoError(t, s.DeleteUser(ctx, login, anonymized))
+	require.ErrorIs(t, s.DeleteUser(ctx, login, anonymized), sql.ErrUserIsNotFound)
+
+	_, err = s.FindUser(ctx, login)
+	require.ErrorIs(t, err, sql.ErrUserIsNotFound)
+
+	_, err = s.FindSession(ctx, session.Token)
+	require.ErrorIs(t, err, sql.ErrSessionIsNotFound)
+
+	_, err = s.FindOrder(ctx, export.Orders[0].ID)
+	require.ErrorIs(t, err, sql.ErrOrderIsNotFound)
+
+	// the login is free again and nothing of the deleted user is given to the new one
+	require.NoError(t, s.CreateUser(ctx, login, "hash"))
+
+	export, err = s.ExportUserData(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(0), export.User.Balance)
+	require.False(t, export.SecondFactor)
+	require.Empty(t, export.Orders)
+	require.Empty(t, export.Withdrawals)
+	require.Empty(t, export.BalanceAdjustments)
+	require.Empty(t, export.BalanceHistory)
+
+	history, err := s.GetBalanceHistory(ctx, login, sql.BalanceHistoryFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, history)
+}
+
+func testOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	other := createUser(t, s)
+
+	orderID := uniqueOrderID()
+
+	_, err := s.FindOrder(ctx, orderID)
+	require.ErrorIs(t, err, sql.ErrOrderIsNotFound)
+
+	require.NoError(t, s.CreateOrder(ctx, login, orderID))
+	require.ErrorIs(t, s.CreateOrder(ctx, login, orderID), sql.ErrOrderAlreadyExist)
+	require.ErrorIs(t, s.CreateOrder(ctx, other, orderID), sql.ErrOrderAlreadyExist)
+	require.ErrorIs(t, s.CreateOrder(ctx, uniqueLogin("unknown"), uniqueOrderID()), sql.ErrUserIsNotFound)
+
+	order, err := s.FindOrder(ctx, orderID)
+	require.NoError(t, err)
+	require.Equal(t, orderID, order.ID)
+	require.Equal(t, login, order.User)
+	require.Equal(t, sql.OrderStatusNew, order.Status)
+	require.Equal(t, money.Amount(0), order.Accrual)
+	require.WithinDuration(t, time.Now(), order.UploadedAt, time.Minute)
+
+	secondID := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, secondID))
+
+	orders, err := s.GetUserOrders(ctx, login, sql.Period{})
+	require.NoError(t, err)
+	require.Equal(t, []string{orderID, secondID}, orderIDs(orders))
+
+	orders, err = s.GetUserOrders(ctx, other, sql.Period{})
+	require.NoError(t, err)
+	require.Empty(t, orders)
+}
+
+func testOrdersPeriod(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	before := time.Now().Add(-time.Minute)
+	orderID := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, orderID))
+	after := time.Now().Add(time.Minute)
+
+	for _, tc := range []struct {
+		period   sql.Period
+		expected []string
+	}{
+		{sql.Period{From: before}, []string{orderID}},
+		{sql.Period{To: after}, []string{orderID}},
+		{sql.Period{From: before, To: after}, []string{orderID}},
+		{sql.Period{From: after}, []string{}},
+		{sql.Period{To: before}, []string{}},
+	} {
+		orders, err := s.GetUserOrders(ctx, login, tc.period)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, orderIDs(orders), "%+v", tc.period)
+	}
+}
+
+func testAccrualQueue(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	processed := uniqueOrderID()
+	processing := uniqueOrderID()
+	invalid := uniqueOrderID()
+	for _, orderID := range []string{processed, processing, invalid} {
+		require.NoError(t, s.CreateOrder(ctx, login, orderID))
+	}
+
+	require.Equal(t, []string{processed, processing, invalid}, unexecutedOrderIDs(t, s, login))
+
+	updates := []*sql.Order{
+		{ID: processed, User: login, Status: sql.OrderStatusProcessed, Accrual: money.New(729, 98)},
+		{ID: processing, User: login, Status: sql.OrderStatusProcessing},
+		{ID: invalid, User: login, Status: sql.OrderStatusInvalid},
+	}
+	for _, order := range updates {
+		require.NoError(t, s.UpdateAccrual(ctx, order))
+	}
+
+	require.Equal(t, []string{processing}, unexecutedOrderIDs(t, s, login))
+
+	orders, err := s.GetUnexecutedOrders(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	order, err := s.FindOrder(ctx, processed)
+	require.NoError(t, err)
+	require.Equal(t, sql.OrderStatusProcessed, order.Status)
+	require.Equal(t, money.New(729, 98), order.Accrual)
+
+	statistic, err := s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(729, 98), statistic.Balance)
+}
+
+func testOrderTransitions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	orderID := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, orderID))
+
+	processing := &sql.Order{ID: orderID, User: login, Status: sql.OrderStatusProcessing}
+	processed := &sql.Order{ID: orderID, User: login, Status: sql.OrderStatusProcessed, Accrual: money.New(100, 0)}
+
+	require.NoError(t, s.UpdateAccrual(ctx, processing))
+	require.ErrorIs(t, s.UpdateAccrual(ctx, processing), sql.ErrIllegalOrderTransition)
+	require.NoError(t, s.UpdateAccrual(ctx, processed))
+
+	// the repeated delivery of the processed order doesn't credit it again
+	require.ErrorIs(t, s.UpdateAccrual(ctx, processed), sql.ErrIllegalOrderTransition)
+	require.ErrorIs(t, s.UpdateAccrual(ctx, processing), sql.ErrIllegalOrderTransition)
+	require.ErrorIs(t, s.UpdateAccrual(ctx, &sql.Order{ID: orderID, User: login, Status: sql.OrderStatusInvalid}),
+		sql.ErrIllegalOrderTransition)
+
+	order, err := s.FindOrder(ctx, orderID)
+	require.NoError(t, err)
+	require.Equal(t, sql.OrderStatusProcessed, order.Status)
+	require.Equal(t, money.New(100, 0), order.Accrual)
+
+	statistic, err := s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(100, 0), statistic.Balance)
+
+	// the invalid order doesn't bring points even if the accrual is given
+	invalid := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, invalid))
+	require.NoError(t, s.UpdateAccrual(ctx, &sql.Order{ID: invalid, User: login, Status: sql.OrderStatusInvalid, Accrual: money.New(5, 0)}))
+
+	statistic, err = s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(100, 0), statistic.Balance)
+
+	// concurrent deliveries of the same order credit it once
+	concurrent := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, concurrent))
+
+	const deliveries = 5
+
+	errs := make(chan error, deliveries)
+	for i := 0; i < deliveries; i++ {
+		go func() {
+			errs <- s.UpdateAccrual(ctx, &sql.Order{ID: concurrent, User: login, Status: sql.OrderStatusProcessed, Accrual: money.New(1, 0)})
+		}()
+	}
+
+	for i := 0; i < deliveries; i++ {
+		if err := <-errs; err != nil {
+			require.ErrorIs(t, err, sql.ErrIllegalOrderTransition)
+		}
+	}
+
+	statistic, err = s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(101, 0), statistic.Balance)
+
+	require.ErrorIs(t, s.UpdateAccrual(ctx, &sql.Order{ID: uniqueOrderID(), Status: sql.OrderStatusProcessed}), sql.ErrOrderIsNotFound)
+}
+
+func testOrderCheckSchedule(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	later := uniqueOrderID()
+	due := uniqueOrderID()
+	for _, orderID := range []string{later, due} {
+		require.NoError(t, s.CreateOrder(ctx, login, orderID))
+	}
+
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{
+		OrderID:     later,
+		NextCheckAt: time.Now().Add(time.Hour),
+		LastError:   "accrual system is unavailable",
+	}))
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{OrderID: due, NextCheckAt: time.Now().Add(-time.Second)}))
+
+	require.Equal(t, []string{due}, unexecutedOrderIDs(t, s, login))
+
+	order, err := s.FindOrder(ctx, later)
+	require.NoError(t, err)
+	require.Equal(t, 1, order.Attempts)
+	require.Equal(t, "accrual system is unavailable", order.LastError)
+	require.True(t, order.NextCheckAt.After(time.Now()))
+	require.Nil(t, order.StuckAt)
+
+	// the finished order isn't rescheduled
+	require.NoError(t, s.UpdateAccrual(ctx, &sql.Order{ID: due, User: login, Status: sql.OrderStatusInvalid}))
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{OrderID: due, NextCheckAt: time.Now(), Stuck: true}))
+
+	order, err = s.FindOrder(ctx, due)
+	require.NoError(t, err)
+	require.Equal(t, 1, order.Attempts)
+	require.Nil(t, order.StuckAt)
+
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{OrderID: uniqueOrderID(), NextCheckAt: time.Now()}))
+}
+
+func testStuckOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	stuck := uniqueOrderID()
+	waiting := uniqueOrderID()
+	for _, orderID := range []string{stuck, waiting} {
+		require.NoError(t, s.CreateOrder(ctx, login, orderID))
+	}
+
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{
+		OrderID:     stuck,
+		NextCheckAt: time.Now().Add(-time.Second),
+		LastError:   "unknown accrual order status",
+		Stuck:       true,
+	}))
+
+	require.Equal(t, []string{waiting}, unexecutedOrderIDs(t, s, login))
+	require.Equal(t, []string{stuck}, stuckOrderIDs(t, s, login))
+
+	order, err := s.FindOrder(ctx, stuck)
+	require.NoError(t, err)
+	require.NotNil(t, order.StuckAt)
+	require.Equal(t, "unknown accrual order status", order.LastError)
+
+	// the stuck order isn't rescheduled by the check which was made before
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{OrderID: stuck, NextCheckAt: time.Now()}))
+	require.Equal(t, []string{stuck}, stuckOrderIDs(t, s, login))
+
+	require.ErrorIs(t, s.RetryStuckOrder(ctx, waiting), sql.ErrOrderIsNotStuck)
+	require.ErrorIs(t, s.RetryStuckOrder(ctx, uniqueOrderID()), sql.ErrOrderIsNotFound)
+
+	require.NoError(t, s.RetryStuckOrder(ctx, stuck))
+	require.ErrorIs(t, s.RetryStuckOrder(ctx, stuck), sql.ErrOrderIsNotStuck)
+
+	require.Empty(t, stuckOrderIDs(t, s, login))
+	require.ElementsMatch(t, []string{stuck, waiting}, unexecutedOrderIDs(t, s, login))
+
+	order, err = s.FindOrder(ctx, stuck)
+	require.NoError(t, err)
+	require.Zero(t, order.Attempts)
+	require.Empty(t, order.LastError)
+	require.Nil(t, order.StuckAt)
+}
+
+func testOrderLeases(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	first := uniqueOrderID()
+	second := uniqueOrderID()
+	for _, orderID := range []string{first, second} {
+		require.NoError(t, s.CreateOrder(ctx, login, orderID))
+	}
+
+	owner := uniqueLogin("instance")
+	other := uniqueLogin("instance")
+
+	require.ElementsMatch(t, []string{first, second}, leaseOrderIDs(t, s, owner, login, time.Minute))
+	require.Empty(t, leaseOrderIDs(t, s, other, login, time.Minute))
+
+	order, err := s.FindOrder(ctx, first)
+	require.NoError(t, err)
+	require.NotNil(t, order.LeaseOwner)
+	require.Equal(t, owner, *order.LeaseOwner)
+
+	// only the owner releases its leases
+	require.NoError(t, s.ReleaseOrderLeases(ctx, other, []string{first, second}))
+	require.Empty(t, leaseOrderIDs(t, s, other, login, time.Minute))
+
+	require.NoError(t, s.ReleaseOrderLeases(ctx, owner, []string{first}))
+	require.Equal(t, []string{first}, leaseOrderIDs(t, s, other, login, time.Minute))
+
+	// the check of the order leased by another instance isn't recorded
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{OrderID: first, NextCheckAt: time.Now(), LeaseOwner: owner}))
+
+	order, err = s.FindOrder(ctx, first)
+	require.NoError(t, err)
+	require.Zero(t, order.Attempts)
+	require.Equal(t, other, *order.LeaseOwner)
+
+	require.NoError(t, s.RecordOrderCheck(ctx, &sql.OrderCheck{OrderID: first, NextCheckAt: time.Now(), LeaseOwner: other}))
+
+	order, err = s.FindOrder(ctx, first)
+	require.NoError(t, err)
+	require.Equal(t, 1, order.Attempts)
+	require.Nil(t, order.LeaseOwner)
+	require.Nil(t, order.LeaseExpiresAt)
+
+	// the update releases the lease and the finished order isn't leased anymore
+	require.NoError(t, s.UpdateAccrual(ctx, &sql.Order{ID: second, User: login, Status: sql.OrderStatusInvalid}))
+
+	order, err = s.FindOrder(ctx, second)
+	require.NoError(t, err)
+	require.Nil(t, order.LeaseOwner)
+
+	require.Equal(t, []string{first}, leaseOrderIDs(t, s, owner, login, time.Millisecond))
+
+	// the expired lease of the stopped instance is taken by others
+	time.Sleep(time.Millisecond * 20)
+	require.Equal(t, []string{first}, leaseOrderIDs(t, s, other, login, time.Minute))
+	require.NoError(t, s.ReleaseOrderLeases(ctx, other, []string{first}))
+}
+
+func testConcurrentOrderLeases(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	const ordersNum = 20
+	for i := 0; i < ordersNum; i++ {
+		require.NoError(t, s.CreateOrder(ctx, login, uniqueOrderID()))
+	}
+
+	const instances = 4
+
+	type lease struct {
+		owner  string
+		orders []*sql.Order
+		err    error
+	}
+
+	leases := make(chan lease, instances)
+	for i := 0; i < instances; i++ {
+		go func() {
+			owner := uniqueLogin("instance")
+			orders, err := s.LeaseOrders(ctx, owner, math.MaxInt32, time.Minute)
+			leases <- lease{owner: owner, orders: orders, err: err}
+		}()
+	}
+
+	// every order is leased by one instance
+	all := make([]string, 0, ordersNum)
+	taken := make([]lease, 0, instances)
+	for i := 0; i < instances; i++ {
+		l := <-leases
+		require.NoError(t, l.err)
+
+		for _, order := range l.orders {
+			if order.User == login {
+				all = append(all, order.ID)
+			}
+		}
+
+		taken = append(taken, l)
+	}
+
+	for _, l := range taken {
+		require.NoError(t, s.ReleaseOrderLeases(ctx, l.owner, orderIDs(l.orders)))
+	}
+
+	require.ElementsMatch(t, unexecutedOrderIDs(t, s, login), all)
+}
+
+func testWithdrawals(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	credit(t, s, login, money.New(100, 0))
+
+	_, err := s.GetUserStatistic(ctx, uniqueLogin("unknown"))
+	require.ErrorIs(t, err, sql.ErrUserIsNotFound)
+	require.ErrorIs(t, s.Withdraw(ctx, uniqueLogin("unknown"), uniqueOrderID(), money.New(1, 0), ""), sql.ErrUserIsNotFound)
+
+	first := uniqueOrderID()
+	second := uniqueOrderID()
+
+	// 0.1 + 0.2 isn't 0.3 in float, the balance must stay exact
+	require.NoError(t, s.Withdraw(ctx, login, first, money.New(0, 10), ""))
+	require.NoError(t, s.Withdraw(ctx, login, second, money.New(0, 20), ""))
+	require.ErrorIs(t, s.Withdraw(ctx, login, first, money.New(1, 0), ""), sql.ErrWithdrawalAlreadyExist)
+	require.ErrorIs(t, s.Withdraw(ctx, login, uniqueOrderID(), money.New(100, 0), ""), sql.ErrNotEnoughFundsInTheAccount)
+
+	statistic, err := s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(99, 70), statistic.Balance)
+	require.Equal(t, money.New(0, 30), statistic.WithdrawalsTotalSum)
+
+	withdrawals, err := s.GetUserWithdrawals(ctx, login, sql.Period{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	require.Equal(t, first, withdrawals[0].OrderID)
+	require.Equal(t, money.New(0, 10), withdrawals[0].Accrual)
+	require.Equal(t, second, withdrawals[1].OrderID)
+	require.WithinDuration(t, time.Now(), withdrawals[1].ProcessedAt, time.Minute)
+
+	withdrawals, err = s.GetUserWithdrawals(ctx, login, sql.Period{From: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	require.Empty(t, withdrawals)
+
+	other := createUser(t, s)
+	statistic, err = s.GetUserStatistic(ctx, other)
+	require.NoError(t, err)
+	require.Equal(t, money.Amount(0), statistic.WithdrawalsTotalSum)
+}
+
+func testWithdrawalsIdempotency(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	credit(t, s, login, money.New(10, 0))
+
+	orderID := uniqueOrderID()
+	key := uniqueLogin("key")
+
+	// the retry returns success and doesn't spend the points again
+	require.NoError(t, s.Withdraw(ctx, login, orderID, money.New(3, 0), key))
+	require.NoError(t, s.Withdraw(ctx, login, orderID, money.New(3, 0), key))
+
+	require.ErrorIs(t, s.Withdraw(ctx, login, orderID, money.New(4, 0), key), sql.ErrIdempotencyKeyIsReused)
+	require.ErrorIs(t, s.Withdraw(ctx, login, uniqueOrderID(), money.New(3, 0), key), sql.ErrIdempotencyKeyIsReused)
+	require.ErrorIs(t, s.Withdraw(ctx, login, orderID, money.New(3, 0), uniqueLogin("key")), sql.ErrWithdrawalAlreadyExist)
+
+	// keys of different users don't interfere
+	other := createUser(t, s)
+	credit(t, s, other, money.New(10, 0))
+	require.NoError(t, s.Withdraw(ctx, other, uniqueOrderID(), money.New(1, 0), key))
+
+	statistic, err := s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(7, 0), statistic.Balance)
+	require.Equal(t, money.New(3, 0), statistic.WithdrawalsTotalSum)
+}
+
+func testConcurrentWithdrawals(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+	credit(t, s, login, money.New(10, 0))
+
+	const attempts = 10
+
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() {
+			errs <- s.Withdraw(ctx, login, uniqueOrderID(), money.New(3, 0), "")
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < attempts; i++ {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, sql.ErrNotEnoughFundsInTheAccount)
+		}
+	}
+
+	require.Equal(t, 3, succeeded)
+
+	statistic, err := s.GetUserStatistic(ctx, login)
+	require.NoError(t, err)
+	require.Equal(t, money.New(1, 0), statistic.Balance)
+	require.Equal(t, money.New(9, 0), statistic.WithdrawalsTotalSum)
+}
+
+func testBalanceHistory(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	login := createUser(t, s)
+
+	accrualOrder := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, accrualOrder))
+	require.NoError(t, s.UpdateAccrual(ctx, &sql.Order{ID: accrualOrder, User: login, Status: sql.OrderStatusProcessed, Accrual: money.New(50, 0)}))
+
+	// orders without accrual don't change the balance and aren't in the history
+	invalidOrder := uniqueOrderID()
+	require.NoError(t, s.CreateOrder(ctx, login, invalidOrder))
+	require.NoError(t, s.UpdateAccrual(ctx, &sql.Order{ID: invalidOrder, User: login, Status: sql.OrderStatusInvalid}))
+
+	firstWithdrawal := uniqueOrderID()
+	secondWithdrawal := uniqueOrderID()
+	require.NoError(t, s.Withdraw(ctx, login, firstWithdrawal, money.New(10, 50), ""))
+	require.NoError(t, s.Withdraw(ctx, login, secondWithdrawal, money.New(4, 50), ""))
+
+	history, err := s.GetBalanceHistory(ctx, login, sql.BalanceHistoryFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+
+	require.Equal(t, sql.LedgerEntryAccrual, history[0].Kind)
+	require.Equal(t, accrualOrder, history[0].Reference)
+	require.Equal(t, money.New(50, 0), history[0].Amount)
+	require.Equal(t, money.New(50, 0), history[0].Balance)
+	require.WithinDuration(t, time.Now(), history[0].CreatedAt, time.Minute)
+
+	require.Equal(t, sql.LedgerEntryWithdrawal, history[1].Kind)
+	require.Equal(t, firstWithdrawal, history[1].Reference)
+	require.Equal(t, -money.New(10, 50), history[1].Amount)
+	require.Equal(t, money.New(39, 50), history[1].Balance)
+
+	require.Equal(t, money.New(35, 0), history[2].Balance)
+
+	// the running balance is counted before the filter and the page
+	history, err = s.GetBalanceHistory(ctx, login, sql.BalanceHistoryFilter{Kind: sql.LedgerEntryWithdrawal, Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, secondWithdrawal, history[0].Reference)
+	require.Equal(t, money.New(35, 0), history[0].Balance)
+
+	history, err = s.GetBalanceHistory(ctx, login, sql.BalanceHistoryFilter{Period: sql.Period{From: time.Now().Add(time.Minute)}, Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, history)
+
+	history, err = s.GetBalanceHistory(ctx, createUser(t, s), sql.BalanceHistoryFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, history)
+}
+
+// credit gives points to the user through the accrual of the new order
+func credit(t *testing.T, s storage.Storage, login string, amount money.Amount) {
+	orderID := uniqueOrderID()
+
+	require.NoError(t, s.CreateOrder(context.Background(), login, orderID))
+	require.NoError(t, s.UpdateAccrual(context.Background(), &sql.Order{
+		ID:      orderID,
+		User:    login,
+		Status:  sql.OrderStatusProcessed,
+		Accrual: amount,
+	}))
+}
+
+func createUser(t *testing.T, s storage.Storage) string {
+	login := uniqueLogin("user")
+	require.NoError(t, s.CreateUser(context.Background(), login, "hash"))
+
+	return login
+}
+
+func createSession(t *testing.T, s storage.Storage, login string, createdAt time.Time) *sql.Session {
+	session := &sql.Session{
+		Token:     uniqueLogin("token"),
+		Login:     login,
+		CreatedAt: createdAt,
+		ExpiresAt: createdAt.Add(time.Hour * 24),
+		UserAgent: "agent",
+		IP:        "127.0.0.1",
+	}
+	require.NoError(t, s.CreateSession(context.Background(), session))
+
+	return session
+}
+
+// createResetToken saves the token which expires in an hour after now
+func createResetToken(t *testing.T, s storage.Storage, login string, now time.Time) string {
+	token := &sql.PasswordResetToken{
+		Token:     uniqueLogin("reset"),
+		Login:     login,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	require.NoError(t, s.CreatePasswordResetToken(context.Background(), token))
+
+	return token.Token
+}
+
+func unexecutedOrderIDs(t *testing.T, s storage.Storage, login string) []string {
+	// the storage may be shared, so the orders of the user can be anywhere in the queue
+	orders, err := s.GetUnexecutedOrders(context.Background(), math.MaxInt32)
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	for _, order := range orders {
+		if order.User == login {
+			ids = append(ids, order.ID)
+		}
+	}
+
+	return ids
+}
+
+// leaseOrderIDs leases all due orders and returns the user's ones, leases of other orders are released at once
+func leaseOrderIDs(t *testing.T, s storage.Storage, owner string, login string, ttl time.Duration) []string {
+	ctx := context.Background()
+
+	orders, err := s.LeaseOrders(ctx, owner, math.MaxInt32, ttl)
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	others := make([]string, 0)
+	for _, order := range orders {
+		if order.User == login {
+			ids = append(ids, order.ID)
+		} else {
+			others = append(others, order.ID)
+		}
+	}
+
+	require.NoError(t, s.ReleaseOrderLeases(ctx, owner, others))
+
+	return ids
+}
+
+func stuckOrderIDs(t *testing.T, s storage.Storage, login string) []string {
+	orders, err := s.GetStuckOrders(context.Background(), math.MaxInt32, 0)
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	for _, order := range orders {
+		if order.User == login {
+			ids = append(ids, order.ID)
+		}
+	}
+
+	return ids
+}
+
+func orderIDs(orders []*sql.Order) []string {
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+	}
+
+	return ids
+}
+
+func summaryLogins(users []*sql.UserSummary) []string {
+	logins := make([]string, 0, len(users))
+	for _, user := range users {
+		logins = append(logins, user.Login)
+	}
+
+	return logins
+}
+
+func uniqueLogin(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), rand.Int63())
+}
+
+func uniqueOrderID() string {
+	return fmt.Sprintf("%d%d", time.Now().UnixNano(), rand.Int63n(1000000))
+}